@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// The name of the config file that prism looks for in the project root.
+	configFileName = ".prism.yaml"
+
+	// The scenario used when no scenario is specified via --scenario.
+	defaultScenarioName = "default"
+)
+
+// config models the contents of a prism config file.
+type config struct {
+	// Named profiling scenarios.
+	Scenarios map[string]*scenarioConfig `yaml:"scenarios"`
+
+	// Default display settings for the print command.
+	Print *displayConfig `yaml:"print"`
+
+	// Default display settings for the diff command.
+	Diff *displayConfig `yaml:"diff"`
+
+	// The folder containing the config file. Relative paths in the
+	// config file are resolved against this folder.
+	dir string
+}
+
+// scenarioConfig defines the settings for profiling a project. Each setting
+// provides the default value for the profile command flag with the same name.
+type scenarioConfig struct {
+	Targets      []string          `yaml:"targets"`
+	VendoredPkgs []string          `yaml:"vendored-pkgs"`
+	BuildCmd     string            `yaml:"build-cmd"`
+	RunCmd       string            `yaml:"run-cmd"`
+	Env          map[string]string `yaml:"env"`
+	Label        string            `yaml:"label"`
+	ProfileDir   string            `yaml:"profile-dir"`
+	ProfileSink  string            `yaml:"profile-sink"`
+	OutputDir    string            `yaml:"output-dir"`
+}
+
+// displayConfig defines the default display settings for the print and diff
+// commands.
+type displayConfig struct {
+	Columns   []string `yaml:"display-columns"`
+	Format    string   `yaml:"display-format"`
+	Unit      string   `yaml:"display-unit"`
+	Threshold *float64 `yaml:"display-threshold"`
+}
+
+// Load the config file specified via the --config flag or, if the flag is
+// not set, look for a config file inside dir. If no config file is found,
+// loadConfig returns a nil config.
+func loadConfig(ctx *cli.Context, dir string) (*config, error) {
+	configFile := ctx.String("config")
+	if configFile == "" {
+		configFile = filepath.Join(dir, configFileName)
+		if _, err := os.Stat(configFile); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg *config
+	err = yaml.UnmarshalStrict(data, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config file %s: %s", configFile, err)
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+
+	cfg.dir, err = filepath.Abs(filepath.Dir(configFile))
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Lookup a scenario by name. If name is empty, the default scenario is
+// returned if the config defines one.
+func (cfg *config) scenario(name string) (*scenarioConfig, error) {
+	if name == "" {
+		return cfg.Scenarios[defaultScenarioName], nil
+	}
+
+	scenario, exists := cfg.Scenarios[name]
+	if !exists {
+		names := make([]string, 0, len(cfg.Scenarios))
+		for name := range cfg.Scenarios {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown scenario %q; available scenarios: %s", name, strings.Join(names, ", "))
+	}
+
+	return scenario, nil
+}
+
+// Resolve a path relative to the folder containing the config file.
+func (cfg *config) resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(cfg.dir, path)
+}
+
+// Apply the settings of a profile scenario to any flags that were not
+// explicitly specified by the user.
+func applyScenarioConfig(ctx *cli.Context, cfg *config, scenario *scenarioConfig) error {
+	envVars := make([]string, 0, len(scenario.Env))
+	for name, value := range scenario.Env {
+		envVars = append(envVars, name+"="+value)
+	}
+	sort.Strings(envVars)
+
+	settings := []struct {
+		flagNames string
+		values    []string
+	}{
+		{"profile-target, t", scenario.Targets},
+		{"profile-vendored-pkg", scenario.VendoredPkgs},
+		{"build-cmd", []string{scenario.BuildCmd}},
+		{"run-cmd", []string{scenario.RunCmd}},
+		{"env", envVars},
+		{"profile-label", []string{scenario.Label}},
+		{"profile-dir", []string{cfg.resolvePath(scenario.ProfileDir)}},
+		{"profile-sink", []string{scenario.ProfileSink}},
+		{"output-dir, o", []string{cfg.resolvePath(scenario.OutputDir)}},
+	}
+
+	for _, setting := range settings {
+		if err := setFlagDefault(ctx, setting.flagNames, setting.values...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Apply the display settings to any flags that were not explicitly specified
+// by the user.
+func applyDisplayConfig(ctx *cli.Context, display *displayConfig) error {
+	var threshold string
+	if display.Threshold != nil {
+		threshold = strconv.FormatFloat(*display.Threshold, 'f', -1, 64)
+	}
+
+	settings := []struct {
+		flagNames string
+		value     string
+	}{
+		{"display-columns, dc", strings.Join(display.Columns, ",")},
+		{"display-format, df", display.Format},
+		{"display-unit, du", display.Unit},
+		{"display-threshold", threshold},
+	}
+
+	for _, setting := range settings {
+		if err := setFlagDefault(ctx, setting.flagNames, setting.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load the config file for the print and diff commands from the current
+// folder and apply the display settings returned by selectFn.
+func applyDisplayDefaults(ctx *cli.Context, selectFn func(cfg *config) *displayConfig) error {
+	cfg, err := loadConfig(ctx, ".")
+	if err != nil || cfg == nil {
+		return err
+	}
+
+	if display := selectFn(cfg); display != nil {
+		return applyDisplayConfig(ctx, display)
+	}
+
+	return nil
+}
+
+// Set the value of a flag unless the user has explicitly specified it using
+// any of its comma-delimited names. Empty values are ignored. Multiple values
+// can be specified for slice flags.
+func setFlagDefault(ctx *cli.Context, flagNames string, values ...string) error {
+	names := strings.Split(flagNames, ",")
+	for index, name := range names {
+		names[index] = strings.TrimSpace(name)
+		if ctx.IsSet(names[index]) {
+			return nil
+		}
+	}
+
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+
+		if err := ctx.Set(names[0], value); err != nil {
+			return fmt.Errorf("could not apply config value %q to flag %q: %s", value, names[0], err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+const mockConfig = `
+scenarios:
+  default:
+    targets:
+      - github.com/foo/bar/main
+  checkout:
+    targets:
+      - github.com/foo/bar/Checkout
+      - github.com/foo/bar/Cart.*
+    vendored-pkgs:
+      - github.com/acme/.*
+    build-cmd: make build
+    run-cmd: ./bin/server
+    env:
+      PORT: "8080"
+      DEBUG: "1"
+    label: checkout
+    profile-dir: profiles
+    output-dir: /tmp/prism
+print:
+  display-columns: [total, mean]
+  display-unit: us
+diff:
+  display-columns: [total, max]
+  display-threshold: 1.5
+`
+
+func TestLoadConfig(t *testing.T) {
+	cfgDir := mockConfigDir(t, mockConfig)
+	defer os.RemoveAll(cfgDir)
+
+	ctx := cli.NewContext(nil, flag.NewFlagSet("test", 0), nil)
+	cfg, err := loadConfig(ctx, cfgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg == nil {
+		t.Fatal("expected config to be loaded")
+	}
+
+	scenario, err := cfg.scenario("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario == nil || len(scenario.Targets) != 1 {
+		t.Fatalf("expected to get back the default scenario; got %+v", scenario)
+	}
+
+	scenario, err = cfg.scenario("checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scenario.BuildCmd != "make build" {
+		t.Errorf("expected scenario build-cmd to be %q; got %q", "make build", scenario.BuildCmd)
+	}
+
+	_, err = cfg.scenario("missing")
+	expError := `unknown scenario "missing"; available scenarios: checkout, default`
+	if err == nil || err.Error() != expError {
+		t.Errorf("expected to get error %q; got %v", expError, err)
+	}
+
+	expPath := filepath.Join(cfg.dir, "profiles")
+	if path := cfg.resolvePath("profiles"); path != expPath {
+		t.Errorf("expected relative path to be resolved to %q; got %q", expPath, path)
+	}
+	if path := cfg.resolvePath("/tmp/prism"); path != "/tmp/prism" {
+		t.Errorf("expected absolute path not to be modified; got %q", path)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	// Missing config files should be ignored unless explicitly specified
+	emptyDir := mockConfigDir(t, "")
+	defer os.RemoveAll(emptyDir)
+	os.Remove(filepath.Join(emptyDir, configFileName))
+
+	set := flag.NewFlagSet("test", 0)
+	set.String("config", "", "")
+	ctx := cli.NewContext(nil, set, nil)
+	cfg, err := loadConfig(ctx, emptyDir)
+	if err != nil || cfg != nil {
+		t.Fatalf("expected to get a nil config and no error; got %v, %v", cfg, err)
+	}
+
+	set.Parse([]string{"-config", filepath.Join(emptyDir, "missing.yaml")})
+	_, err = loadConfig(ctx, emptyDir)
+	if err == nil {
+		t.Fatal("expected to get an error for a missing config file")
+	}
+
+	// Unknown keys should be reported
+	cfgDir := mockConfigDir(t, "scenarios:\n  default:\n    build_cmd: make\n")
+	defer os.RemoveAll(cfgDir)
+
+	ctx = cli.NewContext(nil, flag.NewFlagSet("test", 0), nil)
+	_, err = loadConfig(ctx, cfgDir)
+	if err == nil || !strings.Contains(err.Error(), "could not parse config file") {
+		t.Fatalf("expected to get a config parse error; got %v", err)
+	}
+}
+
+func TestApplyScenarioConfig(t *testing.T) {
+	cfgDir := mockConfigDir(t, mockConfig)
+	defer os.RemoveAll(cfgDir)
+
+	set := flag.NewFlagSet("test", 0)
+	set.String("build-cmd", "", "")
+	set.String("run-cmd", "go run *.go", "")
+	set.String("profile-label", "", "")
+	set.String("profile-dir", "", "")
+	set.String("output-dir", "", "")
+	set.String("o", "", "")
+	for _, name := range []string{"profile-target", "profile-vendored-pkg", "env"} {
+		sliceFlag := &cli.StringSliceFlag{Name: name, Value: &cli.StringSlice{}}
+		sliceFlag.Apply(set)
+	}
+
+	// Flags specified by the user should not be overridden by the config
+	set.Parse([]string{"-build-cmd", "go build", "-o", "/tmp/out"})
+	ctx := cli.NewContext(nil, set, nil)
+
+	cfg, err := loadConfig(ctx, cfgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scenario, err := cfg.scenario("checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyScenarioConfig(ctx, cfg, scenario)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stringSpecs := []struct {
+		Flag     string
+		ExpValue string
+	}{
+		{"build-cmd", "go build"},
+		{"run-cmd", "./bin/server"},
+		{"profile-label", "checkout"},
+		{"profile-dir", filepath.Join(cfg.dir, "profiles")},
+		{"output-dir", ""},
+	}
+	for specIndex, spec := range stringSpecs {
+		if value := ctx.String(spec.Flag); value != spec.ExpValue {
+			t.Errorf("[spec %d] expected flag %q to be %q; got %q", specIndex, spec.Flag, spec.ExpValue, value)
+		}
+	}
+
+	sliceSpecs := []struct {
+		Flag      string
+		ExpValues []string
+	}{
+		{"profile-target", []string{"github.com/foo/bar/Checkout", "github.com/foo/bar/Cart.*"}},
+		{"profile-vendored-pkg", []string{"github.com/acme/.*"}},
+		{"env", []string{"DEBUG=1", "PORT=8080"}},
+	}
+	for specIndex, spec := range sliceSpecs {
+		values := ctx.StringSlice(spec.Flag)
+		if strings.Join(values, " ") != strings.Join(spec.ExpValues, " ") {
+			t.Errorf("[spec %d] expected flag %q to be %v; got %v", specIndex, spec.Flag, spec.ExpValues, values)
+		}
+	}
+}
+
+func TestApplyDisplayConfig(t *testing.T) {
+	cfgDir := mockConfigDir(t, mockConfig)
+	defer os.RemoveAll(cfgDir)
+
+	set := flag.NewFlagSet("test", 0)
+	set.String("display-columns", "total,min,mean,max,invocations", "")
+	set.String("display-unit", "ms", "")
+	set.String("du", "ms", "")
+	set.Float64("display-threshold", 0.0, "")
+	set.Parse([]string{"-du", "ns"})
+	ctx := cli.NewContext(nil, set, nil)
+
+	cfg, err := loadConfig(ctx, cfgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyDisplayConfig(ctx, cfg.Diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value := ctx.String("display-columns"); value != "total,max" {
+		t.Errorf("expected display-columns to be %q; got %q", "total,max", value)
+	}
+	if value := ctx.String("display-unit"); value != "ms" {
+		t.Errorf("expected display-unit not to be overridden; got %q", value)
+	}
+	if value := ctx.Float64("display-threshold"); value != 1.5 {
+		t.Errorf("expected display-threshold to be %f; got %f", 1.5, value)
+	}
+}
+
+func mockConfigDir(t *testing.T, contents string) string {
+	cfgDir, err := ioutil.TempDir("", "prism-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(cfgDir, configFileName), []byte(contents), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(cfgDir)
+		t.Fatal(err)
+	}
+
+	return cfgDir
+}
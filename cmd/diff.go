@@ -60,6 +60,12 @@ func DiffProfiles(ctx *cli.Context) error {
 		return errNotEnoughProfiles
 	}
 
+	// Apply display defaults from the config file
+	err = applyDisplayDefaults(ctx, func(cfg *config) *displayConfig { return cfg.Diff })
+	if err != nil {
+		return err
+	}
+
 	dp := &diffPrinter{}
 
 	dp.unit, err = parseDisplayUnit(ctx.String("display-unit"))
@@ -308,8 +314,39 @@ func (dp *diffPrinter) detectTimeUnit(correlations []*correlatedMetrics) display
 					val = metrics.P90Time
 				case tableColP99:
 					val = metrics.P99Time
+				case tableColWait:
+					val = metrics.WaitTime
+				case tableColSelf:
+					val = metrics.SelfTime
+				case tableColSelfMin:
+					val = metrics.MinSelfTime
+				case tableColSelfMax:
+					val = metrics.MaxSelfTime
+				case tableColSelfMean:
+					val = metrics.MeanSelfTime
+				case tableColSelfMedian:
+					val = metrics.MedianSelfTime
+				case tableColSelfP50:
+					val = metrics.P50SelfTime
+				case tableColSelfP75:
+					val = metrics.P75SelfTime
+				case tableColSelfP90:
+					val = metrics.P90SelfTime
+				case tableColSelfP99:
+					val = metrics.P99SelfTime
+				case tableColCPU:
+					val = metrics.CPUTime
+				case tableColCPUMean:
+					val = metrics.MeanCPUTime
+				case tableColOffCPU:
+					val = metrics.OffCPUTime
+				case tableColOffCPUMean:
+					val = metrics.MeanOffCPUTime
 				default:
-					continue
+					if !dType.IsPercentile() {
+						continue
+					}
+					val, _ = dType.PercentileValue(metrics)
 				}
 
 				dUnit := detectTimeUnit(val)
@@ -333,9 +370,21 @@ func (dp *diffPrinter) fmtDiff(baseLine, candidate *profiler.CallMetrics, metric
 		return ""
 	}
 
+	// Allocation metrics are not affected by the display unit and threshold
+	if metricType.IsAlloc() {
+		baseVal, candVal := metricType.AllocValue(baseLine), metricType.AllocValue(candidate)
+		return dp.fmtComparison(float64(baseVal), float64(candVal), candidate == baseLine, metricType.FormatAlloc(candVal), 0)
+	}
+
 	switch metricType {
 	case tableColInvocations:
 		return fmt.Sprintf("%d", candidate.Invocations)
+	case tableColPanics:
+		return fmt.Sprintf("%d", candidate.Panics)
+	case tableColErrors:
+		return fmt.Sprintf("%d", candidate.Errors)
+	case tableColErrorRate:
+		return fmt.Sprintf("%2.1f%%", 100.0*candidate.ErrorRate)
 	case tableColStdDev:
 		return fmt.Sprintf("%3.3f", candidate.StdDev)
 	case tableColTotal:
@@ -365,26 +414,89 @@ func (dp *diffPrinter) fmtDiff(baseLine, candidate *profiler.CallMetrics, metric
 	case tableColP99:
 		baseVal = baseLine.P99Time
 		candVal = candidate.P99Time
+	case tableColWait:
+		// Wait time is only tracked for async calls
+		if !candidate.Async {
+			return ""
+		}
+		baseVal = baseLine.WaitTime
+		candVal = candidate.WaitTime
+	case tableColSelf:
+		baseVal = baseLine.SelfTime
+		candVal = candidate.SelfTime
+	case tableColSelfMin:
+		baseVal = baseLine.MinSelfTime
+		candVal = candidate.MinSelfTime
+	case tableColSelfMax:
+		baseVal = baseLine.MaxSelfTime
+		candVal = candidate.MaxSelfTime
+	case tableColSelfMean:
+		baseVal = baseLine.MeanSelfTime
+		candVal = candidate.MeanSelfTime
+	case tableColSelfMedian:
+		baseVal = baseLine.MedianSelfTime
+		candVal = candidate.MedianSelfTime
+	case tableColSelfP50:
+		baseVal = baseLine.P50SelfTime
+		candVal = candidate.P50SelfTime
+	case tableColSelfP75:
+		baseVal = baseLine.P75SelfTime
+		candVal = candidate.P75SelfTime
+	case tableColSelfP90:
+		baseVal = baseLine.P90SelfTime
+		candVal = candidate.P90SelfTime
+	case tableColSelfP99:
+		baseVal = baseLine.P99SelfTime
+		candVal = candidate.P99SelfTime
+	case tableColCPU:
+		baseVal = baseLine.CPUTime
+		candVal = candidate.CPUTime
+	case tableColCPUMean:
+		baseVal = baseLine.MeanCPUTime
+		candVal = candidate.MeanCPUTime
+	case tableColOffCPU:
+		baseVal = baseLine.OffCPUTime
+		candVal = candidate.OffCPUTime
+	case tableColOffCPUMean:
+		baseVal = baseLine.MeanOffCPUTime
+		candVal = candidate.MeanOffCPUTime
+	default:
+		if metricType.IsPercentile() {
+			// Percentiles can only be estimated if the profile includes
+			// time histograms
+			var tracked bool
+			if candVal, tracked = metricType.PercentileValue(candidate); !tracked {
+				return ""
+			}
+			baseVal, _ = metricType.PercentileValue(baseLine)
+		}
 	}
 
 	// Convert value to the appropriate unit
 	baseTime := dp.unit.Convert(baseVal)
 	candTime := dp.unit.Convert(candVal)
 
-	if candidate == baseLine {
-		return dp.unit.Format(candTime)
+	return dp.fmtComparison(baseTime, candTime, candidate == baseLine, dp.unit.Format(candTime), dp.clipThreshold)
+}
+
+// Colorize and format the comparison of a candidate value to a baseline value.
+// The fmtCandVal argument contains the formatted candidate value. If the
+// candidate is the baseline, only the formatted value is returned.
+func (dp *diffPrinter) fmtComparison(baseVal, candVal float64, isBaseline bool, fmtCandVal string, clipThreshold float64) string {
+	if isBaseline {
+		return fmtCandVal
 	}
 
 	var diffFactor float64
-	if baseTime == 0 || candTime == 0 {
-		return fmt.Sprintf("%s (--)", dp.unit.Format(candTime))
+	if baseVal == 0 || candVal == 0 {
+		return fmt.Sprintf("%s (--)", fmtCandVal)
 	}
 
-	delta := candTime - baseTime
+	delta := candVal - baseVal
 	if delta < 0 {
-		diffFactor = 100.0 * (baseTime - candTime) / candTime
+		diffFactor = 100.0 * (baseVal - candVal) / candVal
 	} else if delta > 0 {
-		diffFactor = 100.0 * (candTime - baseTime) / baseTime
+		diffFactor = 100.0 * (candVal - baseVal) / baseVal
 	}
 
 	if diffFactor < approxEqualEpsilon {
@@ -392,7 +504,7 @@ func (dp *diffPrinter) fmtDiff(baseLine, candidate *profiler.CallMetrics, metric
 	}
 
 	if diffFactor == 0.0 {
-		return fmt.Sprintf("%s (%s%c%s)", dp.unit.Format(candTime), cYellow, approxEqualSymbol, cReset)
+		return fmt.Sprintf("%s (%s%c%s)", fmtCandVal, cYellow, approxEqualSymbol, cReset)
 	}
 
 	var symbol rune
@@ -405,8 +517,8 @@ func (dp *diffPrinter) fmtDiff(baseLine, candidate *profiler.CallMetrics, metric
 		symbol = greaterThanSymbol
 	}
 
-	if math.Abs(delta) < dp.clipThreshold {
-		return fmt.Sprintf("%s (--)", dp.unit.Format(candTime))
+	if math.Abs(delta) < clipThreshold {
+		return fmt.Sprintf("%s (--)", fmtCandVal)
 	}
-	return fmt.Sprintf("%s (%s%c %2.1f%%%s)", dp.unit.Format(candTime), color, symbol, diffFactor, cReset)
+	return fmt.Sprintf("%s (%s%c %2.1f%%%s)", fmtCandVal, color, symbol, diffFactor, cReset)
 }
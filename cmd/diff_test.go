@@ -125,14 +125,15 @@ func TestDiffWithProfileLabel(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-|            | With Label - baseline                                                                                                                                                   | With Label                                                                                                                                                                                                                                                                 |
-+------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-| call stack |          total |            min |            max |           mean |         median | invoc |            p50 |            p75 |            p90 |            p99 | stddev |                     total |                       min |                       max |                      mean |                    median | invoc |                       p50 |                       p75 |                       p90 |                       p99 | stddev |
-+------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+
-| - main     | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |     1 | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |  0.000 | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |     1 | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |  0.000 |
-| | + foo    | 120,000,000 ns |  10,000,000 ns | 110,000,000 ns |  60,000,000 ns |  60,000,000 ns |     2 |  10,000,000 ns |  10,000,000 ns |  10,000,000 ns | 120,000,000 ns | 70.711 | 10,000,000 ns (↓ 1100.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1733.3%) |  5,000,000 ns (↓ 1100.0%) |  5,000,000 ns (↓ 1100.0%) |     2 |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1900.0%) |  1.414 |
-+------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+
+	expOutput := `+---------------+---------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+|               | With Label - baseline                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                 | With Label                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                   |
++---------------+---------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| call stack    |          total |            min |            max |           mean |         median | invoc |            p50 |            p75 |            p90 |            p99 | stddev |          wait |           self |      self min |       self max |     self mean |   self median |      self p50 |      self p75 |      self p90 |       self p99 | panics | errors | error rate |   bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |           cpu |      cpu mean |       off-cpu |  off-cpu mean |                     total |                       min |                       max |                      mean |                    median | invoc |                       p50 |                       p75 |                       p90 |                       p99 | stddev |                     wait |                      self |                self min |                 self max |                self mean |              self median |                self p50 |                self p75 |                self p90 |                 self p99 | panics | errors | error rate |              bytes |          bytes min |          bytes max |         bytes mean |       bytes median |          bytes p50 |          bytes p75 |          bytes p90 |          bytes p99 |       objects |   objects min |   objects max |  objects mean | objects median |   objects p50 |   objects p75 |   objects p90 |   objects p99 |                      cpu |                 cpu mean |                 off-cpu |            off-cpu mean |
++---------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------+----------------+---------------+----------------+---------------+---------------+---------------+---------------+---------------+----------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------------+---------------+---------------+---------------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+--------------------------+---------------------------+-------------------------+--------------------------+--------------------------+--------------------------+-------------------------+-------------------------+-------------------------+--------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+--------------------------+--------------------------+-------------------------+-------------------------+
+| - main        | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |     1 | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |  0.000 |               |           0 ns |          0 ns |           0 ns |          0 ns |          0 ns |          0 ns |          0 ns |          0 ns |           0 ns |      0 |      0 |       0.0% | 4.0 KiB |   4.0 KiB |   4.0 KiB |    4.0 KiB |      4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |      40 |          40 |          40 |           40 |             40 |          40 |          40 |          40 |          40 | 80,000,000 ns | 80,000,000 ns | 40,000,000 ns | 40,000,000 ns | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |     1 | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |  0.000 |                          |          0 ns        (--) |         0 ns       (--) |         0 ns        (--) |         0 ns        (--) |         0 ns        (--) |         0 ns       (--) |         0 ns       (--) |         0 ns       (--) |         0 ns        (--) |      0 |      0 |       0.0% | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) |  20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 4,000,000 ns (↓ 1900.0%) | 4,000,000 ns (↓ 1900.0%) | 6,000,000 ns (↓ 566.7%) | 6,000,000 ns (↓ 566.7%) |
+| | + foo       | 120,000,000 ns |  10,000,000 ns | 110,000,000 ns |  60,000,000 ns |  60,000,000 ns |     2 |  10,000,000 ns |  10,000,000 ns |  10,000,000 ns | 120,000,000 ns | 70.711 |               | 120,000,000 ns | 10,000,000 ns | 110,000,000 ns | 60,000,000 ns | 60,000,000 ns | 10,000,000 ns | 10,000,000 ns | 10,000,000 ns | 120,000,000 ns |      0 |      0 |       0.0% | 3.0 KiB |   1.0 KiB |   2.0 KiB |    1.5 KiB |      1.5 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |   2.0 KiB |      30 |          10 |          20 |           15 |             15 |          10 |          10 |          10 |          20 | 90,000,000 ns | 45,000,000 ns | 30,000,000 ns | 15,000,000 ns | 10,000,000 ns (↓ 1100.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1733.3%) |  5,000,000 ns (↓ 1100.0%) |  5,000,000 ns (↓ 1100.0%) |     2 |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1900.0%) |  1.414 |                          | 10,000,000 ns (↓ 1100.0%) | 4,000,000 ns (↓ 150.0%) | 6,000,000 ns (↓ 1733.3%) | 5,000,000 ns (↓ 1100.0%) | 5,000,000 ns (↓ 1100.0%) | 4,000,000 ns (↓ 150.0%) | 4,000,000 ns (↓ 150.0%) | 4,000,000 ns (↓ 150.0%) | 6,000,000 ns (↓ 1900.0%) |      1 |      1 |      50.0% | 2.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 20  (↓ 50.0%) | 10        (≈) | 10 (↓ 100.0%) | 10  (↓ 50.0%) |  10  (↓ 50.0%) | 10        (≈) | 10        (≈) | 10        (≈) | 10 (↓ 100.0%) | 6,000,000 ns (↓ 1400.0%) | 3,000,000 ns (↓ 1400.0%) | 4,000,000 ns (↓ 650.0%) | 2,000,000 ns (↓ 650.0%) |
+| | + go worker |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |     1 |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  0.000 | 30,000,000 ns |  50,000,000 ns | 50,000,000 ns |  50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns |  50,000,000 ns |      0 |      0 |       0.0% |     0 B |       0 B |       0 B |        0 B |          0 B |       0 B |       0 B |       0 B |       0 B |       0 |           0 |           0 |            0 |              0 |           0 |           0 |           0 |           0 |          0 ns |          0 ns |          0 ns |          0 ns |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |     1 |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  0.000 | 2,000,000 ns (↓ 1400.0%) |  8,000,000 ns  (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) |      0 |      0 |       0.0% |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |   0       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |         0 ns        (--) |         0 ns        (--) |         0 ns       (--) |         0 ns       (--) |
++---------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------+----------------+---------------+----------------+---------------+---------------+---------------+---------------+---------------+----------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------------+---------------+---------------+---------------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+--------------------------+---------------------------+-------------------------+--------------------------+--------------------------+--------------------------+-------------------------+-------------------------+-------------------------+--------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+--------------------------+--------------------------+-------------------------+-------------------------+
 `
 
 	if expOutput != output {
@@ -175,14 +176,15 @@ func TestDiffWithProfileLabelAndAutoUnitDetection(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+------------+----------------------------------------------------------------------------------------------------------------------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-|            | With Label - baseline                                                                                                      | With Label                                                                                                                                                                                                                    |
-+------------+----------------------------------------------------------------------------------------------------------------------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-| call stack |     total |       min |       max |      mean |    median | invoc |       p50 |       p75 |       p90 |       p99 | stddev |                total |                  min |                  max |                 mean |               median | invoc |                  p50 |                  p75 |                  p90 |                  p99 | stddev |
-+------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+----------------------+----------------------+----------------------+----------------------+----------------------+-------+----------------------+----------------------+----------------------+----------------------+--------+
-| - main     | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |     1 | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |  0.000 | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) |     1 | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) | 10.00 ms (↓ 1100.0%) |  0.000 |
-| | + foo    | 120.00 ms |  10.00 ms | 110.00 ms |  60.00 ms |  60.00 ms |     2 |  10.00 ms |  10.00 ms |  10.00 ms | 120.00 ms | 70.711 | 10.00 ms (↓ 1100.0%) |  4.00 ms        (--) |  6.00 ms (↓ 1733.3%) |  5.00 ms (↓ 1100.0%) |  5.00 ms (↓ 1100.0%) |     2 |  4.00 ms        (--) |  4.00 ms        (--) |  4.00 ms        (--) |  6.00 ms (↓ 1900.0%) |  1.414 |
-+------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+----------------------+----------------------+----------------------+----------------------+----------------------+-------+----------------------+----------------------+----------------------+----------------------+--------+
+	expOutput := `+---------------+---------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+|               | With Label - baseline                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                 | With Label                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                   |
++---------------+---------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| call stack    |          total |            min |            max |           mean |         median | invoc |            p50 |            p75 |            p90 |            p99 | stddev |          wait |           self |      self min |       self max |     self mean |   self median |      self p50 |      self p75 |      self p90 |       self p99 | panics | errors | error rate |   bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |           cpu |      cpu mean |       off-cpu |  off-cpu mean |                     total |                       min |                       max |                      mean |                    median | invoc |                       p50 |                       p75 |                       p90 |                       p99 | stddev |                     wait |                      self |                self min |                 self max |                self mean |              self median |                self p50 |                self p75 |                self p90 |                 self p99 | panics | errors | error rate |              bytes |          bytes min |          bytes max |         bytes mean |       bytes median |          bytes p50 |          bytes p75 |          bytes p90 |          bytes p99 |       objects |   objects min |   objects max |  objects mean | objects median |   objects p50 |   objects p75 |   objects p90 |   objects p99 |                      cpu |                 cpu mean |                 off-cpu |            off-cpu mean |
++---------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------+----------------+---------------+----------------+---------------+---------------+---------------+---------------+---------------+----------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------------+---------------+---------------+---------------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+--------------------------+---------------------------+-------------------------+--------------------------+--------------------------+--------------------------+-------------------------+-------------------------+-------------------------+--------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+--------------------------+--------------------------+-------------------------+-------------------------+
+| - main        | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |     1 | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns | 120,000,000 ns |  0.000 |               |           0 ns |          0 ns |           0 ns |          0 ns |          0 ns |          0 ns |          0 ns |          0 ns |           0 ns |      0 |      0 |       0.0% | 4.0 KiB |   4.0 KiB |   4.0 KiB |    4.0 KiB |      4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |      40 |          40 |          40 |           40 |             40 |          40 |          40 |          40 |          40 | 80,000,000 ns | 80,000,000 ns | 40,000,000 ns | 40,000,000 ns | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |     1 | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) | 10,000,000 ns (↓ 1100.0%) |  0.000 |                          |          0 ns        (--) |         0 ns       (--) |         0 ns        (--) |         0 ns        (--) |         0 ns        (--) |         0 ns       (--) |         0 ns       (--) |         0 ns       (--) |         0 ns        (--) |      0 |      0 |       0.0% | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) |  20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 4,000,000 ns (↓ 1900.0%) | 4,000,000 ns (↓ 1900.0%) | 6,000,000 ns (↓ 566.7%) | 6,000,000 ns (↓ 566.7%) |
+| | + foo       | 120,000,000 ns |  10,000,000 ns | 110,000,000 ns |  60,000,000 ns |  60,000,000 ns |     2 |  10,000,000 ns |  10,000,000 ns |  10,000,000 ns | 120,000,000 ns | 70.711 |               | 120,000,000 ns | 10,000,000 ns | 110,000,000 ns | 60,000,000 ns | 60,000,000 ns | 10,000,000 ns | 10,000,000 ns | 10,000,000 ns | 120,000,000 ns |      0 |      0 |       0.0% | 3.0 KiB |   1.0 KiB |   2.0 KiB |    1.5 KiB |      1.5 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |   2.0 KiB |      30 |          10 |          20 |           15 |             15 |          10 |          10 |          10 |          20 | 90,000,000 ns | 45,000,000 ns | 30,000,000 ns | 15,000,000 ns | 10,000,000 ns (↓ 1100.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1733.3%) |  5,000,000 ns (↓ 1100.0%) |  5,000,000 ns (↓ 1100.0%) |     2 |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  4,000,000 ns  (↓ 150.0%) |  6,000,000 ns (↓ 1900.0%) |  1.414 |                          | 10,000,000 ns (↓ 1100.0%) | 4,000,000 ns (↓ 150.0%) | 6,000,000 ns (↓ 1733.3%) | 5,000,000 ns (↓ 1100.0%) | 5,000,000 ns (↓ 1100.0%) | 4,000,000 ns (↓ 150.0%) | 4,000,000 ns (↓ 150.0%) | 4,000,000 ns (↓ 150.0%) | 6,000,000 ns (↓ 1900.0%) |      1 |      1 |      50.0% | 2.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 20  (↓ 50.0%) | 10        (≈) | 10 (↓ 100.0%) | 10  (↓ 50.0%) |  10  (↓ 50.0%) | 10        (≈) | 10        (≈) | 10        (≈) | 10 (↓ 100.0%) | 6,000,000 ns (↓ 1400.0%) | 3,000,000 ns (↓ 1400.0%) | 4,000,000 ns (↓ 650.0%) | 2,000,000 ns (↓ 650.0%) |
+| | + go worker |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |     1 |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  50,000,000 ns |  0.000 | 30,000,000 ns |  50,000,000 ns | 50,000,000 ns |  50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns | 50,000,000 ns |  50,000,000 ns |      0 |      0 |       0.0% |     0 B |       0 B |       0 B |        0 B |          0 B |       0 B |       0 B |       0 B |       0 B |       0 |           0 |           0 |            0 |              0 |           0 |           0 |           0 |           0 |          0 ns |          0 ns |          0 ns |          0 ns |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |     1 |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  8,000,000 ns  (↓ 525.0%) |  0.000 | 2,000,000 ns (↓ 1400.0%) |  8,000,000 ns  (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns (↓ 525.0%) | 8,000,000 ns  (↓ 525.0%) |      0 |      0 |       0.0% |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |   0       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |         0 ns        (--) |         0 ns        (--) |         0 ns       (--) |         0 ns       (--) |
++---------------+----------------+----------------+----------------+----------------+----------------+-------+----------------+----------------+----------------+----------------+--------+---------------+----------------+---------------+----------------+---------------+---------------+---------------+---------------+---------------+----------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------------+---------------+---------------+---------------+---------------------------+---------------------------+---------------------------+---------------------------+---------------------------+-------+---------------------------+---------------------------+---------------------------+---------------------------+--------+--------------------------+---------------------------+-------------------------+--------------------------+--------------------------+--------------------------+-------------------------+-------------------------+-------------------------+--------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+--------------------------+--------------------------+-------------------------+-------------------------+
 `
 
 	if expOutput != output {
@@ -230,14 +232,15 @@ func TestDiffWithoutProfileLabel(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-|            | baseline                                                                                                                                                       | profile 1                                                                                                                                                                                                                                                         |
-+------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------+-------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
-| call stack |         total |           min |           max |          mean |        median | invoc |           p50 |           p75 |           p90 |           p99 | stddev |                    total |                      min |                      max |                     mean |                   median | invoc |                      p50 |                      p75 |                      p90 |                      p99 | stddev |
-+------------+---------------+---------------+---------------+---------------+---------------+-------+---------------+---------------+---------------+---------------+--------+--------------------------+--------------------------+--------------------------+--------------------------+--------------------------+-------+--------------------------+--------------------------+--------------------------+--------------------------+--------+
-| - main     | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us |     1 | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us |  0.000 | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) |     1 | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) |  0.000 |
-| | + foo    | 120,000.00 us |  10,000.00 us | 110,000.00 us |  60,000.00 us |  60,000.00 us |     2 |  10,000.00 us |  10,000.00 us |  10,000.00 us | 120,000.00 us | 70.711 | 10,000.00 us (↓ 1100.0%) |  4,000.00 us  (↓ 150.0%) |  6,000.00 us (↓ 1733.3%) |  5,000.00 us (↓ 1100.0%) |  5,000.00 us (↓ 1100.0%) |     2 |  4,000.00 us  (↓ 150.0%) |  4,000.00 us  (↓ 150.0%) |  4,000.00 us  (↓ 150.0%) |  6,000.00 us (↓ 1900.0%) |  1.414 |
-+------------+---------------+---------------+---------------+---------------+---------------+-------+---------------+---------------+---------------+---------------+--------+--------------------------+--------------------------+--------------------------+--------------------------+--------------------------+-------+--------------------------+--------------------------+--------------------------+--------------------------+--------+
+	expOutput := `+---------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+-----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+|               | baseline                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                       | profile 1                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                                             |
++---------------+----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+-----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------+
+| call stack    |         total |           min |           max |          mean |        median | invoc |           p50 |           p75 |           p90 |           p99 | stddev |         wait |          self |     self min |      self max |    self mean |  self median |     self p50 |     self p75 |     self p90 |      self p99 | panics | errors | error rate |   bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |          cpu |     cpu mean |      off-cpu | off-cpu mean |                    total |                      min |                      max |                     mean |                   median | invoc |                      p50 |                      p75 |                      p90 |                      p99 | stddev |                    wait |                     self |               self min |                self max |               self mean |             self median |               self p50 |               self p75 |               self p90 |                self p99 | panics | errors | error rate |              bytes |          bytes min |          bytes max |         bytes mean |       bytes median |          bytes p50 |          bytes p75 |          bytes p90 |          bytes p99 |       objects |   objects min |   objects max |  objects mean | objects median |   objects p50 |   objects p75 |   objects p90 |   objects p99 |                     cpu |                cpu mean |                off-cpu |           off-cpu mean |
++---------------+---------------+---------------+---------------+---------------+---------------+-------+---------------+---------------+---------------+---------------+--------+--------------+---------------+--------------+---------------+--------------+--------------+--------------+--------------+--------------+---------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+--------------+--------------+--------------+--------------+--------------------------+--------------------------+--------------------------+--------------------------+--------------------------+-------+--------------------------+--------------------------+--------------------------+--------------------------+--------+-------------------------+--------------------------+------------------------+-------------------------+-------------------------+-------------------------+------------------------+------------------------+------------------------+-------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+-------------------------+-------------------------+------------------------+------------------------+
+| - main        | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us |     1 | 120,000.00 us | 120,000.00 us | 120,000.00 us | 120,000.00 us |  0.000 |              |       0.00 us |      0.00 us |       0.00 us |      0.00 us |      0.00 us |      0.00 us |      0.00 us |      0.00 us |       0.00 us |      0 |      0 |       0.0% | 4.0 KiB |   4.0 KiB |   4.0 KiB |    4.0 KiB |      4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |      40 |          40 |          40 |           40 |             40 |          40 |          40 |          40 |          40 | 80,000.00 us | 80,000.00 us | 40,000.00 us | 40,000.00 us | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) |     1 | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) | 10,000.00 us (↓ 1100.0%) |  0.000 |                         |      0.00 us        (--) |     0.00 us       (--) |     0.00 us        (--) |     0.00 us        (--) |     0.00 us        (--) |     0.00 us       (--) |     0.00 us       (--) |     0.00 us       (--) |     0.00 us        (--) |      0 |      0 |       0.0% | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 2.0 KiB (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) |  20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 20 (↓ 100.0%) | 4,000.00 us (↓ 1900.0%) | 4,000.00 us (↓ 1900.0%) | 6,000.00 us (↓ 566.7%) | 6,000.00 us (↓ 566.7%) |
+| | + foo       | 120,000.00 us |  10,000.00 us | 110,000.00 us |  60,000.00 us |  60,000.00 us |     2 |  10,000.00 us |  10,000.00 us |  10,000.00 us | 120,000.00 us | 70.711 |              | 120,000.00 us | 10,000.00 us | 110,000.00 us | 60,000.00 us | 60,000.00 us | 10,000.00 us | 10,000.00 us | 10,000.00 us | 120,000.00 us |      0 |      0 |       0.0% | 3.0 KiB |   1.0 KiB |   2.0 KiB |    1.5 KiB |      1.5 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |   2.0 KiB |      30 |          10 |          20 |           15 |             15 |          10 |          10 |          10 |          20 | 90,000.00 us | 45,000.00 us | 30,000.00 us | 15,000.00 us | 10,000.00 us (↓ 1100.0%) |  4,000.00 us  (↓ 150.0%) |  6,000.00 us (↓ 1733.3%) |  5,000.00 us (↓ 1100.0%) |  5,000.00 us (↓ 1100.0%) |     2 |  4,000.00 us  (↓ 150.0%) |  4,000.00 us  (↓ 150.0%) |  4,000.00 us  (↓ 150.0%) |  6,000.00 us (↓ 1900.0%) |  1.414 |                         | 10,000.00 us (↓ 1100.0%) | 4,000.00 us (↓ 150.0%) | 6,000.00 us (↓ 1733.3%) | 5,000.00 us (↓ 1100.0%) | 5,000.00 us (↓ 1100.0%) | 4,000.00 us (↓ 150.0%) | 4,000.00 us (↓ 150.0%) | 4,000.00 us (↓ 150.0%) | 6,000.00 us (↓ 1900.0%) |      1 |      1 |      50.0% | 2.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB  (↓ 50.0%) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB        (≈) | 1.0 KiB (↓ 100.0%) | 20  (↓ 50.0%) | 10        (≈) | 10 (↓ 100.0%) | 10  (↓ 50.0%) |  10  (↓ 50.0%) | 10        (≈) | 10        (≈) | 10        (≈) | 10 (↓ 100.0%) | 6,000.00 us (↓ 1400.0%) | 3,000.00 us (↓ 1400.0%) | 4,000.00 us (↓ 650.0%) | 2,000.00 us (↓ 650.0%) |
+| | + go worker |  50,000.00 us |  50,000.00 us |  50,000.00 us |  50,000.00 us |  50,000.00 us |     1 |  50,000.00 us |  50,000.00 us |  50,000.00 us |  50,000.00 us |  0.000 | 30,000.00 us |  50,000.00 us | 50,000.00 us |  50,000.00 us | 50,000.00 us | 50,000.00 us | 50,000.00 us | 50,000.00 us | 50,000.00 us |  50,000.00 us |      0 |      0 |       0.0% |     0 B |       0 B |       0 B |        0 B |          0 B |       0 B |       0 B |       0 B |       0 B |       0 |           0 |           0 |            0 |              0 |           0 |           0 |           0 |           0 |      0.00 us |      0.00 us |      0.00 us |      0.00 us |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |     1 |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  8,000.00 us  (↓ 525.0%) |  0.000 | 2,000.00 us (↓ 1400.0%) |  8,000.00 us  (↓ 525.0%) | 8,000.00 us (↓ 525.0%) | 8,000.00 us  (↓ 525.0%) | 8,000.00 us  (↓ 525.0%) | 8,000.00 us  (↓ 525.0%) | 8,000.00 us (↓ 525.0%) | 8,000.00 us (↓ 525.0%) | 8,000.00 us (↓ 525.0%) | 8,000.00 us  (↓ 525.0%) |      0 |      0 |       0.0% |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |     0 B       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |   0       (--) |  0       (--) |  0       (--) |  0       (--) |  0       (--) |     0.00 us        (--) |     0.00 us        (--) |     0.00 us       (--) |     0.00 us       (--) |
++---------------+---------------+---------------+---------------+---------------+---------------+-------+---------------+---------------+---------------+---------------+--------+--------------+---------------+--------------+---------------+--------------+--------------+--------------+--------------+--------------+---------------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+--------------+--------------+--------------+--------------+--------------------------+--------------------------+--------------------------+--------------------------+--------------------------+-------+--------------------------+--------------------------+--------------------------+--------------------------+--------+-------------------------+--------------------------+------------------------+-------------------------+-------------------------+-------------------------+------------------------+------------------------+------------------------+-------------------------+--------+--------+------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+--------------------+---------------+---------------+---------------+---------------+----------------+---------------+---------------+---------------+---------------+-------------------------+-------------------------+------------------------+------------------------+
 `
 
 	if expOutput != output {
@@ -276,6 +279,40 @@ func TestFmtDiff(t *testing.T) {
 	}
 }
 
+func TestFmtDiffPercentile(t *testing.T) {
+	mkMetrics := func(value time.Duration) *profiler.CallMetrics {
+		histogram := profiler.NewHistogram()
+		histogram.Record(value)
+		return &profiler.CallMetrics{TimeHistogram: histogram}
+	}
+
+	colTypes, err := parseTableColumList("p999")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dp := &diffPrinter{
+		unit: displayUnitMs,
+	}
+
+	specs := []struct {
+		before *profiler.CallMetrics
+		after  *profiler.CallMetrics
+		expOut string
+	}{
+		{mkMetrics(2 * time.Millisecond), mkMetrics(4 * time.Millisecond), "4.00 ms (" + cRed + string(greaterThanSymbol) + " 100.0%" + cReset + ")"},
+		{&profiler.CallMetrics{}, mkMetrics(4 * time.Millisecond), "4.00 ms (--)"},
+		{mkMetrics(2 * time.Millisecond), &profiler.CallMetrics{}, ""},
+	}
+
+	for specIndex, spec := range specs {
+		out := dp.fmtDiff(spec.before, spec.after, colTypes[0])
+		if out != spec.expOut {
+			t.Errorf("[spec %d] expected formatted output to be %q; got %q", specIndex, spec.expOut, out)
+		}
+	}
+}
+
 func TestAlignAndAppendRows(t *testing.T) {
 	dp := &diffPrinter{
 		rows: [][]string{
@@ -319,32 +356,110 @@ func mockProfiles(t *testing.T, useLabel bool) (profileDir string, profileFiles
 		&profiler.Profile{
 			Label: label,
 			Target: &profiler.CallMetrics{
-				FnName:      "main",
-				TotalTime:   120 * time.Millisecond,
-				MinTime:     120 * time.Millisecond,
-				MeanTime:    120 * time.Millisecond,
-				MaxTime:     120 * time.Millisecond,
-				MedianTime:  120 * time.Millisecond,
-				P50Time:     120 * time.Millisecond,
-				P75Time:     120 * time.Millisecond,
-				P90Time:     120 * time.Millisecond,
-				P99Time:     120 * time.Millisecond,
-				StdDev:      0.0,
-				Invocations: 1,
+				FnName:             "main",
+				TotalTime:          120 * time.Millisecond,
+				MinTime:            120 * time.Millisecond,
+				MeanTime:           120 * time.Millisecond,
+				MaxTime:            120 * time.Millisecond,
+				MedianTime:         120 * time.Millisecond,
+				P50Time:            120 * time.Millisecond,
+				P75Time:            120 * time.Millisecond,
+				P90Time:            120 * time.Millisecond,
+				P99Time:            120 * time.Millisecond,
+				StdDev:             0.0,
+				Invocations:        1,
+				AllocBytes:         4096,
+				MinAllocBytes:      4096,
+				MaxAllocBytes:      4096,
+				MeanAllocBytes:     4096,
+				MedianAllocBytes:   4096,
+				P50AllocBytes:      4096,
+				P75AllocBytes:      4096,
+				P90AllocBytes:      4096,
+				P99AllocBytes:      4096,
+				AllocObjects:       40,
+				MinAllocObjects:    40,
+				MaxAllocObjects:    40,
+				MeanAllocObjects:   40,
+				MedianAllocObjects: 40,
+				P50AllocObjects:    40,
+				P75AllocObjects:    40,
+				P90AllocObjects:    40,
+				P99AllocObjects:    40,
+				CPUTime:            80 * time.Millisecond,
+				MeanCPUTime:        80 * time.Millisecond,
+				OffCPUTime:         40 * time.Millisecond,
+				MeanOffCPUTime:     40 * time.Millisecond,
 				NestedCalls: []*profiler.CallMetrics{
 					{
-						FnName:      "foo",
-						TotalTime:   120 * time.Millisecond,
-						MeanTime:    60 * time.Millisecond,
-						MedianTime:  60 * time.Millisecond,
-						MinTime:     10 * time.Millisecond,
-						MaxTime:     110 * time.Millisecond,
-						P50Time:     10 * time.Millisecond,
-						P75Time:     10 * time.Millisecond,
-						P90Time:     10 * time.Millisecond,
-						P99Time:     120 * time.Millisecond,
-						StdDev:      70.71068,
-						Invocations: 2,
+						FnName:             "foo",
+						TotalTime:          120 * time.Millisecond,
+						MeanTime:           60 * time.Millisecond,
+						MedianTime:         60 * time.Millisecond,
+						MinTime:            10 * time.Millisecond,
+						MaxTime:            110 * time.Millisecond,
+						P50Time:            10 * time.Millisecond,
+						P75Time:            10 * time.Millisecond,
+						P90Time:            10 * time.Millisecond,
+						P99Time:            120 * time.Millisecond,
+						StdDev:             70.71068,
+						SelfTime:           120 * time.Millisecond,
+						MinSelfTime:        10 * time.Millisecond,
+						MaxSelfTime:        110 * time.Millisecond,
+						MeanSelfTime:       60 * time.Millisecond,
+						MedianSelfTime:     60 * time.Millisecond,
+						P50SelfTime:        10 * time.Millisecond,
+						P75SelfTime:        10 * time.Millisecond,
+						P90SelfTime:        10 * time.Millisecond,
+						P99SelfTime:        120 * time.Millisecond,
+						Invocations:        2,
+						AllocBytes:         3072,
+						MinAllocBytes:      1024,
+						MaxAllocBytes:      2048,
+						MeanAllocBytes:     1536,
+						MedianAllocBytes:   1536,
+						P50AllocBytes:      1024,
+						P75AllocBytes:      1024,
+						P90AllocBytes:      1024,
+						P99AllocBytes:      2048,
+						AllocObjects:       30,
+						MinAllocObjects:    10,
+						MaxAllocObjects:    20,
+						MeanAllocObjects:   15,
+						MedianAllocObjects: 15,
+						P50AllocObjects:    10,
+						P75AllocObjects:    10,
+						P90AllocObjects:    10,
+						P99AllocObjects:    20,
+						CPUTime:            90 * time.Millisecond,
+						MeanCPUTime:        45 * time.Millisecond,
+						OffCPUTime:         30 * time.Millisecond,
+						MeanOffCPUTime:     15 * time.Millisecond,
+					},
+					{
+						FnName:         "go worker",
+						TotalTime:      50 * time.Millisecond,
+						MeanTime:       50 * time.Millisecond,
+						MedianTime:     50 * time.Millisecond,
+						MinTime:        50 * time.Millisecond,
+						MaxTime:        50 * time.Millisecond,
+						P50Time:        50 * time.Millisecond,
+						P75Time:        50 * time.Millisecond,
+						P90Time:        50 * time.Millisecond,
+						P99Time:        50 * time.Millisecond,
+						StdDev:         0.0,
+						SelfTime:       50 * time.Millisecond,
+						MinSelfTime:    50 * time.Millisecond,
+						MaxSelfTime:    50 * time.Millisecond,
+						MeanSelfTime:   50 * time.Millisecond,
+						MedianSelfTime: 50 * time.Millisecond,
+						P50SelfTime:    50 * time.Millisecond,
+						P75SelfTime:    50 * time.Millisecond,
+						P90SelfTime:    50 * time.Millisecond,
+						P99SelfTime:    50 * time.Millisecond,
+						Invocations:    1,
+						Async:          true,
+						WaitTime:       30 * time.Millisecond,
 					},
 				},
 			},
@@ -352,32 +467,113 @@ func mockProfiles(t *testing.T, useLabel bool) (profileDir string, profileFiles
 		&profiler.Profile{
 			Label: label,
 			Target: &profiler.CallMetrics{
-				FnName:      "main",
-				TotalTime:   10 * time.Millisecond,
-				MinTime:     10 * time.Millisecond,
-				MeanTime:    10 * time.Millisecond,
-				MaxTime:     10 * time.Millisecond,
-				MedianTime:  10 * time.Millisecond,
-				P50Time:     10 * time.Millisecond,
-				P75Time:     10 * time.Millisecond,
-				P90Time:     10 * time.Millisecond,
-				P99Time:     10 * time.Millisecond,
-				StdDev:      0.0,
-				Invocations: 1,
+				FnName:             "main",
+				TotalTime:          10 * time.Millisecond,
+				MinTime:            10 * time.Millisecond,
+				MeanTime:           10 * time.Millisecond,
+				MaxTime:            10 * time.Millisecond,
+				MedianTime:         10 * time.Millisecond,
+				P50Time:            10 * time.Millisecond,
+				P75Time:            10 * time.Millisecond,
+				P90Time:            10 * time.Millisecond,
+				P99Time:            10 * time.Millisecond,
+				StdDev:             0.0,
+				Invocations:        1,
+				AllocBytes:         2048,
+				MinAllocBytes:      2048,
+				MaxAllocBytes:      2048,
+				MeanAllocBytes:     2048,
+				MedianAllocBytes:   2048,
+				P50AllocBytes:      2048,
+				P75AllocBytes:      2048,
+				P90AllocBytes:      2048,
+				P99AllocBytes:      2048,
+				AllocObjects:       20,
+				MinAllocObjects:    20,
+				MaxAllocObjects:    20,
+				MeanAllocObjects:   20,
+				MedianAllocObjects: 20,
+				P50AllocObjects:    20,
+				P75AllocObjects:    20,
+				P90AllocObjects:    20,
+				P99AllocObjects:    20,
+				CPUTime:            4 * time.Millisecond,
+				MeanCPUTime:        4 * time.Millisecond,
+				OffCPUTime:         6 * time.Millisecond,
+				MeanOffCPUTime:     6 * time.Millisecond,
 				NestedCalls: []*profiler.CallMetrics{
 					{
-						FnName:      "foo",
-						TotalTime:   10 * time.Millisecond,
-						MeanTime:    5 * time.Millisecond,
-						MinTime:     4 * time.Millisecond,
-						MaxTime:     6 * time.Millisecond,
-						MedianTime:  5 * time.Millisecond,
-						P50Time:     4 * time.Millisecond,
-						P75Time:     4 * time.Millisecond,
-						P90Time:     4 * time.Millisecond,
-						P99Time:     6 * time.Millisecond,
-						StdDev:      1.41421,
-						Invocations: 2,
+						FnName:             "foo",
+						TotalTime:          10 * time.Millisecond,
+						MeanTime:           5 * time.Millisecond,
+						MinTime:            4 * time.Millisecond,
+						MaxTime:            6 * time.Millisecond,
+						MedianTime:         5 * time.Millisecond,
+						P50Time:            4 * time.Millisecond,
+						P75Time:            4 * time.Millisecond,
+						P90Time:            4 * time.Millisecond,
+						P99Time:            6 * time.Millisecond,
+						StdDev:             1.41421,
+						Panics:             1,
+						Errors:             1,
+						ErrorRate:          0.5,
+						SelfTime:           10 * time.Millisecond,
+						MinSelfTime:        4 * time.Millisecond,
+						MaxSelfTime:        6 * time.Millisecond,
+						MeanSelfTime:       5 * time.Millisecond,
+						MedianSelfTime:     5 * time.Millisecond,
+						P50SelfTime:        4 * time.Millisecond,
+						P75SelfTime:        4 * time.Millisecond,
+						P90SelfTime:        4 * time.Millisecond,
+						P99SelfTime:        6 * time.Millisecond,
+						Invocations:        2,
+						AllocBytes:         2048,
+						MinAllocBytes:      1024,
+						MaxAllocBytes:      1024,
+						MeanAllocBytes:     1024,
+						MedianAllocBytes:   1024,
+						P50AllocBytes:      1024,
+						P75AllocBytes:      1024,
+						P90AllocBytes:      1024,
+						P99AllocBytes:      1024,
+						AllocObjects:       20,
+						MinAllocObjects:    10,
+						MaxAllocObjects:    10,
+						MeanAllocObjects:   10,
+						MedianAllocObjects: 10,
+						P50AllocObjects:    10,
+						P75AllocObjects:    10,
+						P90AllocObjects:    10,
+						P99AllocObjects:    10,
+						CPUTime:            6 * time.Millisecond,
+						MeanCPUTime:        3 * time.Millisecond,
+						OffCPUTime:         4 * time.Millisecond,
+						MeanOffCPUTime:     2 * time.Millisecond,
+					},
+					{
+						FnName:         "go worker",
+						TotalTime:      8 * time.Millisecond,
+						MeanTime:       8 * time.Millisecond,
+						MedianTime:     8 * time.Millisecond,
+						MinTime:        8 * time.Millisecond,
+						MaxTime:        8 * time.Millisecond,
+						P50Time:        8 * time.Millisecond,
+						P75Time:        8 * time.Millisecond,
+						P90Time:        8 * time.Millisecond,
+						P99Time:        8 * time.Millisecond,
+						StdDev:         0.0,
+						SelfTime:       8 * time.Millisecond,
+						MinSelfTime:    8 * time.Millisecond,
+						MaxSelfTime:    8 * time.Millisecond,
+						MeanSelfTime:   8 * time.Millisecond,
+						MedianSelfTime: 8 * time.Millisecond,
+						P50SelfTime:    8 * time.Millisecond,
+						P75SelfTime:    8 * time.Millisecond,
+						P90SelfTime:    8 * time.Millisecond,
+						P99SelfTime:    8 * time.Millisecond,
+						Invocations:    1,
+						Async:          true,
+						WaitTime:       2 * time.Millisecond,
 					},
 				},
 			},
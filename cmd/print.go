@@ -28,6 +28,12 @@ func PrintProfile(ctx *cli.Context) error {
 		return errNoProfile
 	}
 
+	// Apply display defaults from the config file
+	err = applyDisplayDefaults(ctx, func(cfg *config) *displayConfig { return cfg.Print })
+	if err != nil {
+		return err
+	}
+
 	pp := &profilePrinter{}
 
 	pp.format, err = parseDisplayFormat(ctx.String("display-format"))
@@ -150,8 +156,39 @@ func (pp *profilePrinter) detectTimeUnit(metrics *profiler.CallMetrics) displayU
 			val = metrics.P90Time
 		case tableColP99:
 			val = metrics.P99Time
+		case tableColWait:
+			val = metrics.WaitTime
+		case tableColSelf:
+			val = metrics.SelfTime
+		case tableColSelfMin:
+			val = metrics.MinSelfTime
+		case tableColSelfMax:
+			val = metrics.MaxSelfTime
+		case tableColSelfMean:
+			val = metrics.MeanSelfTime
+		case tableColSelfMedian:
+			val = metrics.MedianSelfTime
+		case tableColSelfP50:
+			val = metrics.P50SelfTime
+		case tableColSelfP75:
+			val = metrics.P75SelfTime
+		case tableColSelfP90:
+			val = metrics.P90SelfTime
+		case tableColSelfP99:
+			val = metrics.P99SelfTime
+		case tableColCPU:
+			val = metrics.CPUTime
+		case tableColCPUMean:
+			val = metrics.MeanCPUTime
+		case tableColOffCPU:
+			val = metrics.OffCPUTime
+		case tableColOffCPUMean:
+			val = metrics.MeanOffCPUTime
 		default:
-			continue
+			if !dType.IsPercentile() {
+				continue
+			}
+			val, _ = dType.PercentileValue(metrics)
 		}
 
 		dUnit := detectTimeUnit(val)
@@ -176,9 +213,19 @@ func (pp *profilePrinter) detectTimeUnit(metrics *profiler.CallMetrics) displayU
 func (pp *profilePrinter) fmtEntry(rootMetrics, metrics *profiler.CallMetrics, metricType tableColumnType) string {
 	var val, rootVal time.Duration
 
+	if metricType.IsAlloc() {
+		return pp.fmtAllocEntry(rootMetrics, metrics, metricType)
+	}
+
 	switch metricType {
 	case tableColInvocations:
 		return fmt.Sprintf("%d", metrics.Invocations)
+	case tableColPanics:
+		return fmt.Sprintf("%d", metrics.Panics)
+	case tableColErrors:
+		return fmt.Sprintf("%d", metrics.Errors)
+	case tableColErrorRate:
+		return fmt.Sprintf("%2.1f%%", 100.0*metrics.ErrorRate)
 	case tableColStdDev:
 		return fmt.Sprintf("%3.3f", metrics.StdDev)
 	case tableColTotal:
@@ -208,6 +255,62 @@ func (pp *profilePrinter) fmtEntry(rootMetrics, metrics *profiler.CallMetrics, m
 	case tableColP99:
 		val = metrics.P99Time
 		rootVal = rootMetrics.P99Time
+	case tableColWait:
+		// Wait time is only tracked for async calls
+		if !metrics.Async {
+			return ""
+		}
+		val = metrics.WaitTime
+		rootVal = rootMetrics.TotalTime
+	case tableColSelf:
+		val = metrics.SelfTime
+		rootVal = rootMetrics.TotalTime
+	case tableColSelfMin:
+		val = metrics.MinSelfTime
+		rootVal = rootMetrics.MinTime
+	case tableColSelfMax:
+		val = metrics.MaxSelfTime
+		rootVal = rootMetrics.MaxTime
+	case tableColSelfMean:
+		val = metrics.MeanSelfTime
+		rootVal = rootMetrics.MeanTime
+	case tableColSelfMedian:
+		val = metrics.MedianSelfTime
+		rootVal = rootMetrics.MedianTime
+	case tableColSelfP50:
+		val = metrics.P50SelfTime
+		rootVal = rootMetrics.P50Time
+	case tableColSelfP75:
+		val = metrics.P75SelfTime
+		rootVal = rootMetrics.P75Time
+	case tableColSelfP90:
+		val = metrics.P90SelfTime
+		rootVal = rootMetrics.P90Time
+	case tableColSelfP99:
+		val = metrics.P99SelfTime
+		rootVal = rootMetrics.P99Time
+	case tableColCPU:
+		val = metrics.CPUTime
+		rootVal = rootMetrics.TotalTime
+	case tableColCPUMean:
+		val = metrics.MeanCPUTime
+		rootVal = rootMetrics.MeanTime
+	case tableColOffCPU:
+		val = metrics.OffCPUTime
+		rootVal = rootMetrics.TotalTime
+	case tableColOffCPUMean:
+		val = metrics.MeanOffCPUTime
+		rootVal = rootMetrics.MeanTime
+	default:
+		if metricType.IsPercentile() {
+			// Percentiles can only be estimated if the profile includes
+			// time histograms
+			var tracked bool
+			if val, tracked = metricType.PercentileValue(metrics); !tracked {
+				return ""
+			}
+			rootVal, _ = metricType.TotalPercentile().PercentileValue(rootMetrics)
+		}
 	}
 
 	// Convert value to the proper unit
@@ -231,3 +334,24 @@ func (pp *profilePrinter) fmtEntry(rootMetrics, metrics *profiler.CallMetrics, m
 		return fmt.Sprintf("%2.1f%%", percent)
 	}
 }
+
+// Format an allocation metric entry. When displaying percentages, the value
+// is expressed as a percentage of the same metric for the root call and an
+// empty string will be returned if it is less than the specified threshold.
+func (pp *profilePrinter) fmtAllocEntry(rootMetrics, metrics *profiler.CallMetrics, metricType tableColumnType) string {
+	val := metricType.AllocValue(metrics)
+
+	switch pp.format {
+	case displayTime:
+		return metricType.FormatAlloc(val)
+	default:
+		percent := 0.0
+		if rootVal := metricType.AllocValue(rootMetrics); rootVal != 0 {
+			percent = 100.0 * float64(val) / float64(rootVal)
+		}
+		if percent < pp.clipThreshold {
+			return ""
+		}
+		return fmt.Sprintf("%2.1f%%", percent)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"io"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/geckoboard/prism/profiler"
 	"gopkg.in/urfave/cli.v1"
 )
 
@@ -64,12 +66,13 @@ func TestPrintWithProfileLabel(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+
-| With Label - call stack |     total |       min |       max |      mean |    median | invoc |       p50 |       p75 |       p90 |       p99 | stddev |
-+-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+
-| + main                  | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |     1 | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |  0.000 |
-| | - foo                 | 120.00 ms |           | 110.00 ms |  60.00 ms |  60.00 ms |     2 |           |           |           | 120.00 ms | 70.711 |
-+-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+
+	expOutput := `+-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+----------+-----------+----------+-----------+-----------+-------------+----------+----------+----------+-----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+----------+----------+----------+--------------+
+| With Label - call stack |     total |       min |       max |      mean |    median | invoc |       p50 |       p75 |       p90 |       p99 | stddev |     wait |      self | self min |  self max | self mean | self median | self p50 | self p75 | self p90 |  self p99 | panics | errors | error rate |   bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |      cpu | cpu mean |  off-cpu | off-cpu mean |
++-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+----------+-----------+----------+-----------+-----------+-------------+----------+----------+----------+-----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+----------+----------+----------+--------------+
+| + main                  | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |     1 | 120.00 ms | 120.00 ms | 120.00 ms | 120.00 ms |  0.000 |          |           |          |           |           |             |          |          |          |           |      0 |      0 |       0.0% | 4.0 KiB |   4.0 KiB |   4.0 KiB |    4.0 KiB |      4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |   4.0 KiB |      40 |          40 |          40 |           40 |             40 |          40 |          40 |          40 |          40 | 80.00 ms | 80.00 ms | 40.00 ms |     40.00 ms |
+| | - foo                 | 120.00 ms |           | 110.00 ms |  60.00 ms |  60.00 ms |     2 |           |           |           | 120.00 ms | 70.711 |          | 120.00 ms |          | 110.00 ms |  60.00 ms |    60.00 ms |          |          |          | 120.00 ms |      0 |      0 |       0.0% | 3.0 KiB |   1.0 KiB |   2.0 KiB |    1.5 KiB |      1.5 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |   2.0 KiB |      30 |          10 |          20 |           15 |             15 |          10 |          10 |          10 |          20 | 90.00 ms | 45.00 ms | 30.00 ms |     15.00 ms |
+| | - go worker           |  50.00 ms |  50.00 ms |  50.00 ms |  50.00 ms |  50.00 ms |     1 |  50.00 ms |  50.00 ms |  50.00 ms |  50.00 ms |  0.000 | 30.00 ms |  50.00 ms | 50.00 ms |  50.00 ms |  50.00 ms |    50.00 ms | 50.00 ms | 50.00 ms | 50.00 ms |  50.00 ms |      0 |      0 |       0.0% |     0 B |       0 B |       0 B |        0 B |          0 B |       0 B |       0 B |       0 B |       0 B |       0 |           0 |           0 |            0 |              0 |           0 |           0 |           0 |           0 |          |          |          |              |
++-------------------------+-----------+-----------+-----------+-----------+-----------+-------+-----------+-----------+-----------+-----------+--------+----------+-----------+----------+-----------+-----------+-------------+----------+----------+----------+-----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+----------+----------+----------+--------------+
 `
 
 	if expOutput != output {
@@ -118,12 +121,13 @@ func TestPrintWithoutProfileLabel(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+
-| call stack |    total |      min |      max |     mean |   median | invoc |      p50 |      p75 |      p90 |      p99 | stddev |
-+------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+
-| + main     | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms |     1 | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms |  0.000 |
-| | - foo    | 10.00 ms |  4.00 ms |  6.00 ms |  5.00 ms |  5.00 ms |     2 |  4.00 ms |  4.00 ms |  4.00 ms |  6.00 ms |  1.414 |
-+------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+
+	expOutput := `+---------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+---------+----------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------+----------+---------+--------------+
+| call stack    |    total |      min |      max |     mean |   median | invoc |      p50 |      p75 |      p90 |      p99 | stddev |    wait |     self | self min | self max | self mean | self median | self p50 | self p75 | self p90 | self p99 | panics | errors | error rate |   bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |     cpu | cpu mean | off-cpu | off-cpu mean |
++---------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+---------+----------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------+----------+---------+--------------+
+| + main        | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms |     1 | 10.00 ms | 10.00 ms | 10.00 ms | 10.00 ms |  0.000 |         |  0.00 ms |  0.00 ms |  0.00 ms |   0.00 ms |     0.00 ms |  0.00 ms |  0.00 ms |  0.00 ms |  0.00 ms |      0 |      0 |       0.0% | 2.0 KiB |   2.0 KiB |   2.0 KiB |    2.0 KiB |      2.0 KiB |   2.0 KiB |   2.0 KiB |   2.0 KiB |   2.0 KiB |      20 |          20 |          20 |           20 |             20 |          20 |          20 |          20 |          20 | 4.00 ms |  4.00 ms | 6.00 ms |      6.00 ms |
+| | - foo       | 10.00 ms |  4.00 ms |  6.00 ms |  5.00 ms |  5.00 ms |     2 |  4.00 ms |  4.00 ms |  4.00 ms |  6.00 ms |  1.414 |         | 10.00 ms |  4.00 ms |  6.00 ms |   5.00 ms |     5.00 ms |  4.00 ms |  4.00 ms |  4.00 ms |  6.00 ms |      1 |      1 |      50.0% | 2.0 KiB |   1.0 KiB |   1.0 KiB |    1.0 KiB |      1.0 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |   1.0 KiB |      20 |          10 |          10 |           10 |             10 |          10 |          10 |          10 |          10 | 6.00 ms |  3.00 ms | 4.00 ms |      2.00 ms |
+| | - go worker |  8.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |     1 |  8.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |  0.000 | 2.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |   8.00 ms |     8.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |  8.00 ms |      0 |      0 |       0.0% |     0 B |       0 B |       0 B |        0 B |          0 B |       0 B |       0 B |       0 B |       0 B |       0 |           0 |           0 |            0 |              0 |           0 |           0 |           0 |           0 | 0.00 ms |  0.00 ms | 0.00 ms |      0.00 ms |
++---------------+----------+----------+----------+----------+----------+-------+----------+----------+----------+----------+--------+---------+----------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+---------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+---------+----------+---------+--------------+
 `
 
 	if expOutput != output {
@@ -172,15 +176,56 @@ func TestPrintWithoutProfileLabelAndPercentOutput(t *testing.T) {
 	os.Stdout = stdOut
 
 	output := buf.String()
-	expOutput := `+------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+
-| call stack |  total |    min |    max |   mean | median | invoc |    p50 |    p75 |    p90 |    p99 | stddev |
-+------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+
-| + main     | 100.0% | 100.0% | 100.0% | 100.0% | 100.0% |     1 | 100.0% | 100.0% | 100.0% | 100.0% |  0.000 |
-| | - foo    | 100.0% |        |  60.0% |  50.0% |  50.0% |     2 |        |        |        |  60.0% |  1.414 |
-+------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+
+	expOutput := `+---------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+------+--------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+--------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+-------+----------+---------+--------------+
+| call stack    |  total |    min |    max |   mean | median | invoc |    p50 |    p75 |    p90 |    p99 | stddev | wait |   self | self min | self max | self mean | self median | self p50 | self p75 | self p90 | self p99 | panics | errors | error rate |  bytes | bytes min | bytes max | bytes mean | bytes median | bytes p50 | bytes p75 | bytes p90 | bytes p99 | objects | objects min | objects max | objects mean | objects median | objects p50 | objects p75 | objects p90 | objects p99 |   cpu | cpu mean | off-cpu | off-cpu mean |
++---------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+------+--------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+--------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+-------+----------+---------+--------------+
+| + main        | 100.0% | 100.0% | 100.0% | 100.0% | 100.0% |     1 | 100.0% | 100.0% | 100.0% | 100.0% |  0.000 |      |        |          |          |           |             |          |          |          |          |      0 |      0 |       0.0% | 100.0% |    100.0% |    100.0% |     100.0% |       100.0% |    100.0% |    100.0% |    100.0% |    100.0% |  100.0% |      100.0% |      100.0% |       100.0% |         100.0% |      100.0% |      100.0% |      100.0% |      100.0% |       |          |   60.0% |        60.0% |
+| | - foo       | 100.0% |        |  60.0% |  50.0% |  50.0% |     2 |        |        |        |  60.0% |  1.414 |      | 100.0% |          |    60.0% |     50.0% |       50.0% |          |          |          |    60.0% |      1 |      1 |      50.0% | 100.0% |     50.0% |     50.0% |      50.0% |        50.0% |     50.0% |     50.0% |     50.0% |     50.0% |  100.0% |       50.0% |       50.0% |        50.0% |          50.0% |       50.0% |       50.0% |       50.0% |       50.0% | 60.0% |          |         |              |
+| | - go worker |  80.0% |  80.0% |  80.0% |  80.0% |  80.0% |     1 |  80.0% |  80.0% |  80.0% |  80.0% |  0.000 |      |  80.0% |    80.0% |    80.0% |     80.0% |       80.0% |    80.0% |    80.0% |    80.0% |    80.0% |      0 |      0 |       0.0% |        |           |           |            |              |           |           |           |           |         |             |             |              |                |             |             |             |             |       |          |         |              |
++---------------+--------+--------+--------+--------+--------+-------+--------+--------+--------+--------+--------+------+--------+----------+----------+-----------+-------------+----------+----------+----------+----------+--------+--------+------------+--------+-----------+-----------+------------+--------------+-----------+-----------+-----------+-----------+---------+-------------+-------------+--------------+----------------+-------------+-------------+-------------+-------------+-------+----------+---------+--------------+
 `
 
 	if expOutput != output {
 		t.Fatalf("tabularized print output mismatch; expected:\n%s\n\ngot:\n%s", expOutput, output)
 	}
 }
+
+func TestFmtEntryPercentile(t *testing.T) {
+	rootHistogram := profiler.NewHistogram()
+	rootHistogram.Record(8 * time.Millisecond)
+	selfHistogram := profiler.NewHistogram()
+	selfHistogram.Record(2 * time.Millisecond)
+
+	rootMetrics := &profiler.CallMetrics{TimeHistogram: rootHistogram}
+	metrics := &profiler.CallMetrics{TimeHistogram: rootHistogram, SelfTimeHistogram: selfHistogram}
+
+	colTypes, err := parseTableColumList("p95,self_p95")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		format  displayFormat
+		metrics *profiler.CallMetrics
+		colType tableColumnType
+		expOut  string
+	}{
+		{displayTime, metrics, colTypes[0], "8.00 ms"},
+		{displayTime, metrics, colTypes[1], "2.00 ms"},
+		{displayPercent, metrics, colTypes[1], "25.0%"},
+		// Profiles without histograms
+		{displayTime, &profiler.CallMetrics{}, colTypes[0], ""},
+	}
+
+	for specIndex, spec := range specs {
+		pp := &profilePrinter{
+			format: spec.format,
+			unit:   displayUnitMs,
+		}
+
+		out := pp.fmtEntry(rootMetrics, spec.metrics, spec.colType)
+		if out != spec.expOut {
+			t.Errorf("[spec %d] expected formatted output to be %q; got %q", specIndex, spec.expOut, out)
+		}
+	}
+}
@@ -15,14 +15,19 @@ import (
 	"syscall"
 
 	"github.com/geckoboard/prism/profiler"
+	"github.com/geckoboard/prism/profiler/sink"
 	"github.com/geckoboard/prism/tools"
 	"gopkg.in/urfave/cli.v1"
 )
 
 var (
-	errMissingPathToProject = errors.New("missing path_to_project argument")
-	errNoProfileTargets     = errors.New("no profile targets specified")
-	errMissingRunCmd        = errors.New("run-cmd not specified")
+	errMissingPathToProject  = errors.New("missing path_to_project argument")
+	errNoProfileTargets      = errors.New("no profile targets specified and no //prism:profile directives found")
+	errMissingRunCmd         = errors.New("run-cmd not specified")
+	errProjectNotInWorkspace = errors.New("project is neither part of a go module nor located inside a go workspace")
+	errInvalidMaxDepth       = errors.New("max-depth must not be negative")
+	errAllTargetsIgnored     = errors.New("all selected profile targets are marked with //prism:ignore directives")
+	errNoConfigFile          = errors.New("scenario specified but no " + configFileName + " config file found")
 
 	tokenizeRegex = regexp.MustCompile("'.+?'|\".+?\"|\\S+")
 )
@@ -35,27 +40,53 @@ func ProfileProject(ctx *cli.Context) error {
 		return errMissingPathToProject
 	}
 
-	profileFuncs := ctx.StringSlice("profile-target")
-	if len(profileFuncs) == 0 {
-		return errNoProfileTargets
+	if !strings.HasSuffix("/", args[0]) {
+		args[0] += "/"
+	}
+	absProjPath, err := filepath.Abs(filepath.Dir(args[0]))
+	if err != nil {
+		return err
 	}
+	absProjPath += "/"
 
+	// Apply the selected scenario from the project config file
+	err = applyProjectConfig(ctx, absProjPath)
+	if err != nil {
+		return err
+	}
+
+	// When profiling tests or benchmarks, go test is used as the default run command
+	testRegex, benchRegex := ctx.String("test"), ctx.String("bench")
+	testMode := testRegex != "" || benchRegex != ""
 	runCmd := ctx.String("run-cmd")
+	if testMode && !ctx.IsSet("run-cmd") {
+		runCmd = testRunCmd(testRegex, benchRegex)
+	}
 	if runCmd == "" {
 		return errMissingRunCmd
 	}
 
-	if !strings.HasSuffix("/", args[0]) {
-		args[0] += "/"
+	if sinkURI := ctx.String("profile-sink"); sinkURI != "" {
+		if _, err := sink.Open(sinkURI); err != nil {
+			return fmt.Errorf("invalid profile-sink: %s", err)
+		}
 	}
-	absProjPath, err := filepath.Abs(filepath.Dir(args[0]))
+
+	cgOpts, err := parseCallGraphOptions(ctx)
 	if err != nil {
 		return err
 	}
-	absProjPath += "/"
 
-	// Clone project
-	tmpDir, tmpAbsProjPath, err := cloneProject(absProjPath, ctx.String("output-dir"))
+	// Clone project or, when using an overlay, setup a scratch dir for the
+	// patched files and work directly with the original project sources
+	useOverlay := ctx.Bool("overlay")
+	var tmpDir, tmpAbsProjPath string
+	if useOverlay {
+		tmpDir, err = ioutil.TempDir(ctx.String("output-dir"), "prism-")
+		tmpAbsProjPath = absProjPath
+	} else {
+		tmpDir, tmpAbsProjPath, err = cloneProject(absProjPath, ctx.String("output-dir"))
+	}
 	if err != nil {
 		return err
 	}
@@ -69,44 +100,215 @@ func ProfileProject(ctx *cli.Context) error {
 		return err
 	}
 
-	// Select profile targets
+	// Merge the user-specified targets with the ones marked via source
+	// directives and select profile targets
+	vendorPkgRegex := ctx.StringSlice("profile-vendored-pkg")
+	directiveFuncs, ignoredFuncs, err := goPackage.Directives(vendorPkgRegex)
+	if err != nil {
+		return err
+	}
+	if len(directiveFuncs) != 0 || len(ignoredFuncs) != 0 {
+		fmt.Printf("profile: found %d profile and %d ignore directives\n", len(directiveFuncs), len(ignoredFuncs))
+	}
+
+	profileFuncs := append(ctx.StringSlice("profile-target"), directiveFuncs...)
+	if len(profileFuncs) == 0 {
+		return errNoProfileTargets
+	}
+
 	profileTargets, err := goPackage.Find(profileFuncs...)
 	if err != nil {
 		return err
 	}
+	profileTargets, skippedTargets := filterIgnoredTargets(profileTargets, ignoredFuncs)
+	for _, skipped := range skippedTargets {
+		fmt.Printf("profile: skipping target %s as it is marked with a //prism:ignore directive\n", skipped)
+	}
+	if len(profileTargets) == 0 {
+		return errAllTargetsIgnored
+	}
+	cgOpts.ignore = ignoredFuncs
+	cgOpts.apply(profileTargets)
 
-	// Inject profiler hooks and bootstrap code to main()
+	// Inject profiler hooks and bootstrap code to main() or, when profiling
+	// tests, to the TestMain() function of the package tests
 	bootstrapTargets := []tools.ProfileTarget{
 		tools.ProfileTarget{
 			QualifiedName: goPackage.PkgPrefix + "/main",
 			PkgPrefix:     goPackage.PkgPrefix,
 		},
 	}
-	updatedFiles, patchCount, err := goPackage.Patch(
-		ctx.StringSlice("profile-vendored-pkg"),
+	profilerOpts := tools.ProfilerOptions{
+		ProfileSink:          ctx.String("profile-sink"),
+		TrackAllocs:          ctx.Bool("track-allocs"),
+		TrackAllocsEvery:     ctx.Int("track-allocs-every"),
+		TrackCPUTime:         ctx.Bool("track-cpu"),
+		SampleEvery:          ctx.Int("sample-every"),
+		MaxProfilesPerSecond: ctx.Int("max-profiles-per-sec"),
+		MaxProfiles:          ctx.Int("max-profiles"),
+		CalibrationCache:     ctx.String("calibration-cache"),
+		NoCalibrationCache:   ctx.Bool("no-calibration-cache"),
+		MedianCalibration:    ctx.Bool("median-calibration"),
+	}
+	bootstrapFn := tools.InjectProfilerBootstrap(ctx.String("profile-dir"), ctx.String("profile-label"), profilerOpts)
+	if testMode {
+		goPackage.IncludeTests = true
+		bootstrapTargets = goPackage.TestMainTargets()
+		bootstrapFn = tools.InjectTestMainBootstrap(ctx.String("profile-dir"), ctx.String("profile-label"), profilerOpts)
+	}
+	patchCmds := []tools.PatchCmd{
 		tools.PatchCmd{Targets: profileTargets, PatchFn: tools.InjectProfiler()},
-		tools.PatchCmd{Targets: bootstrapTargets, PatchFn: tools.InjectProfilerBootstrap(ctx.String("profile-dir"), ctx.String("profile-label"))},
-	)
+		tools.PatchCmd{Targets: bootstrapTargets, PatchFn: bootstrapFn},
+	}
+	var overlayFile string
+	var updatedFiles, patchCount int
+	if useOverlay {
+		overlayFile, updatedFiles, patchCount, err = goPackage.PatchOverlay(tmpDir, vendorPkgRegex, patchCmds...)
+	} else {
+		updatedFiles, patchCount, err = goPackage.Patch(vendorPkgRegex, patchCmds...)
+	}
 	if err != nil {
 		return err
 	}
+	fmt.Printf("profile: %s call graph contains %d nodes reachable from %d targets\n", cgOpts.algorithm, callGraphSize(profileTargets), len(profileTargets))
 	fmt.Printf("profile: updated %d files and applied %d patches\n", updatedFiles, patchCount)
 
 	// Handle build step if a build command is specified
+	env, err := projectEnv(goPackage, ctx.StringSlice("env"))
+	if err != nil {
+		return err
+	}
+	if overlayFile != "" {
+		fmt.Printf("profile: using overlay %s\n", overlayFile)
+		env = overlayEnv(env, overlayFile)
+	}
 	buildCmd := ctx.String("build-cmd")
 	if buildCmd != "" {
-		err = buildProject(goPackage.GOPATH, tmpAbsProjPath, buildCmd, ctx.Bool("no-ansi"))
+		err = buildProject(env, tmpAbsProjPath, buildCmd, ctx.Bool("no-ansi"))
 		if err != nil {
 			return err
 		}
 	}
 
-	return runProject(goPackage.GOPATH, tmpAbsProjPath, runCmd, ctx.Bool("no-ansi"))
+	return runProject(env, tmpAbsProjPath, runCmd, ctx.Bool("no-ansi"))
+}
+
+// Generate a go test command for running the tests and benchmarks matching
+// the supplied regexes. If only a benchmark regex is specified, no tests are run.
+func testRunCmd(testRegex, benchRegex string) string {
+	if testRegex == "" {
+		testRegex = "^$"
+	}
+
+	runCmd := "go test -run " + testRegex
+	if benchRegex != "" {
+		runCmd += " -bench " + benchRegex
+	}
+
+	return runCmd
+}
+
+// callGraphOptions groups the user-specified settings that control the
+// construction of profile target callgraphs.
+type callGraphOptions struct {
+	algorithm tools.CallGraphAlgorithm
+	maxDepth  int
+	exclude   []string
+	ignore    []string
+}
+
+// Parse the callgraph options from the cli context.
+func parseCallGraphOptions(ctx *cli.Context) (*callGraphOptions, error) {
+	algorithm, err := tools.ParseCallGraphAlgorithm(ctx.String("callgraph-algorithm"))
+	if err != nil {
+		return nil, err
+	}
+
+	maxDepth := ctx.Int("max-depth")
+	if maxDepth < 0 {
+		return nil, errInvalidMaxDepth
+	}
+
+	exclude := ctx.StringSlice("exclude")
+	if err = tools.ValidatePatterns(exclude...); err != nil {
+		return nil, fmt.Errorf("invalid exclude pattern: %s", err)
+	}
+
+	return &callGraphOptions{
+		algorithm: algorithm,
+		maxDepth:  maxDepth,
+		exclude:   exclude,
+	}, nil
+}
+
+// Apply the callgraph options to a list of profile targets.
+func (opts *callGraphOptions) apply(targets []tools.ProfileTarget) {
+	for index := range targets {
+		targets[index].CallGraphAlgorithm = opts.algorithm
+		targets[index].MaxDepth = opts.maxDepth
+		targets[index].Exclude = opts.exclude
+		targets[index].Ignore = opts.ignore
+	}
+}
+
+// Remove the targets for functions marked with a //prism:ignore directive from
+// a list of profile targets. It returns the remaining targets and the FQ names
+// of the removed targets.
+func filterIgnoredTargets(targets []tools.ProfileTarget, ignored []string) ([]tools.ProfileTarget, []string) {
+	ignoredSet := make(map[string]struct{}, len(ignored))
+	for _, fqName := range ignored {
+		ignoredSet[fqName] = struct{}{}
+	}
+
+	kept := make([]tools.ProfileTarget, 0, len(targets))
+	skipped := make([]string, 0)
+	for _, target := range targets {
+		if _, isIgnored := ignoredSet[target.QualifiedName]; isIgnored {
+			skipped = append(skipped, target.QualifiedName)
+			continue
+		}
+		kept = append(kept, target)
+	}
+
+	return kept, skipped
+}
+
+// Count the unique callgraph nodes reachable from a list of profile targets.
+func callGraphSize(targets []tools.ProfileTarget) int {
+	uniqueNodes := make(map[string]struct{}, 0)
+	for _, target := range targets {
+		for _, cgNode := range target.CallGraph() {
+			uniqueNodes[cgNode.Name] = struct{}{}
+		}
+	}
+
+	return len(uniqueNodes)
 }
 
 // Clone project and return path to the cloned project.
+//
+// Projects living inside a go workspace are copied to a src folder inside the
+// temp dir so that the temp dir can serve as a go workspace. Projects
+// belonging to a go module are cloned together with the rest of the module
+// so that the go.mod file and any sibling packages are also available to
+// the go tool.
 func cloneProject(absProjPath, dest string) (tmpDir, tmpAbsProjPath string, err error) {
-	skipLen := strings.Index(absProjPath, "/src/")
+	modRoot, err := tools.ModuleRoot(absProjPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	var skipLen int
+	cloneRoot := absProjPath
+	if modRoot != "" {
+		cloneRoot = modRoot
+		skipLen = len(filepath.Dir(modRoot))
+	} else {
+		skipLen = strings.Index(absProjPath, "/src/")
+		if skipLen == -1 {
+			return "", "", errProjectNotInWorkspace
+		}
+	}
 
 	tmpDir, err = ioutil.TempDir(dest, "prism-")
 	if err != nil {
@@ -115,7 +317,7 @@ func cloneProject(absProjPath, dest string) (tmpDir, tmpAbsProjPath string, err
 
 	fmt.Printf("profile: copying project to %s\n", tmpDir)
 
-	err = filepath.Walk(absProjPath, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(cloneRoot, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -144,6 +346,10 @@ func cloneProject(absProjPath, dest string) (tmpDir, tmpAbsProjPath string, err
 		return err
 	})
 
+	if err == nil && modRoot != "" {
+		err = tools.PrepareModuleClone(tmpDir+modRoot[skipLen:], modRoot)
+	}
+
 	if err != nil {
 		deleteClonedProject(tmpDir)
 		return "", "", err
@@ -154,22 +360,101 @@ func cloneProject(absProjPath, dest string) (tmpDir, tmpAbsProjPath string, err
 		nil
 }
 
-// Update GOPATH so that the workspace containing the cloned package is included
-// first. This ensures that go will pick up subpackages from the cloned folder.
-func overrideGoPath(adjustedGoPath string) []string {
-	env := os.Environ()
-	for index, envVar := range env {
-		if strings.HasPrefix(envVar, "GOPATH=") {
-			env[index] = "GOPATH=" + adjustedGoPath
+// Generate the environment for building and running the patched project copy.
+//
+// For projects inside a go workspace, GOPATH is updated so that the workspace
+// containing the cloned package is included first. This ensures that go will
+// pick up subpackages from the cloned folder. For projects belonging to a go
+// module, the cloned go.mod file already ensures that go will pick up
+// subpackages from the cloned folder; we just need to make sure that module
+// mode is enabled and that no go.work file from a parent folder interferes.
+//
+// Any user-specified KEY=VALUE pairs in extraEnv are applied last.
+func projectEnv(goPackage *tools.GoPackage, extraEnv []string) ([]string, error) {
+	for _, envVar := range extraEnv {
+		if strings.IndexByte(envVar, '=') < 1 {
+			return nil, fmt.Errorf("invalid env var %q; expected KEY=VALUE", envVar)
+		}
+	}
+
+	var env []string
+	if goPackage.ModuleRoot != "" {
+		env = overrideEnv(os.Environ(), "GO111MODULE=on", "GOWORK=off")
+	} else {
+		env = overrideEnv(os.Environ(), "GOPATH="+goPackage.GOPATH, "GO111MODULE=off")
+	}
+
+	return overrideEnv(env, extraEnv...), nil
+}
+
+// Load the config file for the project and apply the settings from the
+// scenario selected via the --scenario flag. Selecting a scenario without
+// a config file is an error.
+func applyProjectConfig(ctx *cli.Context, absProjPath string) error {
+	cfg, err := loadConfig(ctx, absProjPath)
+	if err != nil {
+		return err
+	}
+
+	scenarioName := ctx.String("scenario")
+	if cfg == nil {
+		if scenarioName != "" {
+			return errNoConfigFile
+		}
+		return nil
+	}
+
+	scenario, err := cfg.scenario(scenarioName)
+	if err != nil || scenario == nil {
+		return err
+	}
+
+	if scenarioName == "" {
+		scenarioName = defaultScenarioName
+	}
+	fmt.Printf("profile: using scenario %q\n", scenarioName)
+	return applyScenarioConfig(ctx, cfg, scenario)
+}
+
+// Append an -overlay flag to the GOFLAGS environment variable so that any go
+// tool invocation by the build and run commands picks up the patched files.
+func overlayEnv(env []string, overlayFile string) []string {
+	goFlags := "-overlay=" + overlayFile
+	for _, envVar := range env {
+		if strings.HasPrefix(envVar, "GOFLAGS=") && len(envVar) > len("GOFLAGS=") {
+			goFlags = envVar[len("GOFLAGS="):] + " " + goFlags
 			break
 		}
 	}
 
+	return overrideEnv(env, "GOFLAGS="+goFlags)
+}
+
+// Replace the values of the environment variables in env with the values from
+// the supplied overrides. Any overrides for variables not present in env are
+// appended to it.
+func overrideEnv(env []string, overrides ...string) []string {
+	for _, override := range overrides {
+		varPrefix := override[:strings.IndexByte(override, '=')+1]
+		found := false
+		for index, envVar := range env {
+			if strings.HasPrefix(envVar, varPrefix) {
+				env[index] = override
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			env = append(env, override)
+		}
+	}
+
 	return env
 }
 
 // Build patched project copy.
-func buildProject(adjustedGoPath, tmpAbsProjPath, buildCmd string, stripAnsi bool) error {
+func buildProject(env []string, tmpAbsProjPath, buildCmd string, stripAnsi bool) error {
 	fmt.Printf("profile: building patched project (%s)\n", buildCmd)
 
 	color := "\033[32m"
@@ -190,7 +475,7 @@ func buildProject(adjustedGoPath, tmpAbsProjPath, buildCmd string, stripAnsi boo
 		execCmd = exec.Command(tokens[0])
 	}
 	execCmd.Dir = tmpAbsProjPath
-	execCmd.Env = overrideGoPath(adjustedGoPath)
+	execCmd.Env = env
 	execCmd.Stdin = os.Stdin
 	execCmd.Stdout = stdout
 	execCmd.Stderr = stderr
@@ -208,7 +493,7 @@ func buildProject(adjustedGoPath, tmpAbsProjPath, buildCmd string, stripAnsi boo
 }
 
 // Run patched project to collect profiler data.
-func runProject(adjustedGoPath, tmpAbsProjPath, runCmd string, stripAnsi bool) error {
+func runProject(env []string, tmpAbsProjPath, runCmd string, stripAnsi bool) error {
 	fmt.Printf("profile: running patched project (%s)\n", runCmd)
 
 	color := "\033[32m"
@@ -229,7 +514,7 @@ func runProject(adjustedGoPath, tmpAbsProjPath, runCmd string, stripAnsi bool) e
 		execCmd = exec.Command(tokens[0])
 	}
 	execCmd.Dir = tmpAbsProjPath
-	execCmd.Env = overrideGoPath(adjustedGoPath)
+	execCmd.Env = env
 	execCmd.Stdin = os.Stdin
 	execCmd.Stdout = stdout
 	execCmd.Stderr = stderr
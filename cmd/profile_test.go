@@ -7,12 +7,20 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/geckoboard/prism/tools"
 	"gopkg.in/urfave/cli.v1"
 )
 
+func init() {
+	// Use the profiler sources from the repository checkout; the prism
+	// binary embeds the same files at build time
+	tools.ProfilerSources = os.DirFS("..")
+}
+
 func TestProfile(t *testing.T) {
 	wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, true)
 	defer os.RemoveAll(wsDir)
@@ -22,6 +30,7 @@ func TestProfile(t *testing.T) {
 	set.String("profile-dir", wsDir, "")
 	set.String("build-cmd", "go build -o artifact", "")
 	set.String("run-cmd", "./artifact", "")
+	set.String("callgraph-algorithm", "rta", "")
 	set.Bool("no-ansi", true, "")
 	set.Parse([]string{pkgDir})
 	targets := cli.StringSlice{pkgName + "/main"}
@@ -63,7 +72,7 @@ func TestProfile(t *testing.T) {
 	os.Stderr = stdErr
 
 	outputLines := strings.Split(strings.Trim(buf.String(), "\n"), "\n")
-	expLines := 5
+	expLines := 6
 	if len(outputLines) != expLines {
 		t.Fatalf("expected profile cmd output to emit %d output lines; got %d", expLines, len(outputLines))
 	}
@@ -72,10 +81,11 @@ func TestProfile(t *testing.T) {
 		Line    int
 		ExpText string
 	}{
-		{1, "profile: updated 1 files and applied 4 patches"},
-		{2, "profile: building patched project (go build -o artifact)"},
-		{3, "profile: running patched project (./artifact)"},
-		{4, fmt.Sprintf("profile: [run] > profiler: saving profiles to %s", wsDir)},
+		{1, "profile: rta call graph contains 4 nodes reachable from 1 targets"},
+		{2, "profile: updated 1 files and applied 4 patches"},
+		{3, "profile: building patched project (go build -o artifact)"},
+		{4, "profile: running patched project (./artifact)"},
+		{5, fmt.Sprintf("profile: [run] > profiler: saving profiles to %s", wsDir)},
 	}
 
 	for _, spec := range specs {
@@ -85,6 +95,353 @@ func TestProfile(t *testing.T) {
 	}
 }
 
+func TestProfileModule(t *testing.T) {
+	defer enableModuleMode()()
+	modDir, modName := mockModule(t)
+	defer os.RemoveAll(modDir)
+
+	outputDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	// Mock args
+	set := flag.NewFlagSet("test", 0)
+	set.String("profile-dir", outputDir, "")
+	set.String("output-dir", outputDir, "")
+	set.String("build-cmd", "go build -o artifact", "")
+	set.String("run-cmd", "./artifact", "")
+	set.String("callgraph-algorithm", "rta", "")
+	set.Bool("no-ansi", true, "")
+	set.Parse([]string{modDir + "cmd/"})
+	targets := cli.StringSlice{modName + "/cmd/main"}
+	targetFlag := &cli.StringSliceFlag{
+		Name:  "profile-target",
+		Value: &targets,
+	}
+	targetFlag.Apply(set)
+	ctx := cli.NewContext(nil, set, nil)
+
+	// Redirect stdout and stderr
+	stdOut := os.Stdout
+	stdErr := os.Stderr
+	pRead, pWrite, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = pWrite
+	os.Stderr = pWrite
+
+	// Restore stdout/err incase of a panic
+	defer func() {
+		os.Stdout = stdOut
+		os.Stderr = stdErr
+	}()
+
+	// Profile package and capture output
+	err = ProfileProject(ctx)
+
+	// Drain pipe and restore stdout
+	var buf bytes.Buffer
+	pWrite.Close()
+	io.Copy(&buf, pRead)
+	pRead.Close()
+	os.Stdout = stdOut
+	os.Stderr = stdErr
+
+	if err != nil {
+		t.Fatalf("%v; output:\n%s", err, buf.String())
+	}
+
+	outputLines := strings.Split(strings.Trim(buf.String(), "\n"), "\n")
+	expLines := 6
+	if len(outputLines) != expLines {
+		t.Fatalf("expected profile cmd output to emit %d output lines; got %d:\n%s", expLines, len(outputLines), buf.String())
+	}
+
+	// Only the profiled package is patched; its sibling package is cloned
+	// together with the rest of the module so the build can still resolve it
+	expText := "profile: updated 1 files and applied 4 patches"
+	if outputLines[2] != expText {
+		t.Errorf("[output line 2] expected text to match %q; got %q", expText, outputLines[2])
+	}
+
+	profiles, err := filepath.Glob(outputDir + "/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 1 {
+		t.Errorf("expected patched project to emit 1 profile; got %d", len(profiles))
+	}
+}
+
+func TestProfileWithOverlay(t *testing.T) {
+	specs := []struct {
+		Module bool
+	}{
+		{false},
+		{true},
+	}
+
+	for specIndex, spec := range specs {
+		var projDir, target, cleanupDir string
+		if spec.Module {
+			restoreModuleMode := enableModuleMode()
+			defer restoreModuleMode()
+
+			modDir, modName := mockModule(t)
+			projDir, target, cleanupDir = modDir+"cmd/", modName+"/cmd/main", modDir
+		} else {
+			wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, true)
+			projDir, target, cleanupDir = pkgDir, pkgName+"/main", wsDir
+		}
+		defer os.RemoveAll(cleanupDir)
+
+		outputDir, err := ioutil.TempDir("", "prism-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(outputDir)
+
+		origSrc, err := ioutil.ReadFile(projDir + "src.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Mock args
+		set := flag.NewFlagSet("test", 0)
+		set.String("profile-dir", outputDir, "")
+		set.String("output-dir", outputDir, "")
+		set.String("build-cmd", "go build -o "+outputDir+"/artifact", "")
+		set.String("run-cmd", outputDir+"/artifact", "")
+		set.String("callgraph-algorithm", "rta", "")
+		set.Bool("no-ansi", true, "")
+		set.Bool("overlay", true, "")
+		set.Parse([]string{projDir})
+		targets := cli.StringSlice{target}
+		targetFlag := &cli.StringSliceFlag{
+			Name:  "profile-target",
+			Value: &targets,
+		}
+		targetFlag.Apply(set)
+		ctx := cli.NewContext(nil, set, nil)
+
+		// Redirect stdout and stderr
+		stdOut := os.Stdout
+		stdErr := os.Stderr
+		pRead, pWrite, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = pWrite
+		os.Stderr = pWrite
+
+		// Profile package and capture output
+		err = ProfileProject(ctx)
+
+		// Drain pipe and restore stdout
+		var buf bytes.Buffer
+		pWrite.Close()
+		io.Copy(&buf, pRead)
+		pRead.Close()
+		os.Stdout = stdOut
+		os.Stderr = stdErr
+
+		if err != nil {
+			t.Fatalf("[spec %d] %v; output:\n%s", specIndex, err, buf.String())
+		}
+
+		outputLines := strings.Split(strings.Trim(buf.String(), "\n"), "\n")
+		expLines := 6
+		if len(outputLines) != expLines {
+			t.Fatalf("[spec %d] expected profile cmd output to emit %d output lines; got %d:\n%s", specIndex, expLines, len(outputLines), buf.String())
+		}
+
+		if !strings.HasPrefix(outputLines[2], "profile: using overlay ") {
+			t.Errorf("[spec %d] expected output line 2 to report the overlay file; got %q", specIndex, outputLines[2])
+		}
+
+		src, err := ioutil.ReadFile(projDir + "src.go")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(src) != string(origSrc) {
+			t.Errorf("[spec %d] expected project sources not to be modified", specIndex)
+		}
+
+		profiles, err := filepath.Glob(outputDir + "/*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(profiles) != 1 {
+			t.Errorf("[spec %d] expected patched project to emit 1 profile; got %d", specIndex, len(profiles))
+		}
+	}
+}
+
+func TestProfileTests(t *testing.T) {
+	specs := []struct {
+		TestSrc string
+	}{
+		// No TestMain; prism should generate one
+		{`
+package main
+
+import "testing"
+
+func TestDoStuff(t *testing.T){
+	DoStuff()
+}
+`},
+		// TestMain exiting via os.Exit which skips deferred calls
+		{`
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M){
+	os.Exit(m.Run())
+}
+
+func TestDoStuff(t *testing.T){
+	DoStuff()
+}
+`},
+	}
+
+	for specIndex, spec := range specs {
+		wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, true)
+		defer os.RemoveAll(wsDir)
+
+		err := ioutil.WriteFile(pkgDir+"src_test.go", []byte(spec.TestSrc), os.ModePerm)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputDir, err := ioutil.TempDir("", "prism-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(outputDir)
+
+		// Mock args
+		set := flag.NewFlagSet("test", 0)
+		set.String("profile-dir", outputDir, "")
+		set.String("test", "TestDoStuff", "")
+		set.String("callgraph-algorithm", "rta", "")
+		set.Bool("no-ansi", true, "")
+		set.Parse([]string{pkgDir})
+		targets := cli.StringSlice{pkgName + "/DoStuff"}
+		targetFlag := &cli.StringSliceFlag{
+			Name:  "profile-target",
+			Value: &targets,
+		}
+		targetFlag.Apply(set)
+		ctx := cli.NewContext(nil, set, nil)
+
+		// Redirect stdout and stderr
+		stdOut := os.Stdout
+		stdErr := os.Stderr
+		pRead, pWrite, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Stdout = pWrite
+		os.Stderr = pWrite
+
+		// Profile package tests and capture output
+		err = ProfileProject(ctx)
+
+		// Drain pipe and restore stdout
+		var buf bytes.Buffer
+		pWrite.Close()
+		io.Copy(&buf, pRead)
+		pRead.Close()
+		os.Stdout = stdOut
+		os.Stderr = stdErr
+
+		if err != nil {
+			t.Fatalf("[spec %d] %v; output:\n%s", specIndex, err, buf.String())
+		}
+
+		expText := "profile: running patched project (go test -run TestDoStuff)"
+		if !strings.Contains(buf.String(), expText) {
+			t.Errorf("[spec %d] expected output to contain %q; got:\n%s", specIndex, expText, buf.String())
+		}
+
+		profiles, err := filepath.Glob(outputDir + "/*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(profiles) != 1 {
+			t.Errorf("[spec %d] expected patched tests to emit 1 profile; got %d; output:\n%s", specIndex, len(profiles), buf.String())
+		}
+	}
+}
+
+func TestTestRunCmd(t *testing.T) {
+	specs := []struct {
+		TestRegex  string
+		BenchRegex string
+		ExpCmd     string
+	}{
+		{"TestFoo", "", "go test -run TestFoo"},
+		{"", "BenchmarkFoo", "go test -run ^$ -bench BenchmarkFoo"},
+		{"TestFoo", ".", "go test -run TestFoo -bench ."},
+	}
+
+	for specIndex, spec := range specs {
+		runCmd := testRunCmd(spec.TestRegex, spec.BenchRegex)
+		if runCmd != spec.ExpCmd {
+			t.Errorf("[spec %d] expected run cmd to be %q; got %q", specIndex, spec.ExpCmd, runCmd)
+		}
+	}
+}
+
+func TestFilterIgnoredTargets(t *testing.T) {
+	targets := []tools.ProfileTarget{
+		{QualifiedName: "foo/A"},
+		{QualifiedName: "foo/B"},
+		{QualifiedName: "foo/C"},
+	}
+
+	kept, skipped := filterIgnoredTargets(targets, []string{"foo/B", "foo/D"})
+
+	expKept := []string{"foo/A", "foo/C"}
+	if len(kept) != len(expKept) {
+		t.Fatalf("expected %d targets to be kept; got %d", len(expKept), len(kept))
+	}
+	for index, expName := range expKept {
+		if kept[index].QualifiedName != expName {
+			t.Errorf("expected kept target %d to be %q; got %q", index, expName, kept[index].QualifiedName)
+		}
+	}
+
+	if len(skipped) != 1 || skipped[0] != "foo/B" {
+		t.Errorf("expected skipped targets to be [foo/B]; got %v", skipped)
+	}
+}
+
+func TestOverrideEnv(t *testing.T) {
+	env := []string{"GOPATH=/foo", "HOME=/home/foo"}
+	env = overrideEnv(env, "GOPATH=/bar", "GO111MODULE=off")
+
+	expEnv := []string{"GOPATH=/bar", "HOME=/home/foo", "GO111MODULE=off"}
+	if len(env) != len(expEnv) {
+		t.Fatalf("expected env to contain %d entries; got %v", len(expEnv), env)
+	}
+
+	for index, expVar := range expEnv {
+		if env[index] != expVar {
+			t.Errorf("[env %d] expected %q; got %q", index, expVar, env[index])
+		}
+	}
+}
+
 func mockPackageWithVendoredDeps(t *testing.T, useGodeps bool) (workspaceDir, pkgDir, pkgName string) {
 	var otherPkgName string
 	pkgName = "prism-mock"
@@ -152,3 +509,81 @@ func main(){
 	pkgDir = workspaceDir + "/src/" + pkgName + "/"
 	return workspaceDir, pkgDir, pkgName
 }
+
+func mockModule(t *testing.T) (modDir, pkgName string) {
+	pkgName = "example.com/prism-mock"
+	pkgData := map[string]string{
+		"go.mod": `
+module ` + pkgName + `
+
+go 1.16
+`,
+		"other/src.go": `
+package other
+
+func DoStuff(){
+}
+	`,
+		"cmd/src.go": `
+package main
+
+import other "` + pkgName + `/other"
+
+type A struct {
+}
+
+func(a *A) DoStuff(){
+	// Call to module sub-package
+	other.DoStuff()
+}
+
+func DoStuff(){
+	a := &A{}
+	a.DoStuff()
+
+	// The callgraph generator should not visit this function a second time
+	a.DoStuff()
+}
+
+func main(){
+	DoStuff()
+}
+`,
+	}
+
+	modDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modDir += "/"
+
+	for name, src := range pkgData {
+		err = os.MkdirAll(filepath.Dir(modDir+name), os.ModeDir|os.ModePerm)
+		if err != nil {
+			os.RemoveAll(modDir)
+			t.Fatalf("error creating module folder for file %q: %s", name, err)
+		}
+
+		err = ioutil.WriteFile(modDir+name, []byte(src), os.ModePerm)
+		if err != nil {
+			os.RemoveAll(modDir)
+			t.Fatalf("error creating module contents for file %q: %s", name, err)
+		}
+	}
+
+	return modDir, pkgName
+}
+
+// Enable module mode for the duration of a test and return a func for
+// restoring the previous GO111MODULE value.
+func enableModuleMode() func() {
+	prevValue, wasSet := os.LookupEnv("GO111MODULE")
+	os.Setenv("GO111MODULE", "on")
+	return func() {
+		if wasSet {
+			os.Setenv("GO111MODULE", prevValue)
+		} else {
+			os.Unsetenv("GO111MODULE")
+		}
+	}
+}
@@ -3,7 +3,12 @@ package cmd
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/geckoboard/prism/profiler"
 )
 
 // A typed value to indicate which table columns should be included in the output.
@@ -21,29 +26,116 @@ const (
 	tableColP90
 	tableColP99
 	tableColStdDev
+	tableColWait
+	tableColSelf
+	tableColSelfMin
+	tableColSelfMax
+	tableColSelfMean
+	tableColSelfMedian
+	tableColSelfP50
+	tableColSelfP75
+	tableColSelfP90
+	tableColSelfP99
+	tableColPanics
+	tableColErrors
+	tableColErrorRate
+	tableColAllocBytes
+	tableColAllocBytesMin
+	tableColAllocBytesMax
+	tableColAllocBytesMean
+	tableColAllocBytesMedian
+	tableColAllocBytesP50
+	tableColAllocBytesP75
+	tableColAllocBytesP90
+	tableColAllocBytesP99
+	tableColAllocObjects
+	tableColAllocObjectsMin
+	tableColAllocObjectsMax
+	tableColAllocObjectsMean
+	tableColAllocObjectsMedian
+	tableColAllocObjectsP50
+	tableColAllocObjectsP75
+	tableColAllocObjectsP90
+	tableColAllocObjectsP99
+	tableColCPU
+	tableColCPUMean
+	tableColOffCPU
+	tableColOffCPUMean
 	// a sentinel value allowing us to iterate all valid table column types
 	numTableColumns
 )
 
+// Columns for arbitrary time percentiles (e.g. p95 or self_p999) are encoded
+// by setting the tableColPercentile bit and packing the self time flag, the
+// number of percentile digits and the digits value into the lower bits.
+const (
+	tableColPercentile     tableColumnType = 1 << 30
+	tableColSelfPercentile tableColumnType = 1 << 29
+
+	percentileDigitCountShift = 24
+	percentileDigitCountMask  = 0x7
+	percentileValueMask       = 1<<percentileDigitCountShift - 1
+)
+
 var (
-	tableColSplitRegex = regexp.MustCompile(`\s*,\s*`)
-	tableColTypeToName = map[tableColumnType]string{
-		tableColTotal:       "total",
-		tableColMin:         "min",
-		tableColMax:         "max",
-		tableColMean:        "mean",
-		tableColMedian:      "median",
-		tableColInvocations: "invocations",
-		tableColP50:         "p50",
-		tableColP75:         "p75",
-		tableColP90:         "p90",
-		tableColP99:         "p99",
-		tableColStdDev:      "stddev",
+	tableColSplitRegex      = regexp.MustCompile(`\s*,\s*`)
+	tableColPercentileRegex = regexp.MustCompile(`^(self_)?p(\d{1,6})$`)
+	tableColTypeToName      = map[tableColumnType]string{
+		tableColTotal:              "total",
+		tableColMin:                "min",
+		tableColMax:                "max",
+		tableColMean:               "mean",
+		tableColMedian:             "median",
+		tableColInvocations:        "invocations",
+		tableColP50:                "p50",
+		tableColP75:                "p75",
+		tableColP90:                "p90",
+		tableColP99:                "p99",
+		tableColStdDev:             "stddev",
+		tableColWait:               "wait",
+		tableColSelf:               "self",
+		tableColSelfMin:            "self_min",
+		tableColSelfMax:            "self_max",
+		tableColSelfMean:           "self_mean",
+		tableColSelfMedian:         "self_median",
+		tableColSelfP50:            "self_p50",
+		tableColSelfP75:            "self_p75",
+		tableColSelfP90:            "self_p90",
+		tableColSelfP99:            "self_p99",
+		tableColPanics:             "panics",
+		tableColErrors:             "errors",
+		tableColErrorRate:          "error_rate",
+		tableColAllocBytes:         "alloc_bytes",
+		tableColAllocBytesMin:      "alloc_bytes_min",
+		tableColAllocBytesMax:      "alloc_bytes_max",
+		tableColAllocBytesMean:     "alloc_bytes_mean",
+		tableColAllocBytesMedian:   "alloc_bytes_median",
+		tableColAllocBytesP50:      "alloc_bytes_p50",
+		tableColAllocBytesP75:      "alloc_bytes_p75",
+		tableColAllocBytesP90:      "alloc_bytes_p90",
+		tableColAllocBytesP99:      "alloc_bytes_p99",
+		tableColAllocObjects:       "alloc_objects",
+		tableColAllocObjectsMin:    "alloc_objects_min",
+		tableColAllocObjectsMax:    "alloc_objects_max",
+		tableColAllocObjectsMean:   "alloc_objects_mean",
+		tableColAllocObjectsMedian: "alloc_objects_median",
+		tableColAllocObjectsP50:    "alloc_objects_p50",
+		tableColAllocObjectsP75:    "alloc_objects_p75",
+		tableColAllocObjectsP90:    "alloc_objects_p90",
+		tableColAllocObjectsP99:    "alloc_objects_p99",
+		tableColCPU:                "cpu",
+		tableColCPUMean:            "cpu_mean",
+		tableColOffCPU:             "off_cpu",
+		tableColOffCPUMean:         "off_cpu_mean",
 	}
 )
 
 // Header returns the table header description for this column type.
 func (dc tableColumnType) Header() string {
+	if dc.IsPercentile() {
+		return strings.Replace(dc.Name(), "_", " ", -1)
+	}
+
 	switch dc {
 	case tableColTotal:
 		return "total"
@@ -67,12 +159,219 @@ func (dc tableColumnType) Header() string {
 		return "p99"
 	case tableColStdDev:
 		return "stddev"
+	case tableColWait:
+		return "wait"
+	case tableColSelf:
+		return "self"
+	case tableColSelfMin:
+		return "self min"
+	case tableColSelfMax:
+		return "self max"
+	case tableColSelfMean:
+		return "self mean"
+	case tableColSelfMedian:
+		return "self median"
+	case tableColSelfP50:
+		return "self p50"
+	case tableColSelfP75:
+		return "self p75"
+	case tableColSelfP90:
+		return "self p90"
+	case tableColSelfP99:
+		return "self p99"
+	case tableColPanics:
+		return "panics"
+	case tableColErrors:
+		return "errors"
+	case tableColErrorRate:
+		return "error rate"
+	case tableColAllocBytes:
+		return "bytes"
+	case tableColAllocBytesMin:
+		return "bytes min"
+	case tableColAllocBytesMax:
+		return "bytes max"
+	case tableColAllocBytesMean:
+		return "bytes mean"
+	case tableColAllocBytesMedian:
+		return "bytes median"
+	case tableColAllocBytesP50:
+		return "bytes p50"
+	case tableColAllocBytesP75:
+		return "bytes p75"
+	case tableColAllocBytesP90:
+		return "bytes p90"
+	case tableColAllocBytesP99:
+		return "bytes p99"
+	case tableColAllocObjects:
+		return "objects"
+	case tableColAllocObjectsMin:
+		return "objects min"
+	case tableColAllocObjectsMax:
+		return "objects max"
+	case tableColAllocObjectsMean:
+		return "objects mean"
+	case tableColAllocObjectsMedian:
+		return "objects median"
+	case tableColAllocObjectsP50:
+		return "objects p50"
+	case tableColAllocObjectsP75:
+		return "objects p75"
+	case tableColAllocObjectsP90:
+		return "objects p90"
+	case tableColAllocObjectsP99:
+		return "objects p99"
+	case tableColCPU:
+		return "cpu"
+	case tableColCPUMean:
+		return "cpu mean"
+	case tableColOffCPU:
+		return "off-cpu"
+	case tableColOffCPUMean:
+		return "off-cpu mean"
 	}
 	panic("unsupported column type")
 }
 
+// IsAlloc returns true if this column type refers to an allocation metric.
+func (dc tableColumnType) IsAlloc() bool {
+	return dc >= tableColAllocBytes && dc <= tableColAllocObjectsP99
+}
+
+// IsAllocBytes returns true if this column type refers to an allocated bytes metric.
+func (dc tableColumnType) IsAllocBytes() bool {
+	return dc >= tableColAllocBytes && dc <= tableColAllocBytesP99
+}
+
+// AllocValue returns the value of an allocation metric column for the given
+// call metrics. It returns 0 for any other column type.
+func (dc tableColumnType) AllocValue(metrics *profiler.CallMetrics) uint64 {
+	switch dc {
+	case tableColAllocBytes:
+		return metrics.AllocBytes
+	case tableColAllocBytesMin:
+		return metrics.MinAllocBytes
+	case tableColAllocBytesMax:
+		return metrics.MaxAllocBytes
+	case tableColAllocBytesMean:
+		return metrics.MeanAllocBytes
+	case tableColAllocBytesMedian:
+		return metrics.MedianAllocBytes
+	case tableColAllocBytesP50:
+		return metrics.P50AllocBytes
+	case tableColAllocBytesP75:
+		return metrics.P75AllocBytes
+	case tableColAllocBytesP90:
+		return metrics.P90AllocBytes
+	case tableColAllocBytesP99:
+		return metrics.P99AllocBytes
+	case tableColAllocObjects:
+		return metrics.AllocObjects
+	case tableColAllocObjectsMin:
+		return metrics.MinAllocObjects
+	case tableColAllocObjectsMax:
+		return metrics.MaxAllocObjects
+	case tableColAllocObjectsMean:
+		return metrics.MeanAllocObjects
+	case tableColAllocObjectsMedian:
+		return metrics.MedianAllocObjects
+	case tableColAllocObjectsP50:
+		return metrics.P50AllocObjects
+	case tableColAllocObjectsP75:
+		return metrics.P75AllocObjects
+	case tableColAllocObjectsP90:
+		return metrics.P90AllocObjects
+	case tableColAllocObjectsP99:
+		return metrics.P99AllocObjects
+	}
+	return 0
+}
+
+// FormatAlloc formats the value of an allocation metric column.
+func (dc tableColumnType) FormatAlloc(val uint64) string {
+	if dc.IsAllocBytes() {
+		return humanize.IBytes(val)
+	}
+	return humanize.Comma(int64(val))
+}
+
+// IsPercentile returns true if this column type refers to an arbitrary time
+// percentile which is estimated from the time histograms of a call.
+func (dc tableColumnType) IsPercentile() bool {
+	return dc&tableColPercentile != 0
+}
+
+// Create a percentile column type for the given percentile digits. One or two
+// digits specify an integer percentile (e.g. p5 = 5%, p95 = 95%) and p100
+// selects the max value. Longer digit sequences must start with 99 and any
+// digits after it specify its fractional part (e.g. p999 = 99.9%). To ensure
+// that each percentile has a single column name, leading zeros and trailing
+// fractional zeros (e.g. p05 or p9990) are rejected.
+func makePercentileColumn(digits string, self bool) (tableColumnType, error) {
+	switch {
+	case len(digits) > 1 && digits[0] == '0',
+		len(digits) > 2 && digits != "100" && (!strings.HasPrefix(digits, "99") || strings.HasSuffix(digits, "0")):
+		return 0, fmt.Errorf("invalid percentile %q; use p0-p99, p100 or p99 followed by fractional digits (e.g. p999 for 99.9%%)", digits)
+	}
+
+	value, _ := strconv.Atoi(digits)
+	dc := tableColPercentile | tableColumnType(len(digits))<<percentileDigitCountShift | tableColumnType(value)
+	if self {
+		dc |= tableColSelfPercentile
+	}
+	return dc, nil
+}
+
+// Get the percentile digits for a percentile column type.
+func (dc tableColumnType) percentileDigits() string {
+	numDigits := int(dc>>percentileDigitCountShift) & percentileDigitCountMask
+	return fmt.Sprintf("%0*d", numDigits, int(dc&percentileValueMask))
+}
+
+// Quantile returns the quantile (in the [0, 1] range) that corresponds to a
+// percentile column type.
+func (dc tableColumnType) Quantile() float64 {
+	digits := dc.percentileDigits()
+	if digits == "100" {
+		return 1
+	}
+
+	percent := digits
+	if len(digits) > 2 {
+		percent = digits[:2] + "." + digits[2:]
+	}
+	val, _ := strconv.ParseFloat(percent, 64)
+	return val / 100
+}
+
+// TotalPercentile returns the total time variant of a percentile column type.
+func (dc tableColumnType) TotalPercentile() tableColumnType {
+	return dc &^ tableColSelfPercentile
+}
+
+// PercentileValue estimates the time percentile for a percentile column from
+// the time histograms of the given call metrics. It returns false if the call
+// metrics do not include the required histogram (e.g. for profiles captured
+// by older prism versions).
+func (dc tableColumnType) PercentileValue(metrics *profiler.CallMetrics) (time.Duration, bool) {
+	histogram := metrics.TimeHistogram
+	if dc&tableColSelfPercentile != 0 {
+		histogram = metrics.SelfTimeHistogram
+	}
+	if histogram == nil {
+		return 0, false
+	}
+	return histogram.Quantile(dc.Quantile()), true
+}
+
 // Name returns a string representation of this column's type.
 func (dc tableColumnType) Name() string {
+	if dc.IsPercentile() {
+		if dc&tableColSelfPercentile != 0 {
+			return "self_p" + dc.percentileDigits()
+		}
+		return "p" + dc.percentileDigits()
+	}
 	return tableColTypeToName[dc]
 }
 
@@ -89,6 +388,16 @@ func parseTableColumList(list string) ([]tableColumnType, error) {
 			}
 		}
 
+		// Check for an arbitrary time percentile column
+		if match := tableColPercentileRegex.FindStringSubmatch(colName); !found && match != nil {
+			colType, err := makePercentileColumn(match[2], match[1] != "")
+			if err != nil {
+				return nil, fmt.Errorf("unsupported column name %q: %s", colName, err)
+			}
+			cols = append(cols, colType)
+			found = true
+		}
+
 		if !found {
 			return nil, fmt.Errorf("unsupported column name %q; supported column names are: %s", colName, SupportedColumnNames())
 		}
@@ -2,22 +2,60 @@ package cmd
 
 import (
 	"fmt"
+	"math"
 	"testing"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
 )
 
 func TestParseTableColumnList(t *testing.T) {
 	colNamesToHeaderNames := map[string]string{
-		"total":       "total",
-		"min":         "min",
-		"max":         "max",
-		"mean":        "mean",
-		"median":      "median",
-		"invocations": "invoc",
-		"p50":         "p50",
-		"p75":         "p75",
-		"p90":         "p90",
-		"p99":         "p99",
-		"stddev":      "stddev",
+		"total":                "total",
+		"min":                  "min",
+		"max":                  "max",
+		"mean":                 "mean",
+		"median":               "median",
+		"invocations":          "invoc",
+		"p50":                  "p50",
+		"p75":                  "p75",
+		"p90":                  "p90",
+		"p99":                  "p99",
+		"stddev":               "stddev",
+		"wait":                 "wait",
+		"self":                 "self",
+		"self_min":             "self min",
+		"self_max":             "self max",
+		"self_mean":            "self mean",
+		"self_median":          "self median",
+		"self_p50":             "self p50",
+		"self_p75":             "self p75",
+		"self_p90":             "self p90",
+		"self_p99":             "self p99",
+		"panics":               "panics",
+		"errors":               "errors",
+		"error_rate":           "error rate",
+		"alloc_bytes":          "bytes",
+		"alloc_bytes_min":      "bytes min",
+		"alloc_bytes_max":      "bytes max",
+		"alloc_bytes_mean":     "bytes mean",
+		"alloc_bytes_median":   "bytes median",
+		"alloc_bytes_p50":      "bytes p50",
+		"alloc_bytes_p75":      "bytes p75",
+		"alloc_bytes_p90":      "bytes p90",
+		"alloc_bytes_p99":      "bytes p99",
+		"alloc_objects":        "objects",
+		"alloc_objects_min":    "objects min",
+		"alloc_objects_max":    "objects max",
+		"alloc_objects_mean":   "objects mean",
+		"alloc_objects_median": "objects median",
+		"alloc_objects_p50":    "objects p50",
+		"alloc_objects_p75":    "objects p75",
+		"alloc_objects_p90":    "objects p90",
+		"alloc_objects_p99":    "objects p99",
+		"p95":                  "p95",
+		"p999":                 "p999",
+		"self_p95":             "self p95",
 	}
 
 	for colName, expHeader := range colNamesToHeaderNames {
@@ -38,6 +76,69 @@ func TestParseTableColumnList(t *testing.T) {
 	}
 }
 
+func TestPercentileColumns(t *testing.T) {
+	timeHistogram := profiler.NewHistogram()
+	selfTimeHistogram := profiler.NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		timeHistogram.Record(time.Duration(i) * time.Millisecond)
+		selfTimeHistogram.Record(time.Duration(i) * time.Microsecond)
+	}
+	metrics := &profiler.CallMetrics{
+		TimeHistogram:     timeHistogram,
+		SelfTimeHistogram: selfTimeHistogram,
+	}
+
+	specs := []struct {
+		ColName     string
+		ExpQuantile float64
+		ExpValue    time.Duration
+	}{
+		{"p5", 0.05, 50 * time.Millisecond},
+		{"p95", 0.95, 950 * time.Millisecond},
+		{"p999", 0.999, 999 * time.Millisecond},
+		{"p9995", 0.9995, 1000 * time.Millisecond},
+		{"p100", 1, 1000 * time.Millisecond},
+		{"self_p0", 0, 1 * time.Microsecond},
+		{"self_p9", 0.09, 90 * time.Microsecond},
+		{"self_p999999", 0.999999, 1000 * time.Microsecond},
+	}
+
+	for specIndex, spec := range specs {
+		colTypes, err := parseTableColumList(spec.ColName)
+		if err != nil {
+			t.Errorf("[spec %d] error parsing col list %q: %v", specIndex, spec.ColName, err)
+			continue
+		}
+
+		colType := colTypes[0]
+		if !colType.IsPercentile() || colType.IsAlloc() {
+			t.Errorf("[spec %d] expected column %q to be a percentile column", specIndex, spec.ColName)
+		}
+		if colType.Name() != spec.ColName {
+			t.Errorf("[spec %d] expected column name to be %q; got %q", specIndex, spec.ColName, colType.Name())
+		}
+		if q := colType.Quantile(); math.Abs(q-spec.ExpQuantile) > 1e-9 {
+			t.Errorf("[spec %d] expected column %q quantile to be %v; got %v", specIndex, spec.ColName, spec.ExpQuantile, q)
+		}
+
+		val, tracked := colType.PercentileValue(metrics)
+		if relErr := float64(val-spec.ExpValue) / float64(spec.ExpValue); !tracked || relErr < -0.01 || relErr > 0.01 {
+			t.Errorf("[spec %d] expected column %q value to be within 1%% of %v; got %v", specIndex, spec.ColName, spec.ExpValue, val)
+		}
+
+		if _, tracked = colType.PercentileValue(&profiler.CallMetrics{}); tracked {
+			t.Errorf("[spec %d] expected column %q value to be untracked for metrics without histograms", specIndex, spec.ColName)
+		}
+	}
+
+	// Percentiles with more than one column name should be rejected
+	for _, colName := range []string{"p", "p1234567", "self_p", "pp95", "p05", "p00", "p1000", "p950", "p9990", "p101", "self_p500"} {
+		if _, err := parseTableColumList(colName); err == nil {
+			t.Errorf("expected an error parsing col list %q", colName)
+		}
+	}
+}
+
 func TestParseTableColumnListError(t *testing.T) {
 	_, err := parseTableColumList("total,     unknown")
 	expError := fmt.Sprintf(`unsupported column name "unknown"; supported column names are: %s`, SupportedColumnNames())
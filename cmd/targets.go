@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/geckoboard/prism/tools"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var anonFuncSuffixRegex = regexp.MustCompile(`(\.func\d+)+$`)
+
+// ListTargets analyzes a go package and lists the fully qualified names of
+// the functions that can be used as profile targets. If the callgraph option
+// is specified, the callgraph for the matching targets is displayed instead.
+func ListTargets(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 1 {
+		return errMissingPathToProject
+	}
+
+	absProjPath, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	absProjPath += "/"
+
+	// Analyze project
+	goPackage, err := tools.NewGoPackage(absProjPath)
+	if err != nil {
+		return err
+	}
+
+	if cgTarget := ctx.String("callgraph"); cgTarget != "" {
+		cgOpts, err := parseCallGraphOptions(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, cgOpts.ignore, err = goPackage.Directives(nil)
+		if err != nil {
+			return err
+		}
+
+		profileTargets, err := goPackage.Find(cgTarget)
+		if err != nil {
+			return err
+		}
+
+		cgOpts.apply(profileTargets)
+		for index, target := range profileTargets {
+			if index > 0 {
+				fmt.Fprintln(os.Stdout)
+			}
+			writeCallGraph(os.Stdout, target)
+		}
+		return nil
+	}
+
+	candidates, err := goPackage.Candidates(ctx.String("filter"))
+	if err != nil {
+		return err
+	}
+
+	writeTargetList(os.Stdout, candidates)
+	return nil
+}
+
+// targetGroup collects the functions and methods of a package that can be
+// used as profile targets.
+type targetGroup struct {
+	pkgName string
+
+	// Functions without a receiver.
+	funcs []string
+
+	// Method names indexed by receiver type.
+	methods map[string][]string
+}
+
+// Group the list of qualified target names by package and receiver and write
+// them to w. Packages and receivers are listed in alphabetical order.
+func writeTargetList(w io.Writer, candidates []string) {
+	groups := groupTargets(candidates)
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s\n", group.pkgName)
+		for _, funcName := range group.funcs {
+			fmt.Fprintf(w, "  %s\n", funcName)
+		}
+
+		receivers := make([]string, 0, len(group.methods))
+		for receiver := range group.methods {
+			receivers = append(receivers, receiver)
+		}
+		sort.Strings(receivers)
+
+		for _, receiver := range receivers {
+			fmt.Fprintf(w, "  type %s\n", receiver)
+			for _, methodName := range group.methods[receiver] {
+				fmt.Fprintf(w, "    %s\n", methodName)
+			}
+		}
+	}
+}
+
+// Split the list of qualified target names into per-package groups. The
+// returned groups are sorted by package name.
+func groupTargets(candidates []string) []*targetGroup {
+	groupMap := make(map[string]*targetGroup, 0)
+	groups := make([]*targetGroup, 0)
+	for _, candidate := range candidates {
+		pkgName, receiver, funcName := splitTargetName(candidate)
+
+		group, exists := groupMap[pkgName]
+		if !exists {
+			group = &targetGroup{
+				pkgName: pkgName,
+				funcs:   make([]string, 0),
+				methods: make(map[string][]string, 0),
+			}
+			groupMap[pkgName] = group
+			groups = append(groups, group)
+		}
+
+		if receiver == "" {
+			group.funcs = append(group.funcs, funcName)
+		} else {
+			group.methods[receiver] = append(group.methods[receiver], funcName)
+		}
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].pkgName < groups[j].pkgName
+	})
+
+	return groups
+}
+
+// Split a qualified target name (e.g. "github.com/foo/bar/Baz.Do") into its
+// package, receiver and function name components. For functions without a
+// receiver, the returned receiver name is empty. Any anonymous function
+// suffixes (e.g. ".func1") are retained as part of the function name.
+func splitTargetName(target string) (pkgName, receiver, funcName string) {
+	pkgEnd := strings.LastIndex(target, "/")
+	if pkgEnd != -1 {
+		pkgName = target[:pkgEnd]
+	}
+	funcName = target[pkgEnd+1:]
+
+	anonSuffix := anonFuncSuffixRegex.FindString(funcName)
+	funcName = funcName[:len(funcName)-len(anonSuffix)]
+
+	if dotIndex := strings.Index(funcName, "."); dotIndex != -1 {
+		receiver = funcName[:dotIndex]
+		funcName = funcName[dotIndex+1:]
+	}
+
+	return pkgName, receiver, funcName + anonSuffix
+}
+
+// Write the callgraph for a profile target as a tree to w. Each node is
+// prefixed with its depth relative to the target.
+func writeCallGraph(w io.Writer, target tools.ProfileTarget) {
+	for _, cgNode := range target.CallGraph() {
+		fmt.Fprintf(w, "%s[%d] %s\n", strings.Repeat("| ", cgNode.Depth), cgNode.Depth, cgNode.Name)
+	}
+}
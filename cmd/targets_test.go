@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+func TestListTargets(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, false)
+	defer os.RemoveAll(wsDir)
+
+	specs := []struct {
+		Filter   string
+		ExpLines []string
+	}{
+		{
+			"",
+			[]string{
+				pkgName,
+				"  DoStuff",
+				"  init",
+				"  main",
+				"  type A",
+				"    DoStuff",
+				pkgName + "/vendor/other/pkg",
+				"  DoStuff",
+				"  init",
+			},
+		},
+		{
+			"DoStuff",
+			[]string{
+				pkgName,
+				"  DoStuff",
+				"  type A",
+				"    DoStuff",
+				pkgName + "/vendor/other/pkg",
+				"  DoStuff",
+			},
+		},
+		{
+			"*/A.*",
+			[]string{
+				pkgName,
+				"  type A",
+				"    DoStuff",
+			},
+		},
+		{
+			"re:.*/(main|init)",
+			[]string{
+				pkgName,
+				"  init",
+				"  main",
+				pkgName + "/vendor/other/pkg",
+				"  init",
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		set := flag.NewFlagSet("test", 0)
+		set.String("filter", spec.Filter, "")
+		set.Parse([]string{pkgDir})
+		ctx := cli.NewContext(nil, set, nil)
+
+		output, err := captureTargetsOutput(ctx)
+		if err != nil {
+			t.Errorf("[spec %d] %s", specIndex, err)
+			continue
+		}
+
+		outputLines := strings.Split(strings.Trim(output, "\n"), "\n")
+		if len(outputLines) != len(spec.ExpLines) {
+			t.Errorf("[spec %d] expected targets cmd output to emit %d lines; got %d:\n%s", specIndex, len(spec.ExpLines), len(outputLines), output)
+			continue
+		}
+
+		for lineIndex, expLine := range spec.ExpLines {
+			if outputLines[lineIndex] != expLine {
+				t.Errorf("[spec %d] expected output line %d to be %q; got %q", specIndex, lineIndex, expLine, outputLines[lineIndex])
+			}
+		}
+	}
+}
+
+func TestListTargetsCallGraph(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, false)
+	defer os.RemoveAll(wsDir)
+
+	set := flag.NewFlagSet("test", 0)
+	set.String("callgraph", pkgName+"/main", "")
+	set.String("callgraph-algorithm", "rta", "")
+	set.Parse([]string{pkgDir})
+	ctx := cli.NewContext(nil, set, nil)
+
+	output, err := captureTargetsOutput(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expLines := []string{
+		"[0] " + pkgName + "/main",
+		"| [1] " + pkgName + "/DoStuff",
+		"| | [2] " + pkgName + "/A.DoStuff",
+		"| | | [3] " + pkgName + "/vendor/other/pkg/DoStuff",
+	}
+
+	outputLines := strings.Split(strings.Trim(output, "\n"), "\n")
+	if len(outputLines) != len(expLines) {
+		t.Fatalf("expected targets cmd output to emit %d lines; got %d:\n%s", len(expLines), len(outputLines), output)
+	}
+
+	for lineIndex, expLine := range expLines {
+		if outputLines[lineIndex] != expLine {
+			t.Errorf("expected output line %d to be %q; got %q", lineIndex, expLine, outputLines[lineIndex])
+		}
+	}
+}
+
+func TestListTargetsPrunedCallGraph(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, false)
+	defer os.RemoveAll(wsDir)
+
+	specs := []struct {
+		MaxDepth int
+		Exclude  []string
+		ExpLines []string
+	}{
+		{
+			1,
+			[]string{},
+			[]string{
+				"[0] " + pkgName + "/main",
+				"| [1] " + pkgName + "/DoStuff",
+			},
+		},
+		{
+			0,
+			[]string{pkgName + "/A.*"},
+			[]string{
+				"[0] " + pkgName + "/main",
+				"| [1] " + pkgName + "/DoStuff",
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		set := flag.NewFlagSet("test", 0)
+		set.String("callgraph", pkgName+"/main", "")
+		set.String("callgraph-algorithm", "rta", "")
+		set.Int("max-depth", spec.MaxDepth, "")
+		set.Parse([]string{pkgDir})
+		exclude := cli.StringSlice(spec.Exclude)
+		excludeFlag := &cli.StringSliceFlag{
+			Name:  "exclude",
+			Value: &exclude,
+		}
+		excludeFlag.Apply(set)
+		ctx := cli.NewContext(nil, set, nil)
+
+		output, err := captureTargetsOutput(ctx)
+		if err != nil {
+			t.Errorf("[spec %d] %s", specIndex, err)
+			continue
+		}
+
+		outputLines := strings.Split(strings.Trim(output, "\n"), "\n")
+		if len(outputLines) != len(spec.ExpLines) {
+			t.Errorf("[spec %d] expected targets cmd output to emit %d lines; got %d:\n%s", specIndex, len(spec.ExpLines), len(outputLines), output)
+			continue
+		}
+
+		for lineIndex, expLine := range spec.ExpLines {
+			if outputLines[lineIndex] != expLine {
+				t.Errorf("[spec %d] expected output line %d to be %q; got %q", specIndex, lineIndex, expLine, outputLines[lineIndex])
+			}
+		}
+	}
+}
+
+func TestParseCallGraphOptions(t *testing.T) {
+	specs := []struct {
+		Algorithm string
+		MaxDepth  int
+		Exclude   []string
+		ExpErr    bool
+	}{
+		{"rta", 0, []string{"foo/*", "re:.*Bar"}, false},
+		{"invalid", 0, []string{}, true},
+		{"rta", -1, []string{}, true},
+		{"rta", 0, []string{"re:(unbalanced"}, true},
+	}
+
+	for specIndex, spec := range specs {
+		set := flag.NewFlagSet("test", 0)
+		set.String("callgraph-algorithm", spec.Algorithm, "")
+		set.Int("max-depth", spec.MaxDepth, "")
+		exclude := cli.StringSlice(spec.Exclude)
+		excludeFlag := &cli.StringSliceFlag{
+			Name:  "exclude",
+			Value: &exclude,
+		}
+		excludeFlag.Apply(set)
+		ctx := cli.NewContext(nil, set, nil)
+
+		_, err := parseCallGraphOptions(ctx)
+		if spec.ExpErr && err == nil {
+			t.Errorf("[spec %d] expected to get an error", specIndex)
+		} else if !spec.ExpErr && err != nil {
+			t.Errorf("[spec %d] expected no error; got %v", specIndex, err)
+		}
+	}
+}
+
+func TestListTargetsMissingPath(t *testing.T) {
+	set := flag.NewFlagSet("test", 0)
+	ctx := cli.NewContext(nil, set, nil)
+
+	err := ListTargets(ctx)
+	if err != errMissingPathToProject {
+		t.Fatalf("expected to get errMissingPathToProject; got %v", err)
+	}
+}
+
+func TestSplitTargetName(t *testing.T) {
+	specs := []struct {
+		In          string
+		ExpPkg      string
+		ExpReceiver string
+		ExpFunc     string
+	}{
+		{"github.com/foo/bar/Baz", "github.com/foo/bar", "", "Baz"},
+		{"github.com/foo/bar/Baz.Do", "github.com/foo/bar", "Baz", "Do"},
+		{"main", "", "", "main"},
+		{"github.com/foo/bar/Baz.Do.func1.func2", "github.com/foo/bar", "Baz", "Do.func1.func2"},
+		{"github.com/foo/bar/Do.func1", "github.com/foo/bar", "", "Do.func1"},
+	}
+
+	for specIndex, spec := range specs {
+		pkgName, receiver, funcName := splitTargetName(spec.In)
+		if pkgName != spec.ExpPkg || receiver != spec.ExpReceiver || funcName != spec.ExpFunc {
+			t.Errorf(
+				"[spec %d] expected split of %q to be (%q, %q, %q); got (%q, %q, %q)",
+				specIndex, spec.In,
+				spec.ExpPkg, spec.ExpReceiver, spec.ExpFunc,
+				pkgName, receiver, funcName,
+			)
+		}
+	}
+}
+
+// Run the targets command and capture its stdout output.
+func captureTargetsOutput(ctx *cli.Context) (string, error) {
+	stdOut := os.Stdout
+	pRead, pWrite, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	os.Stdout = pWrite
+
+	// Restore stdout incase of a panic
+	defer func() {
+		os.Stdout = stdOut
+	}()
+
+	err = ListTargets(ctx)
+
+	// Drain pipe and restore stdout
+	var buf bytes.Buffer
+	pWrite.Close()
+	io.Copy(&buf, pRead)
+	pRead.Close()
+	os.Stdout = stdOut
+
+	return buf.String(), err
+}
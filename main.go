@@ -23,6 +23,14 @@ func main() {
 
 			Action: cmd.ProfileProject,
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "path to a prism config file; defaults to the .prism.yaml file in the project root if present",
+				},
+				cli.StringFlag{
+					Name:  "scenario",
+					Usage: `the name of the profiling scenario to load from the config file; defaults to the "default" scenario if defined`,
+				},
 				cli.StringFlag{
 					Name:  "build-cmd",
 					Value: "",
@@ -33,6 +41,14 @@ func main() {
 					Value: `find . -d 1 -type f -name *\.go ! -name *_test\.go -exec go run {} +`,
 					Usage: "project run command",
 				},
+				cli.StringFlag{
+					Name:  "test",
+					Usage: "profile the package tests matching this regex instead of running main(); unless --run-cmd is specified, the tests are run using go test",
+				},
+				cli.StringFlag{
+					Name:  "bench",
+					Usage: "profile the package benchmarks matching this regex instead of running main(); unless --run-cmd is specified, the benchmarks are run using go test",
+				},
 				cli.StringFlag{
 					Name:  "output-dir, o",
 					Value: os.TempDir(),
@@ -42,20 +58,83 @@ func main() {
 					Name:  "preserve-output",
 					Usage: "preserve patched project post build",
 				},
+				cli.BoolFlag{
+					Name:  "overlay",
+					Usage: "instead of copying the project, write patched files to output-dir and build the project in place using go build -overlay (requires go 1.16+)",
+				},
 				cli.StringSliceFlag{
 					Name:  "profile-target, t",
 					Value: &cli.StringSlice{},
-					Usage: "fully qualified function name to profile",
+					Usage: `fully qualified function name to profile; glob patterns (e.g. "github.com/foo/*.Serve*") and regular expressions prefixed with "re:" are also supported. Functions marked with a //prism:profile comment directive are always profiled`,
+				},
+				cli.StringFlag{
+					Name:  "callgraph-algorithm",
+					Value: "rta",
+					Usage: "algorithm for discovering the functions reachable from the profile targets; supported options: rta, cha, vta, static",
+				},
+				cli.IntFlag{
+					Name:  "max-depth",
+					Usage: "only include functions up to this many calls away from the target in the callgraph; 0 disables the limit",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Value: &cli.StringSlice{},
+					Usage: `exclude functions matching this FQ name, glob pattern or "re:" prefixed regex and any functions only reachable through them from the callgraph; this option may be specified multiple times`,
+				},
+				cli.StringSliceFlag{
+					Name:  "env",
+					Value: &cli.StringSlice{},
+					Usage: "set an environment variable (KEY=VALUE) for the build and run commands; this option may be specified multiple times",
 				},
 				cli.StringFlag{
 					Name:  "profile-dir",
 					Usage: "specify the output dir for captured profiles",
 					Value: defaultOutputDir(),
 				},
+				cli.StringFlag{
+					Name:  "profile-sink",
+					Usage: "the URI of the sink for captured profiles; one of file:///path/to/dir, http(s)://host/path, unix:///path/to/socket, discard:, multi:uri1,uri2 (enclose URIs containing commas in parentheses) or aggregate:[interval:]uri. Overrides profile-dir; profiled binaries can also override it at runtime via the PRISM_SINK env var",
+				},
 				cli.StringFlag{
 					Name:  "profile-label",
 					Usage: `specify a label to be attached to captured profiles and displayed when using the "print" or "diff" commands`,
 				},
+				cli.BoolFlag{
+					Name:  "track-allocs",
+					Usage: "track the number of bytes and objects allocated by each profiled call; allocation counts are only accurate if the profiled code runs on a single goroutine. Tracking stops the world on every profiled call so the reported times are unreliable while it is enabled",
+				},
+				cli.IntFlag{
+					Name:  "track-allocs-every",
+					Usage: "only track allocations in 1 in every N profiles of each profile target; implies track-allocs and leaves the times of the remaining profiles unaffected",
+				},
+				cli.BoolFlag{
+					Name:  "track-cpu",
+					Usage: "track the CPU and off-CPU time of each profiled call (linux only); profiled goroutines are locked to their OS thread which slows down blocking operations in the profiled code",
+				},
+				cli.IntFlag{
+					Name:  "sample-every",
+					Usage: "only profile 1 in every N invocations of each profile target",
+				},
+				cli.IntFlag{
+					Name:  "max-profiles-per-sec",
+					Usage: "capture at most this many profiles per second for each profile target; 0 disables the limit",
+				},
+				cli.IntFlag{
+					Name:  "max-profiles",
+					Usage: "capture at most this many profiles in total for each profile target; 0 disables the limit",
+				},
+				cli.StringFlag{
+					Name:  "calibration-cache",
+					Usage: "cache the calibrated profiler overheads to this file. If left unspecified, the overheads are cached in the user cache folder",
+				},
+				cli.BoolFlag{
+					Name:  "no-calibration-cache",
+					Usage: "calibrate the profiler overheads each time the profiled binary starts instead of reading them from the calibration cache",
+				},
+				cli.BoolFlag{
+					Name:  "median-calibration",
+					Usage: "estimate the profiler overheads using the median of a set of calibration batches instead of the mean of a single calibration run",
+				},
 				cli.StringSliceFlag{
 					Name:  "profile-vendored-pkg",
 					Usage: "inject profile hooks to any vendored packages matching this regex. If left unspecified, no vendored packages will be hooked",
@@ -67,6 +146,37 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:        "targets",
+			Usage:       "list functions that can be used as profile targets",
+			Description: `Analyze a go project and list the fully qualified names of the functions that can be passed to "profile" via --profile-target.`,
+			ArgsUsage:   "path_to_project",
+			Action:      cmd.ListTargets,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "filter, f",
+					Usage: `only list targets containing this string; glob patterns and regular expressions prefixed with "re:" are also supported`,
+				},
+				cli.StringFlag{
+					Name:  "callgraph, cg",
+					Usage: "instead of listing targets, display the callgraph of functions reachable from the targets matching this value",
+				},
+				cli.StringFlag{
+					Name:  "callgraph-algorithm",
+					Value: "rta",
+					Usage: "algorithm for constructing the callgraph; supported options: rta, cha, vta, static",
+				},
+				cli.IntFlag{
+					Name:  "max-depth",
+					Usage: "only include functions up to this many calls away from the target in the callgraph; 0 disables the limit",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Value: &cli.StringSlice{},
+					Usage: `exclude functions matching this FQ name, glob pattern or "re:" prefixed regex and any functions only reachable through them from the callgraph; this option may be specified multiple times`,
+				},
+			},
+		},
 		{
 			Name:        "print",
 			Usage:       "pretty-print profile",
@@ -74,10 +184,14 @@ func main() {
 			ArgsUsage:   "profile",
 			Action:      cmd.PrintProfile,
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "path to a prism config file; defaults to the .prism.yaml file in the current folder if present",
+				},
 				cli.StringFlag{
 					Name:  "display-columns, dc",
 					Value: "total,min,mean,max,invocations",
-					Usage: fmt.Sprintf("columns to include in the output; supported options: %s", cmd.SupportedColumnNames()),
+					Usage: fmt.Sprintf("columns to include in the output; supported options: %s, pNNN and self_pNNN for arbitrary percentiles (e.g. p95, p999)", cmd.SupportedColumnNames()),
 				},
 				cli.StringFlag{
 					Name:  "display-format, df",
@@ -107,10 +221,14 @@ func main() {
 			ArgsUsage:   "profile1 profile2 [...profile_n]",
 			Action:      cmd.DiffProfiles,
 			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "config",
+					Usage: "path to a prism config file; defaults to the .prism.yaml file in the current folder if present",
+				},
 				cli.StringFlag{
 					Name:  "display-columns,dc",
 					Value: "total,min,mean,max,invocations",
-					Usage: fmt.Sprintf("columns to include in the diff output; supported options: %s", cmd.SupportedColumnNames()),
+					Usage: fmt.Sprintf("columns to include in the diff output; supported options: %s, pNNN and self_pNNN for arbitrary percentiles (e.g. p95, p999)", cmd.SupportedColumnNames()),
 				},
 				cli.StringFlag{
 					Name:  "display-unit, du",
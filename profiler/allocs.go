@@ -0,0 +1,53 @@
+package profiler
+
+import (
+	"runtime"
+	"sync"
+)
+
+var (
+	// A buffer for reading the runtime memory statistics and a mutex for
+	// protecting access to it.
+	memStats      runtime.MemStats
+	memStatsMutex sync.Mutex
+)
+
+// allocCounters tracks the cumulative number of bytes and objects allocated
+// on the heap.
+type allocCounters struct {
+	bytes   uint64
+	objects uint64
+}
+
+// Read the process-wide heap allocation counters.
+func readAllocCounters() allocCounters {
+	memStatsMutex.Lock()
+	defer memStatsMutex.Unlock()
+
+	runtime.ReadMemStats(&memStats)
+	return allocCounters{
+		bytes:   memStats.TotalAlloc,
+		objects: memStats.Mallocs,
+	}
+}
+
+// Add the counter values of other to this set of counters.
+func (c allocCounters) add(other allocCounters) allocCounters {
+	return allocCounters{
+		bytes:   c.bytes + other.bytes,
+		objects: c.objects + other.objects,
+	}
+}
+
+// Subtract the counter values of other from this set of counters. Negative
+// differences are clamped to zero.
+func (c allocCounters) sub(other allocCounters) allocCounters {
+	var diff allocCounters
+	if c.bytes > other.bytes {
+		diff.bytes = c.bytes - other.bytes
+	}
+	if c.objects > other.objects {
+		diff.objects = c.objects - other.objects
+	}
+	return diff
+}
@@ -0,0 +1,263 @@
+package profiler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	numCalibrationCalls   = 10000000
+	numCalibrationBatches = 50
+
+	// The name of the calibration cache file inside the user cache folder.
+	calibrationCacheFile = "prism/calibration.json"
+)
+
+// The supported estimators for calibrating the profiler overheads.
+const (
+	MeanEstimator   = "mean"
+	MedianEstimator = "median"
+)
+
+// Calibration contains the estimated overheads for the operations performed
+// by the profiler hooks. These overheads are subtracted from the times
+// captured by the profiler.
+type Calibration struct {
+	// The estimator used for calculating the overheads.
+	Estimator string `json:"estimator"`
+
+	// The mean time spent in function guard code (stack setup, pushing/popping
+	// registers e.t.c).
+	FnCall time.Duration `json:"fn_call"`
+
+	// The mean time spent invoking a deferred function.
+	DeferredFn time.Duration `json:"deferred_fn"`
+
+	// The mean time spent invoking time.Now() and time.Since().
+	TimeNow   time.Duration `json:"time_now"`
+	TimeSince time.Duration `json:"time_since"`
+}
+
+var (
+	// Function call invokation overhead; calculated by calibrate() and applied by Init()
+	timeNowOverhead, timeSinceOverhead, deferredFnOverhead, fnCallOverhead time.Duration
+
+	// The calibration that is currently applied.
+	activeCalibration *Calibration
+)
+
+// Load the profiler overheads for the estimator selected by opts from the
+// calibration cache or calibrate them if the cache contains no entry for the
+// current runtime and hardware. Calibration only runs once per process for
+// each estimator.
+//
+// Any errors accessing the cache are ignored; the worst case is that the
+// overheads are calibrated again the next time the profiler is initialized.
+func initCalibration(opts options) {
+	estimator := MeanEstimator
+	if opts.medianCalibration {
+		estimator = MedianEstimator
+	}
+
+	if activeCalibration != nil && activeCalibration.Estimator == estimator {
+		return
+	}
+
+	cachePath := calibrationCachePath(opts)
+	cacheKey := calibrationCacheKey(estimator)
+	if cachePath == "" || cacheKey == "" {
+		applyCalibration(calibrate(estimator))
+		return
+	}
+
+	cache := readCalibrationCache(cachePath)
+	calibration, found := cache[cacheKey]
+	if !found {
+		calibration = calibrate(estimator)
+		cache[cacheKey] = calibration
+		writeCalibrationCache(cachePath, cache)
+	}
+	applyCalibration(calibration)
+}
+
+// Set the overheads used by the profiler hooks.
+func applyCalibration(calibration Calibration) {
+	fnCallOverhead = calibration.FnCall
+	deferredFnOverhead = calibration.DeferredFn
+	timeNowOverhead = calibration.TimeNow
+	timeSinceOverhead = calibration.TimeSince
+	activeCalibration = &calibration
+}
+
+// calibrate attempts to estimate the overhead for invoking time.Now(), time.Since(),
+// as well as the time spent in function guard code (stack setup, pushing/popping
+// registers, dealing with deferred calls e.t.c).
+//
+// Runtime overhead is generally in the nanosecond range but we need to
+// properly account for it when calculating the total time spent inside a
+// profiled function as it tends to skew our timing calculations when the profiled
+// function is invoked a large number of times.
+//
+// To calculate an estimate, we time N executions of each function and then
+// either calculate the mean execution time or, if the median estimator is
+// selected, split the executions into batches and pick the median of the
+// mean execution time of each batch.
+func calibrate(estimator string) Calibration {
+	estimate := meanOverhead
+	if estimator == MedianEstimator {
+		estimate = medianOverhead
+	}
+
+	// Benchmark function call time. We use a switch statement to ensure that
+	// the compiler will not inline this function (see https://github.com/golang/go/issues/12312)
+	fnCallBench := func(i int) {
+		switch i {
+		}
+	}
+	fnCall := estimate(func(n int) {
+		for i := 0; i < n; i++ {
+			fnCallBench(i)
+		}
+	})
+
+	// Benchmark deferred function call time
+	deferBench := func(i int) {
+		defer func() { fnCallBench(i) }()
+	}
+	deferredFn := estimate(func(n int) {
+		for i := 0; i < n; i++ {
+			deferBench(i)
+		}
+	})
+
+	// Benchmark time.Now()
+	timeNow := estimate(func(n int) {
+		for i := 0; i < n; i++ {
+			time.Now()
+		}
+	})
+
+	// Benchmark time.Since()
+	timeSince := estimate(func(n int) {
+		tick := time.Now()
+		for i := 0; i < n; i++ {
+			time.Since(tick)
+		}
+	})
+
+	return Calibration{
+		Estimator:  estimator,
+		FnCall:     fnCall,
+		DeferredFn: deferredFn,
+		TimeNow:    fnCall + timeNow,
+		TimeSince:  fnCall + timeSince,
+	}
+}
+
+// Run bench for numCalibrationCalls iterations and return the mean time per iteration.
+func meanOverhead(bench func(n int)) time.Duration {
+	tick := time.Now()
+	bench(numCalibrationCalls)
+	return time.Since(tick) / time.Duration(numCalibrationCalls)
+}
+
+// Run bench for numCalibrationCalls iterations split into numCalibrationBatches
+// batches and return the median of the mean time per iteration of each batch.
+func medianOverhead(bench func(n int)) time.Duration {
+	batchSize := numCalibrationCalls / numCalibrationBatches
+	batchTimes := make([]time.Duration, numCalibrationBatches)
+	for index := range batchTimes {
+		tick := time.Now()
+		bench(batchSize)
+		batchTimes[index] = time.Since(tick)
+	}
+	sort.Slice(batchTimes, func(i, j int) bool { return batchTimes[i] < batchTimes[j] })
+
+	median := batchTimes[len(batchTimes)/2]
+	if len(batchTimes)%2 == 0 {
+		median = (batchTimes[len(batchTimes)/2-1] + median) / 2
+	}
+	return median / time.Duration(batchSize)
+}
+
+// Get the path to the calibration cache file or an empty string if caching
+// is disabled.
+func calibrationCachePath(opts options) string {
+	if opts.noCalibrationCache {
+		return ""
+	}
+	if opts.calibrationCache != "" {
+		return opts.calibrationCache
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, calibrationCacheFile)
+}
+
+// Get the key for the calibration cache entry that matches the current go
+// runtime, platform and CPU model. As calibration results cannot be shared
+// across different CPUs, an empty key is returned if the CPU model cannot be
+// detected.
+func calibrationCacheKey(estimator string) string {
+	model := cpuModel()
+	if model == "" {
+		return ""
+	}
+
+	return strings.Join([]string{runtime.Version(), runtime.GOOS + "/" + runtime.GOARCH, model, estimator}, "; ")
+}
+
+// Read the calibration cache entries from path. An empty set of entries is
+// returned if the cache file does not exist or cannot be parsed.
+func readCalibrationCache(path string) map[string]Calibration {
+	cache := make(map[string]Calibration)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+
+	if err = json.Unmarshal(data, &cache); err != nil || cache == nil {
+		return make(map[string]Calibration)
+	}
+	return cache
+}
+
+// Write the calibration cache entries to path. The entries are written to a
+// temp file which is then renamed so that processes reading the cache while
+// it is being written never observe a partially written file.
+func writeCalibrationCache(path string, cache map[string]Calibration) {
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
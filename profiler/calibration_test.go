@@ -0,0 +1,83 @@
+package profiler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCalibrationCache(t *testing.T) {
+	cacheKey := calibrationCacheKey(MeanEstimator)
+	if cacheKey == "" {
+		t.Skip("CPU model detection is not supported on this platform")
+	}
+
+	tmpDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Reset the calibration so it gets reloaded by Init
+	defer func() { activeCalibration = nil }()
+	activeCalibration = nil
+
+	// Seed the cache with an entry for the mean estimator
+	cachePath := filepath.Join(tmpDir, "calibration.json")
+	expCalibration := Calibration{
+		Estimator:  MeanEstimator,
+		FnCall:     1,
+		DeferredFn: 2,
+		TimeNow:    3,
+		TimeSince:  4,
+	}
+	data, err := json.Marshal(map[string]Calibration{cacheKey: expCalibration})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cachePath, data, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", CalibrationCache(cachePath))
+
+	BeginProfile("func1")
+	EndProfile()
+	Shutdown()
+
+	if fnCallOverhead != 1 || deferredFnOverhead != 2 || timeNowOverhead != 3 || timeSinceOverhead != 4 {
+		t.Fatalf("expected profiler to apply the cached calibration; got fn call %d, deferred fn %d, time.Now %d, time.Since %d", fnCallOverhead, deferredFnOverhead, timeNowOverhead, timeSinceOverhead)
+	}
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+	if calibration := sink.buffer[0].Calibration; calibration == nil || *calibration != expCalibration {
+		t.Fatalf("expected profile calibration to be %+v; got %+v", expCalibration, calibration)
+	}
+
+	// Switching to the median estimator should trigger a calibration
+	// and append a new entry to the cache
+	Init(newDiscardSink(), "profiler-test", CalibrationCache(cachePath), MedianCalibration())
+	Shutdown()
+
+	if activeCalibration.Estimator != MedianEstimator {
+		t.Fatalf("expected active calibration estimator to be %q; got %q", MedianEstimator, activeCalibration.Estimator)
+	}
+
+	cache := readCalibrationCache(cachePath)
+	expEntries = 2
+	if len(cache) != expEntries {
+		t.Fatalf("expected calibration cache to contain %d entries; got %d", expEntries, len(cache))
+	}
+	if calibration := cache[calibrationCacheKey(MedianEstimator)]; calibration != *activeCalibration {
+		t.Fatalf("expected cached median calibration to be %+v; got %+v", *activeCalibration, calibration)
+	}
+	if calibration := cache[cacheKey]; calibration != expCalibration {
+		t.Fatalf("expected cached mean calibration to be %+v; got %+v", expCalibration, calibration)
+	}
+}
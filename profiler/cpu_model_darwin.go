@@ -0,0 +1,13 @@
+package profiler
+
+import "syscall"
+
+// cpuModel returns the CPU brand string reported by sysctl or an empty
+// string if the model cannot be detected.
+func cpuModel() string {
+	model, err := syscall.Sysctl("machdep.cpu.brand_string")
+	if err != nil {
+		return ""
+	}
+	return model
+}
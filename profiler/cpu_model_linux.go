@@ -0,0 +1,43 @@
+package profiler
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// cpuModel returns the CPU model as reported by /proc/cpuinfo or an empty
+// string if the model cannot be detected.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	// Only examine the entry for the first processor
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" && len(fields) != 0 {
+			break
+		}
+
+		tokens := strings.SplitN(line, ":", 2)
+		if len(tokens) == 2 {
+			fields[strings.TrimSpace(tokens[0])] = strings.TrimSpace(tokens[1])
+		}
+	}
+
+	// x86 CPUs report a model name while arm CPUs report the implementer
+	// and part number.
+	if model := fields["model name"]; model != "" {
+		return model
+	}
+	if fields["CPU implementer"] != "" && fields["CPU part"] != "" {
+		return "implementer " + fields["CPU implementer"] + " part " + fields["CPU part"]
+	}
+
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package profiler
+
+// cpuModel returns an empty string as CPU model detection is not supported
+// on this platform.
+func cpuModel() string {
+	return ""
+}
@@ -0,0 +1,23 @@
+package profiler
+
+import (
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The clock ID for querying the CPU time consumed by the calling thread (see
+// clock_gettime(2)).
+const clockThreadCPUTimeID = 3
+
+// threadCPUTime returns the CPU time consumed by the OS thread that runs the
+// calling goroutine. As clock_gettime never blocks, it is invoked via
+// RawSyscall to avoid notifying the scheduler.
+func threadCPUTime() time.Duration {
+	var ts syscall.Timespec
+	_, _, errno := syscall.RawSyscall(syscall.SYS_CLOCK_GETTIME, clockThreadCPUTimeID, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package profiler
+
+import "time"
+
+// threadCPUTime returns 0 as per-thread CPU time tracking is not supported on
+// this platform.
+func threadCPUTime() time.Duration {
+	return 0
+}
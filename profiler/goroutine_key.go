@@ -0,0 +1,17 @@
+//go:build amd64 || arm64
+// +build amd64 arm64
+
+package profiler
+
+// Get the address of the runtime structure for the calling goroutine. This
+// function is implemented in assembly.
+func getg() uintptr
+
+// goroutineKey returns a value identifying the calling goroutine. On this
+// architecture, the key is the address of the runtime structure for the
+// goroutine which is much cheaper to obtain than the goroutine ID. As the go
+// runtime may reuse the structures of exited goroutines, the key is only
+// unique among running goroutines.
+func goroutineKey() uintptr {
+	return getg()
+}
@@ -0,0 +1,10 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package profiler
+
+// goroutineKey returns a value identifying the calling goroutine. On this
+// architecture, the key is the goroutine ID.
+func goroutineKey() uintptr {
+	return uintptr(threadID())
+}
@@ -0,0 +1,209 @@
+package profiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// The number of bits used for indexing the linear sub-buckets within each
+// power-of-two range of values. Using 7 bits bounds the relative error of
+// the values reported by the histogram to 1/128 (< 0.8%).
+const histogramSubBucketBits = 7
+
+const histogramSubBucketCount = 1 << histogramSubBucketBits
+
+// Histogram is a compact log-linear histogram of durations. Durations are
+// mapped to buckets whose width grows with the magnitude of the recorded
+// values so that percentiles can be estimated with a bounded relative error
+// regardless of the value range. Histograms can be merged without any loss
+// of precision which allows percentiles to be calculated across multiple
+// profiles.
+type Histogram struct {
+	// The number of recorded values.
+	Count uint64 `json:"count"`
+
+	// The exact min and max recorded values.
+	Min time.Duration `json:"min"`
+	Max time.Duration `json:"max"`
+
+	// The non-empty buckets sorted by index.
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+// HistogramBucket stores the number of recorded values that were mapped to a
+// particular histogram bucket. Buckets are serialized as compact
+// [index, count] JSON arrays.
+type HistogramBucket struct {
+	Index int
+	Count uint64
+}
+
+// MarshalJSON implements json.Marshaler for histogram buckets.
+func (b HistogramBucket) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%d,%d]", b.Index, b.Count)), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for histogram buckets.
+func (b *HistogramBucket) UnmarshalJSON(data []byte) error {
+	var pair [2]uint64
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if pair[0] > math.MaxInt32 {
+		return fmt.Errorf("invalid histogram bucket index %d", pair[0])
+	}
+
+	b.Index = int(pair[0])
+	b.Count = pair[1]
+	return nil
+}
+
+// NewHistogram creates a new empty histogram.
+func NewHistogram() *Histogram {
+	return &Histogram{
+		Buckets: make([]HistogramBucket, 0),
+	}
+}
+
+// Record adds a duration to the histogram. Negative durations are recorded
+// as zero.
+func (h *Histogram) Record(value time.Duration) {
+	h.RecordN(value, 1)
+}
+
+// RecordN adds count instances of a duration to the histogram.
+func (h *Histogram) RecordN(value time.Duration, count uint64) {
+	if count == 0 {
+		return
+	}
+	if value < 0 {
+		value = 0
+	}
+
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if h.Count == 0 || value > h.Max {
+		h.Max = value
+	}
+	h.Count += count
+
+	index := histogramBucketIndex(value)
+	pos := sort.Search(len(h.Buckets), func(i int) bool { return h.Buckets[i].Index >= index })
+	if pos < len(h.Buckets) && h.Buckets[pos].Index == index {
+		h.Buckets[pos].Count += count
+		return
+	}
+
+	h.Buckets = append(h.Buckets, HistogramBucket{})
+	copy(h.Buckets[pos+1:], h.Buckets[pos:])
+	h.Buckets[pos] = HistogramBucket{Index: index, Count: count}
+}
+
+// Merge adds the values recorded by other to the histogram.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.Count == 0 {
+		return
+	}
+
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if h.Count == 0 || other.Max > h.Max {
+		h.Max = other.Max
+	}
+	h.Count += other.Count
+
+	// Both bucket lists are sorted so we can merge them in a single pass
+	merged := make([]HistogramBucket, 0, len(h.Buckets)+len(other.Buckets))
+	i, j := 0, 0
+	for i < len(h.Buckets) && j < len(other.Buckets) {
+		switch {
+		case h.Buckets[i].Index < other.Buckets[j].Index:
+			merged = append(merged, h.Buckets[i])
+			i++
+		case h.Buckets[i].Index > other.Buckets[j].Index:
+			merged = append(merged, other.Buckets[j])
+			j++
+		default:
+			merged = append(merged, HistogramBucket{
+				Index: h.Buckets[i].Index,
+				Count: h.Buckets[i].Count + other.Buckets[j].Count,
+			})
+			i++
+			j++
+		}
+	}
+	merged = append(merged, h.Buckets[i:]...)
+	merged = append(merged, other.Buckets[j:]...)
+	h.Buckets = merged
+}
+
+// Quantile estimates the duration below which the q fraction of the recorded
+// values fall (e.g. q = 0.99 for the 99th percentile). The estimate is
+// clamped to the recorded min and max values. Quantile returns 0 for an
+// empty histogram.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+
+	// Use the same nearest-rank definition as the percentiles calculated
+	// from the raw values
+	rank := uint64(math.Ceil(q * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	} else if rank > h.Count {
+		rank = h.Count
+	}
+
+	var seen uint64
+	for _, bucket := range h.Buckets {
+		seen += bucket.Count
+		if seen < rank {
+			continue
+		}
+
+		value := histogramBucketMidpoint(bucket.Index)
+		if value < h.Min {
+			value = h.Min
+		} else if value > h.Max {
+			value = h.Max
+		}
+		return value
+	}
+
+	return h.Max
+}
+
+// Map a duration to the index of the bucket that contains it. Values smaller
+// than histogramSubBucketCount are mapped to a dedicated bucket; larger values
+// are mapped to one of the histogramSubBucketCount equally sized buckets
+// spanning the power-of-two range that contains them.
+func histogramBucketIndex(value time.Duration) int {
+	v := uint64(value)
+	if v < histogramSubBucketCount {
+		return int(v)
+	}
+
+	shift := uint(bits.Len64(v)) - histogramSubBucketBits - 1
+	mantissa := v >> shift
+	return int(shift+1)*histogramSubBucketCount + int(mantissa-histogramSubBucketCount)
+}
+
+// Get the midpoint of the range of values covered by a bucket.
+func histogramBucketMidpoint(index int) time.Duration {
+	if index < histogramSubBucketCount {
+		return time.Duration(index)
+	}
+
+	shift := uint(index/histogramSubBucketCount - 1)
+	mantissa := uint64(histogramSubBucketCount + index%histogramSubBucketCount)
+	lower := mantissa << shift
+	width := uint64(1) << shift
+	return time.Duration(lower + (width-1)/2)
+}
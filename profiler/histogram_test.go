@@ -0,0 +1,142 @@
+package profiler
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	specs := []struct {
+		Q   float64
+		Exp time.Duration
+	}{
+		{0, 1 * time.Microsecond},
+		{0.5, 500 * time.Microsecond},
+		{0.9, 900 * time.Microsecond},
+		{0.99, 990 * time.Microsecond},
+		{0.999, 999 * time.Microsecond},
+		{1, 1000 * time.Microsecond},
+	}
+
+	for specIndex, spec := range specs {
+		got := h.Quantile(spec.Q)
+		if relErr := float64(got-spec.Exp) / float64(spec.Exp); relErr < -0.01 || relErr > 0.01 {
+			t.Errorf("[spec %d] expected quantile %v to be within 1%% of %v; got %v", specIndex, spec.Q, spec.Exp, got)
+		}
+	}
+
+	if h.Count != 1000 {
+		t.Errorf("expected histogram count to be 1000; got %d", h.Count)
+	}
+	if h.Min != time.Microsecond || h.Max != time.Millisecond {
+		t.Errorf("expected histogram min/max to be %v/%v; got %v/%v", time.Microsecond, time.Millisecond, h.Min, h.Max)
+	}
+}
+
+func TestHistogramSmallValues(t *testing.T) {
+	h := NewHistogram()
+	for i := 0; i < histogramSubBucketCount; i++ {
+		h.Record(time.Duration(i))
+	}
+
+	// Values smaller than the sub-bucket count are tracked exactly
+	for i := 0; i < histogramSubBucketCount; i++ {
+		q := float64(i+1) / float64(histogramSubBucketCount)
+		if got := h.Quantile(q); got != time.Duration(i) {
+			t.Errorf("expected quantile %v to be %d; got %d", q, i, got)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	merged := NewHistogram()
+	expected := NewHistogram()
+
+	for part := 0; part < 4; part++ {
+		h := NewHistogram()
+		for i := 0; i < 250; i++ {
+			value := time.Duration(part*250+i) * time.Millisecond
+			h.Record(value)
+			expected.Record(value)
+		}
+		merged.Merge(h)
+	}
+	merged.Merge(nil)
+	merged.Merge(NewHistogram())
+
+	if merged.Count != expected.Count || merged.Min != expected.Min || merged.Max != expected.Max {
+		t.Fatalf("expected merged count/min/max to be %d/%v/%v; got %d/%v/%v", expected.Count, expected.Min, expected.Max, merged.Count, merged.Min, merged.Max)
+	}
+	if len(merged.Buckets) != len(expected.Buckets) {
+		t.Fatalf("expected merged histogram to have %d buckets; got %d", len(expected.Buckets), len(merged.Buckets))
+	}
+	for index, bucket := range expected.Buckets {
+		if merged.Buckets[index] != bucket {
+			t.Errorf("expected merged bucket %d to be %+v; got %+v", index, bucket, merged.Buckets[index])
+		}
+	}
+}
+
+func TestHistogramRecordN(t *testing.T) {
+	h := NewHistogram()
+	h.RecordN(10*time.Millisecond, 99)
+	h.RecordN(time.Second, 1)
+	h.RecordN(time.Hour, 0)
+	h.Record(-time.Second)
+
+	if h.Count != 101 {
+		t.Errorf("expected histogram count to be 101; got %d", h.Count)
+	}
+	if h.Min != 0 || h.Max != time.Second {
+		t.Errorf("expected histogram min/max to be 0/%v; got %v/%v", time.Second, h.Min, h.Max)
+	}
+	if got := h.Quantile(0.99); got < 9900*time.Microsecond || got > 10100*time.Microsecond {
+		t.Errorf("expected p99 to be ~10ms; got %v", got)
+	}
+	if got := h.Quantile(1); got != time.Second {
+		t.Errorf("expected p100 to be %v; got %v", time.Second, got)
+	}
+
+	var empty *Histogram
+	if got := empty.Quantile(0.5); got != 0 {
+		t.Errorf("expected quantile of nil histogram to be 0; got %v", got)
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	h := NewHistogram()
+	h.Record(5)
+	h.RecordN(time.Millisecond, 3)
+
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expJSON := `{"count":4,"min":5,"max":1000000,"buckets":[[5,1],[1780,3]]}`
+	if string(data) != expJSON {
+		t.Fatalf("expected histogram JSON to be %s; got %s", expJSON, data)
+	}
+
+	var decoded *Histogram
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, h) {
+		t.Fatalf("expected decoded histogram to be %+v; got %+v", h, decoded)
+	}
+
+	if err = json.Unmarshal([]byte(`{"buckets":[[1]]}`), &decoded); err != nil {
+		t.Fatalf("unexpected error decoding short bucket: %v", err)
+	}
+	if err = json.Unmarshal([]byte(`{"buckets":[["x",1]]}`), &decoded); err == nil {
+		t.Fatal("expected an error decoding a malformed bucket")
+	}
+}
@@ -0,0 +1,132 @@
+package profiler
+
+// Option configures the behavior of the profiler. Options are passed to Init.
+type Option func(*options)
+
+// options contains the settings that can be configured via Option values.
+type options struct {
+	// Track the heap allocations performed by each profiled call of 1 in
+	// every trackAllocsEvery profiles of each profile target.
+	trackAllocs      bool
+	trackAllocsEvery int
+
+	// Track the CPU time consumed by each profiled call.
+	trackCPUTime bool
+
+	// Sampling settings for profile targets.
+	sampleEvery          int
+	maxProfilesPerSecond int
+	maxProfiles          int
+
+	// Calibration settings. An empty calibrationCache value selects the
+	// default cache location.
+	noCalibrationCache bool
+	calibrationCache   string
+	medianCalibration  bool
+}
+
+// TrackAllocs enables the tracking of the number of bytes and objects
+// allocated by each profiled call.
+//
+// The go runtime does not maintain per-goroutine allocation counters so the
+// profiler hooks sample the process-wide counters via runtime.ReadMemStats.
+// As a result:
+//   - allocations performed by other goroutines while a profiled call is
+//     running are attributed to that call. The reported values are only
+//     accurate if the profiled code runs on a single goroutine.
+//   - runtime.ReadMemStats briefly stops the world each time it is invoked.
+//     While the time spent by the profiler hooks is excluded from the reported
+//     times, tracking allocations significantly slows down the profiled code
+//     and any goroutines running concurrently to it. The times reported by
+//     profiles that track allocations are therefore unreliable; use
+//     TrackAllocsEvery to limit the number of affected profiles.
+func TrackAllocs() Option {
+	return func(opts *options) {
+		opts.trackAllocs = true
+	}
+}
+
+// TrackAllocsEvery enables the tracking of allocations like TrackAllocs but
+// only for 1 in every n profiles of each profile target. The remaining
+// profiles do not invoke runtime.ReadMemStats and report no allocations.
+func TrackAllocsEvery(n int) Option {
+	return func(opts *options) {
+		opts.trackAllocs = true
+		opts.trackAllocsEvery = n
+	}
+}
+
+// TrackCPUTime enables the tracking of the CPU time consumed by each profiled
+// call in addition to its wall-clock time. This option is only supported on
+// linux; it is ignored on other platforms.
+//
+// The CPU time is measured using the per-thread CPU clock of the OS thread
+// running the profiled goroutine. As goroutines may migrate between OS threads,
+// the profiler locks each profiled goroutine to its current OS thread (see
+// runtime.LockOSThread) while its profile is active. As a result:
+//   - each time a profiled goroutine blocks (e.g. on I/O, a channel or a
+//     mutex), the go runtime needs to park its OS thread and wake it up again
+//     once the goroutine can resume. This makes blocking operations in the
+//     profiled code noticeably slower.
+//   - the runtime spawns additional OS threads for running other goroutines
+//     while the profiled goroutines are blocked.
+//   - the CPU time consumed by the runtime on behalf of the goroutine (e.g.
+//     GC assists) is attributed to the profiled call.
+func TrackCPUTime() Option {
+	return func(opts *options) {
+		opts.trackCPUTime = true
+	}
+}
+
+// SampleEvery configures the profiler to only profile 1 in every n
+// invocations of each profile target. Invocations that are not sampled are
+// not profiled and add minimal overhead.
+func SampleEvery(n int) Option {
+	return func(opts *options) {
+		opts.sampleEvery = n
+	}
+}
+
+// MaxProfilesPerSecond limits the number of profiles captured for each
+// profile target to n profiles per second.
+func MaxProfilesPerSecond(n int) Option {
+	return func(opts *options) {
+		opts.maxProfilesPerSecond = n
+	}
+}
+
+// MaxProfiles limits the total number of profiles captured for each profile
+// target to n profiles. Once the limit is reached, any further invocations
+// of the target are not profiled.
+func MaxProfiles(n int) Option {
+	return func(opts *options) {
+		opts.maxProfiles = n
+	}
+}
+
+// CalibrationCache overrides the location of the file used for caching the
+// calibrated profiler overheads. By default, the profiler caches the
+// calibration results in the user cache folder (see os.UserCacheDir).
+func CalibrationCache(path string) Option {
+	return func(opts *options) {
+		opts.calibrationCache = path
+	}
+}
+
+// NoCalibrationCache disables the calibration cache. The profiler overheads
+// are calibrated each time the profiler is initialized.
+func NoCalibrationCache() Option {
+	return func(opts *options) {
+		opts.noCalibrationCache = true
+	}
+}
+
+// MedianCalibration estimates the profiler overheads using the median of a
+// set of calibration batches instead of the mean of a single calibration run.
+// The median estimate is less susceptible to outliers such as GC pauses or
+// goroutine preemption.
+func MedianCalibration() Option {
+	return func(opts *options) {
+		opts.medianCalibration = true
+	}
+}
@@ -9,11 +9,21 @@ import (
 
 // Profile wraps the processed metrics for a particular execution of a prism-hooked target.
 type Profile struct {
+	// ID uniquely identifies the profile among the profiles captured by
+	// the profiled process.
 	ID        uint64    `json:"-"`
 	CreatedAt time.Time `json:"-"`
 
 	Label  string       `json:"label"`
 	Target *CallMetrics `json:"target"`
+
+	// The profiler overhead estimates that were used for adjusting the
+	// captured times.
+	Calibration *Calibration `json:"calibration,omitempty"`
+
+	// Set if the allocations of the profiled calls were tracked (see
+	// TrackAllocs and TrackAllocsEvery).
+	AllocsTracked bool `json:"allocs_tracked,omitempty"`
 }
 
 type metricsList []*CallMetrics
@@ -33,6 +43,7 @@ func (p metricsList) aggregate() *CallMetrics {
 		MinTime:     time.Duration(math.MaxInt64),
 		MaxTime:     time.Duration(math.MinInt64),
 		Invocations: len(p),
+		Async:       p[0].Async,
 	}
 
 	// Pre-sort metrics so we can calculate the percentiles and the median
@@ -53,13 +64,82 @@ func (p metricsList) aggregate() *CallMetrics {
 		cm.P90Time = p[p90].TotalTime
 		cm.P99Time = p[p99].TotalTime
 
+		cm.TimeHistogram = NewHistogram()
 		for _, metric := range p {
+			cm.TimeHistogram.Record(metric.TotalTime)
 			cm.TotalTime += metric.TotalTime
+			cm.WaitTime += metric.WaitTime
+			cm.Unfinished += metric.Unfinished
+			cm.Panics += metric.Panics
+			cm.Errors += metric.Errors
+			cm.CPUTime += metric.CPUTime
+			cm.OffCPUTime += metric.OffCPUTime
+		}
+
+		// Calc the same set of values for the self time. As the self
+		// time ordering does not necessarily match the total time ordering
+		// we need to sort the self times separately
+		selfTimes := make([]time.Duration, len(p))
+		for index, metric := range p {
+			selfTimes[index] = metric.SelfTime
+			cm.SelfTime += metric.SelfTime
+		}
+		sort.Slice(selfTimes, func(i, j int) bool { return selfTimes[i] < selfTimes[j] })
+
+		cm.SelfTimeHistogram = NewHistogram()
+		for _, selfTime := range selfTimes {
+			cm.SelfTimeHistogram.Record(selfTime)
+		}
+
+		cm.MinSelfTime = selfTimes[0]
+		cm.MaxSelfTime = selfTimes[len(selfTimes)-1]
+		cm.P50SelfTime = selfTimes[p50]
+		cm.P75SelfTime = selfTimes[p75]
+		cm.P90SelfTime = selfTimes[p90]
+		cm.P99SelfTime = selfTimes[p99]
+		cm.MeanSelfTime = cm.SelfTime / time.Duration(cm.Invocations)
+		if cm.Invocations%2 == 0 {
+			cm.MedianSelfTime = (selfTimes[cm.Invocations/2-1] + selfTimes[cm.Invocations/2]) / 2
+		} else {
+			cm.MedianSelfTime = selfTimes[cm.Invocations/2]
 		}
+
+		// Calc allocation stats
+		allocBytes := make([]uint64, len(p))
+		allocObjects := make([]uint64, len(p))
+		for index, metric := range p {
+			allocBytes[index] = metric.AllocBytes
+			allocObjects[index] = metric.AllocObjects
+		}
+
+		bytesStats := summarizeAllocs(allocBytes)
+		cm.AllocBytes = bytesStats.total
+		cm.MinAllocBytes = bytesStats.min
+		cm.MaxAllocBytes = bytesStats.max
+		cm.MeanAllocBytes = bytesStats.mean
+		cm.MedianAllocBytes = bytesStats.median
+		cm.P50AllocBytes = bytesStats.p50
+		cm.P75AllocBytes = bytesStats.p75
+		cm.P90AllocBytes = bytesStats.p90
+		cm.P99AllocBytes = bytesStats.p99
+
+		objectStats := summarizeAllocs(allocObjects)
+		cm.AllocObjects = objectStats.total
+		cm.MinAllocObjects = objectStats.min
+		cm.MaxAllocObjects = objectStats.max
+		cm.MeanAllocObjects = objectStats.mean
+		cm.MedianAllocObjects = objectStats.median
+		cm.P50AllocObjects = objectStats.p50
+		cm.P75AllocObjects = objectStats.p75
+		cm.P90AllocObjects = objectStats.p90
+		cm.P99AllocObjects = objectStats.p99
 	}
 
-	// Calc mean
+	// Calc means and error rate
 	cm.MeanTime = cm.TotalTime / time.Duration(cm.Invocations)
+	cm.MeanCPUTime = cm.CPUTime / time.Duration(cm.Invocations)
+	cm.MeanOffCPUTime = cm.OffCPUTime / time.Duration(cm.Invocations)
+	cm.ErrorRate = float64(cm.Errors) / float64(cm.Invocations)
 
 	// Calc stddev = Sqrt( 1 / N * Sum_i( (total_i - mean)^2 ) )
 	for _, metric := range p {
@@ -77,6 +157,41 @@ func (p metricsList) aggregate() *CallMetrics {
 	return cm
 }
 
+// allocStats summarizes the allocation counter values for a group of calls.
+type allocStats struct {
+	total, min, max, mean, median uint64
+	p50, p75, p90, p99            uint64
+}
+
+// Calculate the aggregate statistics for a non-empty list of allocation
+// counter values. The list is sorted in place.
+func summarizeAllocs(values []uint64) allocStats {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	countF := float64(len(values))
+	stats := allocStats{
+		min: values[0],
+		max: values[len(values)-1],
+		p50: values[int(math.Ceil(countF*.5))-1],
+		p75: values[int(math.Ceil(countF*.75))-1],
+		p90: values[int(math.Ceil(countF*.90))-1],
+		p99: values[int(math.Ceil(countF*.99))-1],
+	}
+
+	for _, value := range values {
+		stats.total += value
+	}
+	stats.mean = stats.total / uint64(len(values))
+
+	if len(values)%2 == 0 {
+		stats.median = (values[len(values)/2-1] + values[len(values)/2]) / 2
+	} else {
+		stats.median = values[len(values)/2]
+	}
+
+	return stats
+}
+
 // CallMetrics encapsulates all collected metrics about a function call that is
 // reachable by a profile target.
 type CallMetrics struct {
@@ -102,9 +217,98 @@ type CallMetrics struct {
 	// Std of time valus.
 	StdDev float64 `json:"std_dev"`
 
+	// Total time spent in this call excluding the time spent in nested
+	// calls (self time).
+	SelfTime time.Duration `json:"self_time"`
+
+	// Min and max self time.
+	MinSelfTime time.Duration `json:"min_self_time"`
+	MaxSelfTime time.Duration `json:"max_self_time"`
+
+	// Mean and median self time.
+	MeanSelfTime   time.Duration `json:"mean_self_time"`
+	MedianSelfTime time.Duration `json:"median_self_time"`
+
+	// Self time percentiles.
+	P50SelfTime time.Duration `json:"p50_self_time"`
+	P75SelfTime time.Duration `json:"p75_self_time"`
+	P90SelfTime time.Duration `json:"p90_self_time"`
+	P99SelfTime time.Duration `json:"p99_self_time"`
+
+	// Histograms of the total and self time of each invocation. They allow
+	// the metrics of multiple profiles to be merged (see the aggregating
+	// sink) and arbitrary percentiles to be estimated. Profiles captured by
+	// older prism versions do not include histograms.
+	TimeHistogram     *Histogram `json:"time_histogram,omitempty"`
+	SelfTimeHistogram *Histogram `json:"self_time_histogram,omitempty"`
+
 	// The number of times a scope was entered by the same parent function call.
 	Invocations int `json:"invocations"`
 
+	// Set if this entry tracks the work performed by goroutines spawned
+	// from the parent function call. For async entries, the time values
+	// refer to the wall time of the spawned goroutines.
+	Async bool `json:"async,omitempty"`
+
+	// The total time that the parent function call was still running while
+	// waiting for the spawned goroutines to complete. Only populated for
+	// async entries.
+	WaitTime time.Duration `json:"wait_time,omitempty"`
+
+	// The number of async invocations whose goroutines were still running
+	// when the profile target returned. The time values of unfinished
+	// invocations only cover the time until the profile target returned
+	// and any calls made by their goroutines are not included.
+	Unfinished int `json:"unfinished,omitempty"`
+
+	// The number of invocations that were exited due to a panic.
+	Panics int `json:"panics,omitempty"`
+
+	// The number of invocations that returned a non-nil error and the
+	// ratio of such invocations to the total number of invocations. Only
+	// tracked for functions whose last result is an error.
+	Errors    int     `json:"errors,omitempty"`
+	ErrorRate float64 `json:"error_rate,omitempty"`
+
+	// Total number of bytes and objects allocated on the heap by this call.
+	// The allocation metrics are only populated if the profiler was
+	// initialized with the TrackAllocs or TrackAllocsEvery options.
+	AllocBytes   uint64 `json:"alloc_bytes,omitempty"`
+	AllocObjects uint64 `json:"alloc_objects,omitempty"`
+
+	// Min and max allocations.
+	MinAllocBytes   uint64 `json:"min_alloc_bytes,omitempty"`
+	MaxAllocBytes   uint64 `json:"max_alloc_bytes,omitempty"`
+	MinAllocObjects uint64 `json:"min_alloc_objects,omitempty"`
+	MaxAllocObjects uint64 `json:"max_alloc_objects,omitempty"`
+
+	// Mean and median allocations.
+	MeanAllocBytes     uint64 `json:"mean_alloc_bytes,omitempty"`
+	MedianAllocBytes   uint64 `json:"median_alloc_bytes,omitempty"`
+	MeanAllocObjects   uint64 `json:"mean_alloc_objects,omitempty"`
+	MedianAllocObjects uint64 `json:"median_alloc_objects,omitempty"`
+
+	// Allocation percentiles.
+	P50AllocBytes   uint64 `json:"p50_alloc_bytes,omitempty"`
+	P75AllocBytes   uint64 `json:"p75_alloc_bytes,omitempty"`
+	P90AllocBytes   uint64 `json:"p90_alloc_bytes,omitempty"`
+	P99AllocBytes   uint64 `json:"p99_alloc_bytes,omitempty"`
+	P50AllocObjects uint64 `json:"p50_alloc_objects,omitempty"`
+	P75AllocObjects uint64 `json:"p75_alloc_objects,omitempty"`
+	P90AllocObjects uint64 `json:"p90_alloc_objects,omitempty"`
+	P99AllocObjects uint64 `json:"p99_alloc_objects,omitempty"`
+
+	// Total CPU time consumed by this call and the remaining time that the
+	// call spent off the CPU (e.g. blocked on I/O). The CPU time metrics are
+	// only populated if the profiler was initialized with the TrackCPUTime
+	// option on a supported platform.
+	CPUTime    time.Duration `json:"cpu_time,omitempty"`
+	OffCPUTime time.Duration `json:"off_cpu_time,omitempty"`
+
+	// Mean CPU and off-CPU time.
+	MeanCPUTime    time.Duration `json:"mean_cpu_time,omitempty"`
+	MeanOffCPUTime time.Duration `json:"mean_off_cpu_time,omitempty"`
+
 	NestedCalls []*CallMetrics `json:"calls"`
 }
 
@@ -121,6 +325,35 @@ type fnCall struct {
 	// The ordered list of calls originating from this call's scope.
 	nestedCalls []*fnCall
 
+	// Set if this call tracks the work performed by a goroutine spawned
+	// from the parent call.
+	async bool
+
+	// Set if this is an async call whose goroutine was still running when
+	// the profile was shipped.
+	unfinished bool
+
+	// Set if this call was exited due to a panic.
+	panicked bool
+
+	// Set if this call returned a non-nil error.
+	failed bool
+
+	// The heap allocation counters at the time of entry/exit for this call
+	// and the allocations performed by the profiler hooks. Only populated
+	// when tracking allocations.
+	enteredAllocs allocCounters
+	exitedAllocs  allocCounters
+	allocOverhead allocCounters
+
+	// The CPU time consumed by the thread running this call at the time of
+	// entry/exit. Only populated when tracking CPU time.
+	enteredCPU time.Duration
+	exitedCPU  time.Duration
+
+	// The ID of the profile. Only populated for the root call.
+	profileID uint64
+
 	// The call via which this call was reached.
 	parent *fnCall
 
@@ -142,6 +375,16 @@ func makeFnCall(fnName string) *fnCall {
 	call.profilerOverhead = 0
 	call.nestedCalls = make([]*fnCall, 0)
 	call.parent = nil
+	call.async = false
+	call.unfinished = false
+	call.panicked = false
+	call.failed = false
+	call.enteredAllocs = allocCounters{}
+	call.exitedAllocs = allocCounters{}
+	call.allocOverhead = allocCounters{}
+	call.enteredCPU = 0
+	call.exitedCPU = 0
+	call.profileID = 0
 
 	return call
 }
@@ -302,18 +545,52 @@ func (t *callGroupTree) linkGroups() {
 func (t *callGroupTree) groupMetrics(cg *callGroup) *CallMetrics {
 	groupCallMetrics := make(metricsList, len(cg.calls))
 	for callIndex, call := range cg.calls {
-		// Our overhead calculation codes uses the mean fn call overhead
-		// estimated by a calibration loop. This may cause the estimated total
-		// time to become negative due to jitter so we need to ensure we track
-		// at least 1ns of total time
-		totalTime := call.exitedAt.Sub(call.enteredAt) - call.profilerOverhead
-		if totalTime <= 0 {
-			totalTime = 1 * time.Nanosecond
+		totalTime := call.totalTime()
+
+		// The self time excludes the time spent in nested calls. Async
+		// calls run concurrently to the call that spawned them so their
+		// time is not subtracted
+		selfTime := totalTime
+		for _, nestedCall := range call.nestedCalls {
+			if !nestedCall.async {
+				selfTime -= nestedCall.totalTime()
+			}
+		}
+		if selfTime < 0 {
+			selfTime = 0
+		}
+
+		// The wait time is not adjusted for the profiler overhead so
+		// clamp it to the total time
+		waitTime := call.waitTime()
+		if waitTime > totalTime {
+			waitTime = totalTime
 		}
 
 		groupCallMetrics[callIndex] = &CallMetrics{
 			FnName:    call.fnName,
 			TotalTime: totalTime,
+			SelfTime:  selfTime,
+			Async:     call.async,
+			WaitTime:  waitTime,
+		}
+		if call.unfinished {
+			groupCallMetrics[callIndex].Unfinished = 1
+		}
+		if call.panicked {
+			groupCallMetrics[callIndex].Panics = 1
+		}
+		if call.failed {
+			groupCallMetrics[callIndex].Errors = 1
+		}
+
+		allocs := call.exitedAllocs.sub(call.enteredAllocs).sub(call.allocOverhead)
+		groupCallMetrics[callIndex].AllocBytes = allocs.bytes
+		groupCallMetrics[callIndex].AllocObjects = allocs.objects
+
+		if cpuTime, tracked := call.cpuTime(totalTime); tracked {
+			groupCallMetrics[callIndex].CPUTime = cpuTime
+			groupCallMetrics[callIndex].OffCPUTime = totalTime - cpuTime
 		}
 	}
 	cm := groupCallMetrics.aggregate()
@@ -327,6 +604,57 @@ func (t *callGroupTree) groupMetrics(cg *callGroup) *CallMetrics {
 	return cm
 }
 
+// totalTime calculates the time spent in a call excluding the profiler overhead.
+func (fn *fnCall) totalTime() time.Duration {
+	// Our overhead calculation codes uses the mean fn call overhead
+	// estimated by a calibration loop. This may cause the estimated total
+	// time to become negative due to jitter so we need to ensure we track
+	// at least 1ns of total time
+	totalTime := fn.exitedAt.Sub(fn.enteredAt) - fn.profilerOverhead
+	if totalTime <= 0 {
+		totalTime = 1 * time.Nanosecond
+	}
+
+	return totalTime
+}
+
+// cpuTime calculates the CPU time consumed by a call excluding the profiler
+// overhead. As the profiler hooks do not block, their overhead is entirely
+// spent on the CPU. The result is clamped to the call's total time. The
+// returned flag is false if the CPU time was not tracked for this call.
+func (fn *fnCall) cpuTime(totalTime time.Duration) (time.Duration, bool) {
+	if fn.exitedCPU == 0 {
+		return 0, false
+	}
+
+	cpuTime := fn.exitedCPU - fn.enteredCPU - fn.profilerOverhead
+	if cpuTime < 0 {
+		cpuTime = 0
+	} else if cpuTime > totalTime {
+		cpuTime = totalTime
+	}
+
+	return cpuTime, true
+}
+
+// waitTime calculates the amount of time that the parent of an async call was
+// still running while the goroutine tracked by the async call was running.
+func (fn *fnCall) waitTime() time.Duration {
+	if !fn.async || fn.parent == nil {
+		return 0
+	}
+
+	waitUntil := fn.exitedAt
+	if fn.parent.exitedAt.Before(waitUntil) {
+		waitUntil = fn.parent.exitedAt
+	}
+
+	if waitTime := waitUntil.Sub(fn.enteredAt); waitTime > 0 {
+		return waitTime
+	}
+	return 0
+}
+
 // genProfile post-processes the data captured by the profiler into a Profile
 // instance consisting of a tree structure of CallMetrics instances.
 func genProfile(ID uint64, label string, rootFnCall *fnCall) *Profile {
@@ -18,6 +18,8 @@ func TestGroupMetrics(t *testing.T) {
 			&CallMetrics{
 				FnName:    fnName,
 				TotalTime: time.Duration(i) * time.Millisecond,
+				// Self times are in reverse order compared to total times
+				SelfTime: time.Duration(numMetrics-1-i) * time.Millisecond,
 			},
 		)
 	}
@@ -37,6 +39,14 @@ func TestGroupMetrics(t *testing.T) {
 		"p75_time": metrics[75-1].TotalTime,
 		"p90_time": metrics[90-1].TotalTime,
 		"p99_time": metrics[99-1].TotalTime,
+		//
+		"self_time":        time.Duration(numMetrics/2) * (metrics[0].TotalTime + metrics[numMetrics-1].TotalTime),
+		"min_self_time":    0,
+		"max_self_time":    metrics[numMetrics-1].TotalTime,
+		"mean_self_time":   metrics[0].TotalTime + metrics[numMetrics-1].TotalTime/2,
+		"median_self_time": (metrics[(numMetrics/2)-1].TotalTime + metrics[numMetrics/2].TotalTime) / 2,
+		"p50_self_time":    metrics[50-1].TotalTime,
+		"p99_self_time":    metrics[99-1].TotalTime,
 	}
 
 	dataDump, _ := json.Marshal(groupedMetric)
@@ -126,6 +136,19 @@ func TestGenProfile(t *testing.T) {
 		t.Fatalf("expected func1 total time (sans any overhead) to be %d; got %d", expRootTotalTime, profile.Target.TotalTime)
 	}
 
+	if profile.Target.SelfTime != timeInRoot {
+		t.Fatalf("expected func1 self time (sans any nested calls) to be %d; got %d", timeInRoot, profile.Target.SelfTime)
+	}
+
+	// func2 does not make any nested calls so its self time should match its total time
+	nestedMetrics := profile.Target.NestedCalls[0]
+	if nestedMetrics.SelfTime != totalTimeInNestedCalls {
+		t.Fatalf("expected func2 self time to be %d; got %d", totalTimeInNestedCalls, nestedMetrics.SelfTime)
+	}
+	if nestedMetrics.MinSelfTime != 1*time.Millisecond || nestedMetrics.MaxSelfTime != time.Duration(numNestedCalls)*time.Millisecond {
+		t.Fatalf("expected func2 min/max self time to be 1ms/%dms; got %v/%v", numNestedCalls, nestedMetrics.MinSelfTime, nestedMetrics.MaxSelfTime)
+	}
+
 	nestedCalls := root.nestedCalls
 	root.free()
 	if root.nestedCalls != nil {
@@ -2,106 +2,222 @@ package profiler
 
 import (
 	"fmt"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	defaultSinkBufferSize = 100
-	numCalibrationCalls   = 10000000
 )
 
 var (
-	// A mutex for protecting access to the activeProfiles map.
+	// A mutex for synchronizing the goroutines that contribute async calls
+	// to the same profile.
 	profileMutex sync.Mutex
 
 	// A label to be applied to generated profiles.
 	profileLabel string
 
-	// We maintain a dedicated call stack for each profiled goroutine. Each
-	// map entry points to the currently entered function scope.
-	activeProfiles map[uint64]*fnCall
+	// The ID of the most recently created profile. Profile IDs are assigned
+	// from this counter so that they are unique within the process.
+	lastProfileID uint64
 
-	// A sink for emitted profile entries.
+	// We maintain a dedicated call stack for each profiled goroutine indexed
+	// by its goroutine key. As each call stack is only accessed by the
+	// goroutine that owns it, the hooks can access their call stack
+	// without any locking.
+	activeStacks *sync.Map
+
+	// The number of entries in activeStacks. It allows the profiler hooks to
+	// return early when no profiles are active.
+	activeCallStacks int32
+
+	// A sink for emitted profile entries and a flag indicating whether it
+	// has been closed by Shutdown. Goroutines shipping profiles hold a read
+	// lock on sinkMutex while sending to the sink.
 	outputSink Sink
+	sinkMutex  sync.RWMutex
+	sinkClosed bool
+
+	// The options specified when initializing the profiler.
+	profilerOpts options
 
-	// Function call invokation overhead; calculated by calibrate() and triggered by init()
-	timeNowOverhead, timeSinceOverhead, deferredFnOverhead, fnCallOverhead time.Duration
+	// The sampler for profile target invocations; nil if sampling is disabled.
+	targetSampler *sampler
+
+	// The sampler for the profiles that track allocations; nil if the
+	// allocations of all profiles are tracked.
+	allocSampler *sampler
 )
 
-func init() {
-	calibrate()
-}
-
-// callibrate attempts to estimate the mean overhead for invoking time.Now(), time.Since(),
-// as well as the mean time spent in function guard code (stack setup, pushing/popping
-// registers, dealing with deferred calls e.t.c).
-//
-// Runtime overhead is generally in the nanosecond range but we need to
-// properly account for it when calculating the total time spent inside a
-// profiled function as it tends to skew our timing calculations when the profiled
-// function is invoked a large number of times.
-//
-// To calculate an estimate, we time N executions of each function and then just
-// calculate the mean execution time. As outliers may skew our results, using
-// the median execution time would be better but calculating it is not a trivial
-// operation due to the space and timing accurace required.
-func calibrate() {
-	// Benchmark function call time. We use a switch statement to ensure that
-	// the compiler will not inline this function (see https://github.com/golang/go/issues/12312)
-	fnCallBench := func(i int) {
-		switch i {
-		}
+// Init handles the initialization of the prism profiler. This method must be
+// called before invoking any other method from this package. The profiler
+// behavior can be customized by passing one or more options.
+func Init(sink Sink, capturedProfileLabel string, opts ...Option) {
+	err := sink.Open(defaultSinkBufferSize)
+	if err != nil {
+		err = fmt.Errorf("profiler: error initializing sink: %s", err)
+		panic(err)
 	}
-	tick := time.Now()
-	for i := 0; i < numCalibrationCalls; i++ {
-		fnCallBench(i)
+
+	sinkMutex.Lock()
+	outputSink = sink
+	sinkClosed = false
+	sinkMutex.Unlock()
+
+	activeStacks = &sync.Map{}
+	atomic.StoreInt32(&activeCallStacks, 0)
+	profileLabel = capturedProfileLabel
+
+	profilerOpts = options{}
+	for _, opt := range opts {
+		opt(&profilerOpts)
 	}
-	fnCallOverhead = time.Since(tick) / time.Duration(numCalibrationCalls)
+	targetSampler = newSampler(profilerOpts)
+	allocSampler = newSampler(options{sampleEvery: profilerOpts.trackAllocsEvery})
+	initCalibration(profilerOpts)
+}
+
+// callStack tracks the currently entered function call of a profiled goroutine.
+type callStack struct {
+	active *fnCall
+
+	// The number of BeginProfile calls that were invoked while the goroutine
+	// was already being profiled and did not create a profile. Each one of
+	// them is matched by an EndProfile call that must be ignored.
+	skipped int
 
-	// Benchmark deferred function call time
-	deferBench := func(i int) {
-		defer func() { fnCallBench(i) }()
+	// The async calls of the profile that the goroutine contributes to. It
+	// is lazily allocated by the first Fork call of a profile target.
+	async *asyncCalls
+
+	// Set if the profile that the goroutine contributes to tracks
+	// allocations.
+	trackAllocs bool
+}
+
+// asyncCalls tracks the async calls of a profile that are still running. Its
+// fields are protected by profileMutex.
+type asyncCalls struct {
+	running []*fnCall
+
+	// Set when the profile has been shipped. As the profile is shipped as
+	// soon as its target returns, any async calls that are still running
+	// are detached from the call tree and their calls are no longer
+	// recorded. It is also read atomically by the Enter hook.
+	shipped int32
+}
+
+// Check whether the profile that the async calls belong to has been shipped.
+func (a *asyncCalls) isShipped() bool {
+	return a != nil && atomic.LoadInt32(&a.shipped) != 0
+}
+
+// Remove a call from the list of running async calls.
+func (a *asyncCalls) remove(call *fnCall) {
+	for index, running := range a.running {
+		if running == call {
+			last := len(a.running) - 1
+			a.running[index] = a.running[last]
+			a.running[last] = nil
+			a.running = a.running[:last]
+			return
+		}
 	}
-	tick = time.Now()
-	for i := 0; i < numCalibrationCalls; i++ {
-		deferBench(i)
+}
+
+// Detach the async calls that are still running from the call tree of a
+// profile that is about to be shipped. Each detached call is replaced by a
+// copy that is marked as unfinished and whose exit time is set to the
+// specified time. The detached calls are left untouched as the goroutines
+// running them may still update them. Any async calls nested under a
+// detached call are dropped together with it. This function must be invoked
+// while holding profileMutex.
+func (a *asyncCalls) detach(exitedAt time.Time) {
+	atomic.StoreInt32(&a.shipped, 1)
+
+	running := make(map[*fnCall]struct{}, len(a.running))
+	for _, call := range a.running {
+		running[call] = struct{}{}
 	}
-	deferredFnOverhead = time.Since(tick) / time.Duration(numCalibrationCalls)
 
-	// Benchmark time.Now()
-	tick = time.Now()
-	for i := 0; i < numCalibrationCalls; i++ {
-		time.Now()
+nextCall:
+	for _, call := range a.running {
+		for ancestor := call.parent; ancestor != nil; ancestor = ancestor.parent {
+			if _, found := running[ancestor]; found {
+				continue nextCall
+			}
+		}
+
+		unfinished := makeFnCall(call.fnName)
+		unfinished.enteredAt = call.enteredAt
+		unfinished.exitedAt = exitedAt
+		unfinished.async = true
+		unfinished.unfinished = true
+		unfinished.parent = call.parent
+		for index, sibling := range call.parent.nestedCalls {
+			if sibling == call {
+				call.parent.nestedCalls[index] = unfinished
+				break
+			}
+		}
 	}
-	timeNowOverhead = fnCallOverhead + time.Since(tick)/time.Duration(numCalibrationCalls)
 
-	// Benchmark time.Since()
-	tick = time.Now()
-	for i := 0; i < numCalibrationCalls; i++ {
-		time.Since(tick)
+	a.running = nil
+}
+
+// Check whether any goroutine has an active call stack.
+func hasActiveCallStacks() bool {
+	return atomic.LoadInt32(&activeCallStacks) != 0
+}
+
+// Get the call stack for the goroutine with the given key or nil if the
+// goroutine is not being profiled.
+func lookupCallStack(key uintptr) *callStack {
+	stack, found := activeStacks.Load(key)
+	if !found {
+		return nil
 	}
-	timeSinceOverhead = fnCallOverhead + time.Since(tick)/time.Duration(numCalibrationCalls)
+	return stack.(*callStack)
 }
 
-// Init handles the initialization of the prism profiler. This method must be
-// called before invoking any other method from this package.
-func Init(sink Sink, capturedProfileLabel string) {
-	err := sink.Open(defaultSinkBufferSize)
-	if err != nil {
-		err = fmt.Errorf("profiler: error initializing sink: %s", err)
-		panic(err)
+// Make call the active call for the goroutine with the given key, creating a
+// call stack for the goroutine if it does not already have one. This function
+// must only be invoked by the goroutine that owns the key.
+func pushCallStack(key uintptr, call *fnCall) *callStack {
+	if stack := lookupCallStack(key); stack != nil {
+		stack.active = call
+		return stack
 	}
 
-	outputSink = sink
-	activeProfiles = make(map[uint64]*fnCall, 0)
-	profileLabel = capturedProfileLabel
+	stack := &callStack{active: call}
+	activeStacks.Store(key, stack)
+	atomic.AddInt32(&activeCallStacks, 1)
+	return stack
+}
+
+// Remove the call stack for the goroutine with the given key. This function
+// must only be invoked by the goroutine that owns the key.
+func dropCallStack(key uintptr) {
+	if _, found := activeStacks.Load(key); !found {
+		return
+	}
+
+	activeStacks.Delete(key)
+	atomic.AddInt32(&activeCallStacks, -1)
 }
 
 // Shutdown waits for shippers to fully dequeue any buffered profiles and shuts
 // them down. This method should be called by main() before the program exits
 // to ensure that no profile data is lost if the program executes too fast.
+// Any profiles whose targets return after Shutdown is called are discarded.
 func Shutdown() {
+	sinkMutex.Lock()
+	sinkClosed = true
+	sinkMutex.Unlock()
+
 	err := outputSink.Close()
 	if err != nil {
 		err = fmt.Errorf("profiler: error shutting downg sink: %s", err)
@@ -109,67 +225,261 @@ func Shutdown() {
 	}
 }
 
-// BeginProfile creates a new profile.
+// BeginProfile creates a new profile. If the profiler was initialized with
+// any sampling options, a profile is only created for the sampled invocations
+// of each profile target. If the calling goroutine is already being profiled
+// (e.g. the target was invoked by another profile target), no profile is
+// created and the matching EndProfile call is a no-op.
 func BeginProfile(rootFnName string) {
 	tick := time.Now()
+	if hasActiveCallStacks() {
+		if stack := lookupCallStack(goroutineKey()); stack != nil {
+			stack.skipped++
+			return
+		}
+	}
 
-	tid := threadID()
+	if !targetSampler.sample(rootFnName, tick) {
+		return
+	}
 
 	rootCall := makeFnCall(rootFnName)
 	rootCall.enteredAt = tick
+	rootCall.profileID = atomic.AddUint64(&lastProfileID, 1)
 
-	profileMutex.Lock()
-	activeProfiles[tid] = rootCall
-	profileMutex.Unlock()
+	stack := pushCallStack(goroutineKey(), rootCall)
+	stack.trackAllocs = profilerOpts.trackAllocs && allocSampler.sample(rootFnName, tick)
+	if stack.trackAllocs {
+		rootCall.enteredAllocs = readAllocCounters()
+	}
+	if profilerOpts.trackCPUTime {
+		runtime.LockOSThread()
+		rootCall.enteredCPU = threadCPUTime()
+	}
 
 	rootCall.profilerOverhead += timeNowOverhead + timeSinceOverhead + fnCallOverhead + time.Since(tick)
 }
 
-// EndProfile finalizes and ships a currently active profile.
+// EndProfile finalizes and ships a currently active profile. Any goroutines
+// spawned while the profile was active that are still running are reported
+// as unfinished async calls.
 func EndProfile() {
-	tick := time.Now()
-	tid := threadID()
+	endProfile(time.Now(), false, false)
+}
 
-	profileMutex.Lock()
-	rootCall := activeProfiles[tid]
-	if rootCall == nil {
-		// No active profile for this threadID; skip
-		profileMutex.Unlock()
+// EndProfileWithPanic finalizes and ships a currently active profile like
+// EndProfile. It should be invoked by a deferred function with a flag
+// indicating whether the profile target is being unwound by a panic. The
+// flag is expected to be set on entry and cleared just before the target
+// returns so the panic does not need to be recovered and keeps unwinding the
+// stack once the profile is shipped.
+func EndProfileWithPanic(panicking bool) {
+	endProfile(time.Now(), panicking, false)
+}
+
+// EndProfileWithError finalizes and ships a currently active profile like
+// EndProfileWithPanic. It should be invoked by a deferred function of a
+// profile target whose last result is an error with the panicking flag and
+// the returned error. If the target returns normally with a non-nil error,
+// the call is marked as failed.
+func EndProfileWithError(panicking bool, err error) {
+	endProfile(time.Now(), panicking, !panicking && err != nil)
+}
+
+func endProfile(tick time.Time, panicked, failed bool) {
+	if !hasActiveCallStacks() {
 		return
 	}
 
-	delete(activeProfiles, tid)
-	profileMutex.Unlock()
+	key := goroutineKey()
+	stack := lookupCallStack(key)
+	if stack == nil {
+		// No active profile for this goroutine; skip
+		return
+	}
+
+	if stack.skipped != 0 {
+		// Matches a BeginProfile call that did not create a profile
+		stack.skipped--
+		return
+	}
+
+	rootCall := stack.active
+	if rootCall.parent != nil {
+		// The goroutine is running an async call which can only be exited
+		// by EndAsync; skip
+		return
+	}
 
-	// Generate profile;
+	if stack.trackAllocs {
+		rootCall.exitedAllocs = readAllocCounters()
+	}
+	dropCallStack(key)
+
+	if profilerOpts.trackCPUTime {
+		rootCall.exitedCPU = threadCPUTime()
+		runtime.UnlockOSThread()
+	}
+
+	rootCall.panicked = panicked
+	rootCall.failed = failed
 	rootCall.exitedAt = time.Now()
 	rootCall.profilerOverhead += 2*timeNowOverhead + timeSinceOverhead + deferredFnOverhead + time.Since(tick)
-	profile := genProfile(tid, profileLabel, rootCall)
+
+	if stack.async != nil {
+		profileMutex.Lock()
+		stack.async.detach(rootCall.exitedAt)
+		profileMutex.Unlock()
+	}
+
+	shipProfile(rootCall, stack.trackAllocs)
+}
+
+// Generate a profile from a finalized call tree and ship it to the sink. The
+// profile is discarded if the sink has already been closed by Shutdown.
+func shipProfile(rootCall *fnCall, allocsTracked bool) {
+	profile := genProfile(rootCall.profileID, profileLabel, rootCall)
+	profile.AllocsTracked = allocsTracked
+	calibration := *activeCalibration
+	profile.Calibration = &calibration
 	rootCall.free()
 
-	// Ship profile
-	outputSink.Input() <- profile
+	sinkMutex.RLock()
+	defer sinkMutex.RUnlock()
+	if !sinkClosed {
+		outputSink.Input() <- profile
+	}
 }
 
-// Enter adds a new nested function call to the profile linked to the current go-routine ID.
-func Enter(fnName string) {
+// AsyncCall links a goroutine spawned by a go statement to the function call
+// that was active in the spawning goroutine.
+type AsyncCall struct {
+	call        *fnCall
+	async       *asyncCalls
+	trackAllocs bool
+}
+
+// Fork creates a new async call nested under the currently active function
+// call of the calling goroutine. The returned AsyncCall should be passed to
+// BeginAsync and EndAsync by the spawned goroutine so that any profiled calls
+// it makes appear as a nested async branch of the spawning call. If no profile
+// is active for the calling goroutine or the profile has already been
+// shipped, Fork returns nil.
+func Fork(asyncFnName string) *AsyncCall {
+	if !hasActiveCallStacks() {
+		return nil
+	}
+
 	tick := time.Now()
-	tid := threadID()
+	stack := lookupCallStack(goroutineKey())
+	if stack == nil {
+		// No active profile for this goroutine; skip
+		return nil
+	}
+
+	if stack.async == nil {
+		// Only the goroutine running the profile target can get here
+		stack.async = &asyncCalls{}
+	}
+
+	parentCall := stack.active
+	call := makeFnCall(asyncFnName)
+	call.enteredAt = tick
+	call.async = true
 
 	profileMutex.Lock()
-	parentCall := activeProfiles[tid]
-	if parentCall == nil {
-		// No active profile for this threadID; skip
+	if stack.async.isShipped() {
 		profileMutex.Unlock()
+		call.free()
+		return nil
+	}
+	parentCall.nestCall(call)
+	stack.async.running = append(stack.async.running, call)
+	profileMutex.Unlock()
+
+	// Update overhead estimate for the spawning call
+	parentCall.profilerOverhead += timeNowOverhead + timeSinceOverhead + fnCallOverhead + time.Since(tick)
+	return &AsyncCall{call: call, async: stack.async, trackAllocs: stack.trackAllocs}
+}
+
+// BeginAsync makes an async call returned by Fork the active call for the
+// calling goroutine. Calling BeginAsync with a nil AsyncCall is a no-op.
+func BeginAsync(async *AsyncCall) {
+	if async == nil {
+		return
+	}
+
+	stack := pushCallStack(goroutineKey(), async.call)
+	stack.async = async.async
+	stack.trackAllocs = async.trackAllocs
+	if profilerOpts.trackCPUTime {
+		runtime.LockOSThread()
+		async.call.enteredCPU = threadCPUTime()
+	}
+}
+
+// EndAsync exits an async call returned by Fork. If the profile that the async
+// call belongs to has already been shipped, the calls recorded by the
+// goroutine are discarded. Calling EndAsync with a nil AsyncCall is a no-op.
+func EndAsync(async *AsyncCall) {
+	if async == nil {
+		return
+	}
+
+	exitedAt := time.Now()
+	call := async.call
+	if profilerOpts.trackCPUTime {
+		call.exitedCPU = threadCPUTime()
+		runtime.UnlockOSThread()
+	}
+
+	// The goroutine is about to exit so its call stack can be dropped even
+	// if it contains unbalanced calls
+	dropCallStack(goroutineKey())
+
+	// A shipped profile no longer references the call so the call tree
+	// recorded by this goroutine is left to the garbage collector
+	profileMutex.Lock()
+	if !async.async.isShipped() {
+		call.exitedAt = exitedAt
+		async.async.remove(call)
+	}
+	profileMutex.Unlock()
+}
+
+// Enter adds a new nested function call to the profile linked to the current go-routine ID.
+func Enter(fnName string) {
+	if !hasActiveCallStacks() {
 		return
 	}
 
+	tick := time.Now()
+	stack := lookupCallStack(goroutineKey())
+	if stack == nil || stack.async.isShipped() {
+		// No active profile for this goroutine or the profile has
+		// already been shipped; skip
+		return
+	}
+
+	// When tracking allocations, any allocations performed while setting
+	// up the call are tracked as profiler overhead
+	var enteredAllocs allocCounters
+	if stack.trackAllocs {
+		enteredAllocs = readAllocCounters()
+	}
+
 	call := makeFnCall(fnName)
 	call.enteredAt = tick
-	parentCall.nestCall(call)
+	if profilerOpts.trackCPUTime {
+		call.enteredCPU = threadCPUTime()
+	}
+	stack.active.nestCall(call)
+	stack.active = call
 
-	activeProfiles[tid] = call
-	profileMutex.Unlock()
+	if stack.trackAllocs {
+		call.enteredAllocs = enteredAllocs
+		call.allocOverhead = readAllocCounters().sub(enteredAllocs)
+	}
 
 	// Update overhead estimate
 	call.profilerOverhead += timeNowOverhead + timeSinceOverhead + fnCallOverhead + time.Since(tick)
@@ -177,30 +487,61 @@ func Enter(fnName string) {
 
 // Leave exits the current function in the profile linked to the current go-routine ID.
 func Leave() {
-	tick := time.Now()
-	tid := threadID()
+	leave(time.Now(), false, false)
+}
 
-	profileMutex.Lock()
-	call := activeProfiles[tid]
-	if call == nil {
-		// No active profile for this threadID; skip
-		profileMutex.Unlock()
+// LeaveWithPanic exits the current function like Leave. It should be invoked
+// by a deferred function with a flag indicating whether the function is being
+// unwound by a panic. Like with EndProfileWithPanic, the panic is never
+// recovered.
+func LeaveWithPanic(panicking bool) {
+	leave(time.Now(), panicking, false)
+}
+
+// LeaveWithError exits the current function like LeaveWithPanic. It should
+// be invoked by a deferred function of a function whose last result is an
+// error with the panicking flag and the returned error. If the function
+// returns normally with a non-nil error, the call is marked as failed.
+func LeaveWithError(panicking bool, err error) {
+	leave(time.Now(), panicking, !panicking && err != nil)
+}
+
+func leave(tick time.Time, panicked, failed bool) {
+	if !hasActiveCallStacks() {
 		return
 	}
 
-	if call.parent == nil {
-		profileMutex.Unlock()
-		panic(fmt.Sprintf("profiler: [BUG] attempted to exit an active profile (tid %d)", tid))
+	stack := lookupCallStack(goroutineKey())
+	if stack == nil {
+		// No active profile for this goroutine; skip
+		return
+	}
+
+	call := stack.active
+	if call.parent == nil || call.async {
+		// The active call is a profile target or an async call which can
+		// only be exited by EndProfile or EndAsync. This can happen if a
+		// function was entered before the profile became active; skip
+		return
+	}
+
+	if stack.trackAllocs {
+		call.exitedAllocs = readAllocCounters()
 	}
 
 	// Exit current scope
-	activeProfiles[tid] = call.parent
-	profileMutex.Unlock()
+	stack.active = call.parent
 
 	// Update exit timestamp and overhead estimate for the parent. We also add in
 	// an extra fnCallOverhead to account for the pointer dereferencing code for
 	// updating the parent's overhead
+	call.panicked = panicked
+	call.failed = failed
+	if profilerOpts.trackCPUTime {
+		call.exitedCPU = threadCPUTime()
+	}
 	call.exitedAt = time.Now()
-	call.profilerOverhead += 2*timeNowOverhead + timeSinceOverhead + deferredFnOverhead + 2*fnCallOverhead + time.Since(tick)
+	call.profilerOverhead += 2*timeNowOverhead + timeSinceOverhead + deferredFnOverhead + 3*fnCallOverhead + time.Since(tick)
 	call.parent.profilerOverhead += call.profilerOverhead
+	call.parent.allocOverhead = call.parent.allocOverhead.add(call.allocOverhead)
 }
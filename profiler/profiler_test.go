@@ -1,6 +1,7 @@
 package profiler
 
 import (
+	"errors"
 	"sync"
 	"testing"
 	"time"
@@ -51,9 +52,8 @@ func TestProfiler(t *testing.T) {
 
 	profile := sink.buffer[0]
 
-	expID := threadID()
-	if profile.ID != expID {
-		t.Fatalf("expected profile ID to be %d; got %d", expID, profile.ID)
+	if profile.ID == 0 {
+		t.Fatal("expected profile to be assigned a non-zero ID")
 	}
 
 	expInvocations := 1
@@ -71,6 +71,498 @@ func TestProfiler(t *testing.T) {
 	}
 }
 
+func TestProfilerAsync(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test")
+
+	BeginProfile("func1")
+	Enter("func2")
+
+	// Spawn a goroutine that outlives the spawning call but not the
+	// profiled target
+	async := Fork("go worker")
+	workerStarted := make(chan struct{})
+	func2Exited := make(chan struct{})
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		BeginAsync(async)
+		defer EndAsync(async)
+
+		Enter("worker")
+		close(workerStarted)
+		<-func2Exited
+		<-time.After(10 * time.Millisecond)
+		Leave()
+	}()
+
+	<-workerStarted
+	<-time.After(5 * time.Millisecond)
+	Leave()
+	close(func2Exited)
+	<-workerDone
+	EndProfile()
+
+	// Calling BeginAsync/EndAsync with a nil AsyncCall should be a no-op
+	BeginAsync(Fork("go noProfile"))
+	EndAsync(nil)
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	func2 := sink.buffer[0].Target.NestedCalls[0]
+	if len(func2.NestedCalls) != 1 {
+		t.Fatalf("expected func2 to capture 1 nested call; got %d", len(func2.NestedCalls))
+	}
+
+	asyncCall := func2.NestedCalls[0]
+	if asyncCall.FnName != "go worker" || !asyncCall.Async {
+		t.Fatalf("expected func2 to capture an async call named %q; got %+v", "go worker", asyncCall)
+	}
+
+	if len(asyncCall.NestedCalls) != 1 || asyncCall.NestedCalls[0].FnName != "worker" {
+		t.Fatalf("expected async call to capture the worker call; got %+v", asyncCall.NestedCalls)
+	}
+
+	// func2 exits before the worker so the async call should outlast the wait time
+	if asyncCall.WaitTime < 5*time.Millisecond || asyncCall.WaitTime >= asyncCall.TotalTime {
+		t.Fatalf("expected async call wait time to be >= 5ms and less than its total time %v; got %v", asyncCall.TotalTime, asyncCall.WaitTime)
+	}
+
+	if asyncCall.NestedCalls[0].TotalTime < 10*time.Millisecond {
+		t.Fatalf("expected worker total time to be >= 10ms; got %v", asyncCall.NestedCalls[0].TotalTime)
+	}
+
+	if asyncCall.Unfinished != 0 {
+		t.Fatalf("expected async call to be finished; got %d unfinished invocations", asyncCall.Unfinished)
+	}
+}
+
+func TestProfilerUnfinishedAsync(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test")
+
+	BeginProfile("func1")
+
+	// Spawn a goroutine that keeps running after the profile is shipped
+	// and the profiler is shut down
+	async := Fork("go worker")
+	workerStarted := make(chan struct{})
+	profilerShutdown := make(chan struct{})
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		BeginAsync(async)
+		defer EndAsync(async)
+
+		Enter("worker")
+		go func(async *AsyncCall) {
+			BeginAsync(async)
+			defer EndAsync(async)
+			Enter("nestedWorker")
+			Leave()
+		}(Fork("go nestedWorker"))
+		close(workerStarted)
+		<-profilerShutdown
+		Leave()
+
+		// The profile has been shipped so these calls should be ignored
+		Enter("afterShip")
+		Leave()
+		if Fork("go afterShip") != nil {
+			t.Errorf("expected Fork to return nil after the profile was shipped")
+		}
+	}()
+
+	<-workerStarted
+	<-time.After(5 * time.Millisecond)
+	EndProfile()
+
+	// Shutdown and flush sink. The profile should be shipped without
+	// waiting for the worker to exit.
+	Shutdown()
+
+	// Exiting the async call after the sink is closed should not panic
+	close(profilerShutdown)
+	<-workerDone
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	target := sink.buffer[0].Target
+	if len(target.NestedCalls) != 1 {
+		t.Fatalf("expected target to capture 1 nested call; got %d", len(target.NestedCalls))
+	}
+
+	asyncCall := target.NestedCalls[0]
+	if asyncCall.FnName != "go worker" || !asyncCall.Async || asyncCall.Unfinished != 1 {
+		t.Fatalf("expected target to capture an unfinished async call named %q; got %+v", "go worker", asyncCall)
+	}
+
+	// The calls made by unfinished goroutines are not included
+	if len(asyncCall.NestedCalls) != 0 {
+		t.Fatalf("expected unfinished async call to capture no nested calls; got %d", len(asyncCall.NestedCalls))
+	}
+
+	// The time of unfinished async calls is tracked until the target returns
+	if asyncCall.TotalTime < 5*time.Millisecond {
+		t.Fatalf("expected unfinished async call total time to be >= 5ms; got %v", asyncCall.TotalTime)
+	}
+
+	if hasActiveCallStacks() {
+		t.Fatal("expected all call stacks to be inactive")
+	}
+}
+
+func TestProfilerPanics(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test")
+
+	// Simulate the hooks injected to a profile target that recovers from a
+	// panic raised by a nested call. As the panic unwinds the body of the
+	// target, it is also reported as a panic
+	func() {
+		prismPanicking := true
+		BeginProfile("target")
+		defer func() { EndProfileWithPanic(prismPanicking) }()
+		defer func() { recover() }()
+
+		func() {
+			prismPanicking := true
+			Enter("nested")
+			defer func() { LeaveWithPanic(prismPanicking) }()
+			panic("boom")
+		}()
+		prismPanicking = false
+	}()
+
+	// Panics that are not recovered by the profile target should keep
+	// unwinding the stack
+	var recovered interface{}
+	func() {
+		defer func() { recovered = recover() }()
+
+		prismPanicking := true
+		BeginProfile("target")
+		defer func() { EndProfileWithPanic(prismPanicking) }()
+		panic("boom")
+	}()
+
+	if recovered != "boom" {
+		t.Fatalf("expected the panic to propagate after exiting the profile target; recovered %v", recovered)
+	}
+
+	// Unbalanced calls to Leave should not exit the profile target
+	BeginProfile("target")
+	Leave()
+	EndProfile()
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expEntries := 3
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	specs := []struct {
+		ExpTargetPanics int
+		ExpNestedPanics []int
+	}{
+		{1, []int{1}},
+		{1, []int{}},
+		{0, []int{}},
+	}
+
+	for specIndex, spec := range specs {
+		target := sink.buffer[specIndex].Target
+		if target.Panics != spec.ExpTargetPanics {
+			t.Errorf("[spec %d] expected target panic count to be %d; got %d", specIndex, spec.ExpTargetPanics, target.Panics)
+		}
+
+		if len(target.NestedCalls) != len(spec.ExpNestedPanics) {
+			t.Errorf("[spec %d] expected target to capture %d nested calls; got %d", specIndex, len(spec.ExpNestedPanics), len(target.NestedCalls))
+			continue
+		}
+
+		for index, expPanics := range spec.ExpNestedPanics {
+			if target.NestedCalls[index].Panics != expPanics {
+				t.Errorf("[spec %d] expected nested call %d panic count to be %d; got %d", specIndex, index, expPanics, target.NestedCalls[index].Panics)
+			}
+		}
+	}
+}
+
+func TestProfilerErrors(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test")
+
+	// Simulate the hooks injected to functions whose last result is an error
+	nested := func(fail bool) (err error) {
+		prismPanicking := true
+		Enter("nested")
+		defer func() { LeaveWithError(prismPanicking, err) }()
+
+		if fail {
+			prismPanicking = false
+			return errors.New("nested failed")
+		}
+		prismPanicking = false
+		return nil
+	}
+
+	target := func() (err error) {
+		prismPanicking := true
+		BeginProfile("target")
+		defer func() { EndProfileWithError(prismPanicking, err) }()
+
+		for index := 0; index < 4; index++ {
+			nested(index%2 == 0)
+		}
+		prismPanicking = false
+		return errors.New("target failed")
+	}
+
+	if err := target(); err == nil {
+		t.Fatal("expected the target error to be returned")
+	}
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	targetMetrics := sink.buffer[0].Target
+	if targetMetrics.Errors != 1 || targetMetrics.ErrorRate != 1.0 {
+		t.Errorf("expected target error count and rate to be 1 and 1.0; got %d and %f", targetMetrics.Errors, targetMetrics.ErrorRate)
+	}
+
+	if len(targetMetrics.NestedCalls) != 1 {
+		t.Fatalf("expected target to capture 1 nested call group; got %d", len(targetMetrics.NestedCalls))
+	}
+
+	nestedMetrics := targetMetrics.NestedCalls[0]
+	if nestedMetrics.Errors != 2 || nestedMetrics.ErrorRate != 0.5 {
+		t.Errorf("expected nested call error count and rate to be 2 and 0.5; got %d and %f", nestedMetrics.Errors, nestedMetrics.ErrorRate)
+	}
+}
+
+func TestProfilerSampling(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", SampleEvery(2), MaxProfiles(2))
+
+	for index := 0; index < 10; index++ {
+		BeginProfile("target")
+		Enter("nested")
+		Leave()
+		EndProfile()
+	}
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expEntries := 2
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	for index, profile := range sink.buffer {
+		if len(profile.Target.NestedCalls) != 1 {
+			t.Errorf("[profile %d] expected target to capture 1 nested call; got %d", index, len(profile.Target.NestedCalls))
+		}
+	}
+
+	if sink.buffer[0].ID == sink.buffer[1].ID {
+		t.Errorf("expected each profile to be assigned a unique ID; got %d for both profiles", sink.buffer[0].ID)
+	}
+
+	if hasActiveCallStacks() {
+		t.Fatal("expected all call stacks to be inactive")
+	}
+}
+
+func TestProfilerNestedTargets(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", SampleEvery(2))
+
+	// Only the first outer invocation is sampled. The inner target calls
+	// made while the outer profile is active should not end it prematurely
+	// while the inner target calls made by the unsampled outer invocation
+	// should be profiled like any other invocation.
+	for index := 0; index < 2; index++ {
+		BeginProfile("outer")
+		Enter("outerNested")
+		for inner := 0; inner < 3; inner++ {
+			BeginProfile("inner")
+			Enter("innerNested")
+			Leave()
+			EndProfile()
+		}
+		Leave()
+		EndProfile()
+	}
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expTargets := []string{"outer", "inner", "inner"}
+	if len(sink.buffer) != len(expTargets) {
+		t.Fatalf("expected sink to capture %d entries; got %d", len(expTargets), len(sink.buffer))
+	}
+
+	for index, expTarget := range expTargets {
+		if sink.buffer[index].Target.FnName != expTarget {
+			t.Errorf("[profile %d] expected profile target to be %q; got %q", index, expTarget, sink.buffer[index].Target.FnName)
+		}
+	}
+
+	outer := sink.buffer[0].Target
+	if len(outer.NestedCalls) != 1 || len(outer.NestedCalls[0].NestedCalls) != 1 {
+		t.Fatalf("expected outer target to capture the outerNested and innerNested calls; got %+v", outer.NestedCalls)
+	}
+
+	innerNested := outer.NestedCalls[0].NestedCalls[0]
+	if innerNested.FnName != "innerNested" || innerNested.Invocations != 3 {
+		t.Errorf("expected outerNested to capture 3 invocations of innerNested; got %d invocations of %q", innerNested.Invocations, innerNested.FnName)
+	}
+
+	if hasActiveCallStacks() {
+		t.Fatal("expected all call stacks to be inactive")
+	}
+}
+
+var allocSink []*[1024]byte
+
+func TestProfilerTrackAllocs(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", TrackAllocs())
+
+	BeginProfile("target")
+	allocSink = append(make([]*[1024]byte, 0, 16), new([1024]byte))
+	Enter("nested")
+	for index := 0; index < 10; index++ {
+		allocSink = append(allocSink, new([1024]byte))
+	}
+	Leave()
+	EndProfile()
+
+	// Shutdown and flush sink
+	Shutdown()
+	allocSink = nil
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	target := sink.buffer[0].Target
+	if len(target.NestedCalls) != 1 {
+		t.Fatalf("expected target to capture 1 nested call; got %d", len(target.NestedCalls))
+	}
+
+	// The allocations performed by the profiler hooks should not be
+	// attributed to the profiled calls
+	nested := target.NestedCalls[0]
+	if nested.AllocObjects != 10 || nested.AllocBytes != 10*1024 {
+		t.Errorf("expected nested call to allocate 10 objects and %d bytes; got %d objects and %d bytes", 10*1024, nested.AllocObjects, nested.AllocBytes)
+	}
+
+	if target.AllocObjects != 12 || target.AllocBytes < 11*1024 {
+		t.Errorf("expected target to allocate 12 objects and at least %d bytes; got %d objects and %d bytes", 11*1024, target.AllocObjects, target.AllocBytes)
+	}
+}
+
+func TestProfilerTrackAllocsEvery(t *testing.T) {
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", TrackAllocsEvery(2))
+
+	for index := 0; index < 4; index++ {
+		BeginProfile("target")
+		Enter("nested")
+		allocSink = append(make([]*[1024]byte, 0, 1), new([1024]byte))
+		Leave()
+		EndProfile()
+	}
+
+	// Shutdown and flush sink
+	Shutdown()
+	allocSink = nil
+
+	expEntries := 4
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	// Only 1 in every 2 profiles should track allocations
+	for index, profile := range sink.buffer {
+		expTracked := index%2 == 0
+		if profile.AllocsTracked != expTracked {
+			t.Errorf("[profile %d] expected allocs tracked flag to be %t; got %t", index, expTracked, profile.AllocsTracked)
+		}
+
+		nested := profile.Target.NestedCalls[0]
+		if tracked := nested.AllocObjects != 0; tracked != expTracked {
+			t.Errorf("[profile %d] expected nested call allocations to be tracked: %t; got %d objects", index, expTracked, nested.AllocObjects)
+		}
+	}
+}
+
+func TestProfilerTrackCPUTime(t *testing.T) {
+	if threadCPUTime() == 0 {
+		t.Skip("CPU time tracking is not supported on this platform")
+	}
+
+	sink := newBufferedSink()
+	Init(sink, "profiler-test", TrackCPUTime())
+
+	BeginProfile("target")
+	Enter("spin")
+	for tick := time.Now(); time.Since(tick) < 20*time.Millisecond; {
+	}
+	Leave()
+	Enter("sleep")
+	<-time.After(20 * time.Millisecond)
+	Leave()
+	EndProfile()
+
+	// Shutdown and flush sink
+	Shutdown()
+
+	expEntries := 1
+	if len(sink.buffer) != expEntries {
+		t.Fatalf("expected sink to capture %d entries; got %d", expEntries, len(sink.buffer))
+	}
+
+	target := sink.buffer[0].Target
+	if len(target.NestedCalls) != 2 {
+		t.Fatalf("expected target to capture 2 nested calls; got %d", len(target.NestedCalls))
+	}
+
+	for _, metrics := range append(target.NestedCalls, target) {
+		if metrics.CPUTime+metrics.OffCPUTime != metrics.TotalTime {
+			t.Errorf("[%s] expected cpu time (%s) + off-cpu time (%s) to equal total time (%s)", metrics.FnName, metrics.CPUTime, metrics.OffCPUTime, metrics.TotalTime)
+		}
+	}
+
+	spin, sleep := target.NestedCalls[0], target.NestedCalls[1]
+	if spin.CPUTime < spin.TotalTime/2 {
+		t.Errorf("expected spin call to spend most of its time on the CPU; got cpu time %s, total time %s", spin.CPUTime, spin.TotalTime)
+	}
+	if sleep.OffCPUTime < sleep.TotalTime/2 {
+		t.Errorf("expected sleep call to spend most of its time off the CPU; got off-cpu time %s, total time %s", sleep.OffCPUTime, sleep.TotalTime)
+	}
+}
+
 type bufferedSink struct {
 	sigChan   chan struct{}
 	inputChan chan *Profile
@@ -121,3 +613,95 @@ func (s *bufferedSink) worker() {
 		s.buffer = append(s.buffer, profile)
 	}
 }
+
+// The number of Enter/Leave pairs recorded by each profile in the benchmarks.
+// Each profile is shipped once this limit is reached so the reported times
+// also include the amortized cost of generating the profiles.
+const benchCallsPerProfile = 1000
+
+func BenchmarkEnterLeave(b *testing.B) {
+	Init(newDiscardSink(), "profiler-bench")
+	defer Shutdown()
+
+	b.Run("single", func(b *testing.B) {
+		BeginProfile("target")
+		for i := 0; i < b.N; i++ {
+			Enter("nested")
+			Leave()
+
+			if (i+1)%benchCallsPerProfile == 0 {
+				EndProfile()
+				BeginProfile("target")
+			}
+		}
+		EndProfile()
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			BeginProfile("target")
+			for i := 1; pb.Next(); i++ {
+				Enter("nested")
+				Leave()
+
+				if i%benchCallsPerProfile == 0 {
+					EndProfile()
+					BeginProfile("target")
+				}
+			}
+			EndProfile()
+		})
+	})
+}
+
+func BenchmarkBeginEndProfile(b *testing.B) {
+	Init(newDiscardSink(), "profiler-bench")
+	defer Shutdown()
+
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			BeginProfile("target")
+			EndProfile()
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				BeginProfile("target")
+				EndProfile()
+			}
+		})
+	})
+}
+
+// discardSink drops any profiles piped into it.
+type discardSink struct {
+	inputChan chan *Profile
+	doneChan  chan struct{}
+}
+
+func newDiscardSink() *discardSink {
+	return &discardSink{}
+}
+
+func (s *discardSink) Open(bufferSize int) error {
+	s.inputChan = make(chan *Profile, bufferSize)
+	s.doneChan = make(chan struct{}, 0)
+	go func() {
+		for range s.inputChan {
+		}
+		close(s.doneChan)
+	}()
+	return nil
+}
+
+func (s *discardSink) Close() error {
+	close(s.inputChan)
+	<-s.doneChan
+	return nil
+}
+
+func (s *discardSink) Input() chan<- *Profile {
+	return s.inputChan
+}
@@ -0,0 +1,104 @@
+package profiler
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampler decides which invocations of each profile target should start a
+// new profile. Each profile target is sampled independently.
+type sampler struct {
+	// Profile 1 in every sampleEvery invocations of a target.
+	sampleEvery uint64
+
+	// The max number of profiles to capture per second for a target.
+	maxPerSecond int64
+
+	// The max number of profiles to capture in total for a target.
+	maxProfiles int64
+
+	// The sampling state for each profile target indexed by FQN.
+	targets sync.Map
+}
+
+// targetSamples tracks the sampling state of a profile target.
+type targetSamples struct {
+	// The number of times the target was invoked.
+	invocations uint64
+
+	// A mutex for protecting access to the profile counters.
+	mutex sync.Mutex
+
+	// The number of profiles captured for the target.
+	profiles int64
+
+	// The start of the current rate-limiting window as a unix timestamp and
+	// the number of profiles captured in it.
+	windowStart    int64
+	windowProfiles int64
+}
+
+// Create a new sampler using the sampling settings from opts. If no sampling
+// settings are specified, newSampler returns nil.
+func newSampler(opts options) *sampler {
+	if opts.sampleEvery <= 1 && opts.maxProfilesPerSecond <= 0 && opts.maxProfiles <= 0 {
+		return nil
+	}
+
+	s := &sampler{
+		sampleEvery:  1,
+		maxPerSecond: int64(opts.maxProfilesPerSecond),
+		maxProfiles:  int64(opts.maxProfiles),
+	}
+	if opts.sampleEvery > 1 {
+		s.sampleEvery = uint64(opts.sampleEvery)
+	}
+
+	return s
+}
+
+// Check whether the invocation of a profile target at the specified time
+// should be profiled. A nil sampler profiles all invocations.
+func (s *sampler) sample(target string, tick time.Time) bool {
+	if s == nil {
+		return true
+	}
+
+	state, found := s.targets.Load(target)
+	if !found {
+		state, _ = s.targets.LoadOrStore(target, &targetSamples{})
+	}
+	ts := state.(*targetSamples)
+
+	// Profile 1 in N invocations starting with the first invocation
+	if (atomic.AddUint64(&ts.invocations, 1)-1)%s.sampleEvery != 0 {
+		return false
+	}
+
+	if s.maxPerSecond <= 0 && s.maxProfiles <= 0 {
+		return true
+	}
+
+	// Enforce the profile limits
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if s.maxProfiles > 0 && ts.profiles >= s.maxProfiles {
+		return false
+	}
+
+	if s.maxPerSecond > 0 {
+		if now := tick.Unix(); ts.windowStart != now {
+			ts.windowStart = now
+			ts.windowProfiles = 0
+		}
+		if ts.windowProfiles >= s.maxPerSecond {
+			return false
+		}
+		ts.windowProfiles++
+	}
+
+	ts.profiles++
+	return true
+}
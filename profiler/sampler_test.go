@@ -0,0 +1,66 @@
+package profiler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	specs := []struct {
+		Opts       []Option
+		Ticks      []time.Duration
+		ExpSampled []bool
+	}{
+		// No sampling
+		{
+			nil,
+			[]time.Duration{0, 0, 0},
+			[]bool{true, true, true},
+		},
+		// 1 in 3 invocations
+		{
+			[]Option{SampleEvery(3)},
+			[]time.Duration{0, 0, 0, 0, 0, 0, 0},
+			[]bool{true, false, false, true, false, false, true},
+		},
+		// At most 2 profiles per second
+		{
+			[]Option{MaxProfilesPerSecond(2)},
+			[]time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, time.Second, 1500 * time.Millisecond, 1600 * time.Millisecond},
+			[]bool{true, true, false, true, true, false},
+		},
+		// At most 3 profiles in total
+		{
+			[]Option{MaxProfiles(3)},
+			[]time.Duration{0, 0, 0, 0, time.Second},
+			[]bool{true, true, true, false, false},
+		},
+		// Combined settings
+		{
+			[]Option{SampleEvery(2), MaxProfilesPerSecond(1), MaxProfiles(2)},
+			[]time.Duration{0, 0, 0, time.Second, 2 * time.Second, 2 * time.Second, 3 * time.Second},
+			[]bool{true, false, false, false, true, false, false},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		opts := options{}
+		for _, opt := range spec.Opts {
+			opt(&opts)
+		}
+		s := newSampler(opts)
+
+		for index, tick := range spec.Ticks {
+			if sampled := s.sample("target", start.Add(tick)); sampled != spec.ExpSampled[index] {
+				t.Errorf("[spec %d] expected invocation %d sampled flag to be %t; got %t", specIndex, index, spec.ExpSampled[index], sampled)
+			}
+		}
+
+		// Each target should be sampled independently
+		if len(spec.Ticks) != 0 && !s.sample("other-target", start) {
+			t.Errorf("[spec %d] expected first invocation of a different target to be sampled", specIndex)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package sink
+
+import (
+	"math"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+type aggregateSink struct {
+	wrapperSink
+	sink          profiler.Sink
+	flushInterval time.Duration
+
+	// The aggregated profiles indexed by target name and the order in
+	// which the targets were first seen. These fields are only accessed
+	// by the worker.
+	targets     map[string]*targetAggregate
+	targetOrder []string
+}
+
+// NewAggregateSink creates a new profile entry sink instance which merges
+// incoming profiles for the same target into a single aggregated profile and
+// forwards one aggregated profile per target to sink. The aggregated profiles
+// are forwarded every flushInterval and when the sink is closed; if
+// flushInterval is <= 0, they are only forwarded when the sink is closed.
+// Opening or closing the aggregate sink also opens or closes the sink that
+// it wraps.
+//
+// Calls are grouped using the same rules that the profiler applies when
+// grouping the calls of a single profile. The time percentiles of the
+// aggregated profiles are calculated from the merged time histograms of the
+// incoming profiles so they reflect every invocation. The allocation metrics
+// only reflect the profiles that tracked allocations. Allocation medians and
+// percentiles cannot be merged and are not populated.
+func NewAggregateSink(sink profiler.Sink, flushInterval time.Duration) profiler.Sink {
+	s := &aggregateSink{
+		sink:          sink,
+		flushInterval: flushInterval,
+		targets:       make(map[string]*targetAggregate),
+		targetOrder:   make([]string, 0),
+	}
+	s.wrapperSink = newWrapperSink(s.worker, sink)
+	return s
+}
+
+func (s *aggregateSink) worker(input <-chan *profiler.Profile) {
+	var flushChan <-chan time.Time
+	if s.flushInterval > 0 {
+		ticker := time.NewTicker(s.flushInterval)
+		defer ticker.Stop()
+		flushChan = ticker.C
+	}
+
+	for {
+		select {
+		case profile, sinkOpen := <-input:
+			if !sinkOpen {
+				s.flush()
+				return
+			}
+			s.merge(profile)
+		case <-flushChan:
+			s.flush()
+		}
+	}
+}
+
+// Merge a profile into the aggregated profile for its target.
+func (s *aggregateSink) merge(profile *profiler.Profile) {
+	if profile.Target == nil {
+		return
+	}
+
+	agg, exists := s.targets[profile.Target.FnName]
+	if !exists {
+		agg = &targetAggregate{
+			id:        profile.ID,
+			createdAt: profile.CreatedAt,
+			levels:    make([]*aggregateLevel, 0),
+		}
+		s.targets[profile.Target.FnName] = agg
+		s.targetOrder = append(s.targetOrder, profile.Target.FnName)
+	}
+
+	agg.label = profile.Label
+	agg.calibration = profile.Calibration
+	agg.allocsTracked = agg.allocsTracked || profile.AllocsTracked
+	agg.insert(0, -1, "", profile.Target, profile.AllocsTracked, make(map[int]map[string]bool))
+}
+
+// Forward the aggregated profile for each target to the wrapped sink and
+// reset the aggregation state.
+func (s *aggregateSink) flush() {
+	for _, target := range s.targetOrder {
+		s.sink.Input() <- s.targets[target].profile()
+	}
+
+	s.targets = make(map[string]*targetAggregate)
+	s.targetOrder = make([]string, 0)
+}
+
+// targetAggregate merges the CallMetrics trees of the profiles captured for a
+// particular target. Like the profiler's callGroupTree, it groups the calls
+// at each tree depth by the composite key (parent fn name, fn name) and links
+// groups at consecutive depths when a direct path exists between them.
+type targetAggregate struct {
+	id            uint64
+	createdAt     time.Time
+	label         string
+	calibration   *profiler.Calibration
+	allocsTracked bool
+	levels        []*aggregateLevel
+}
+
+type aggregateLevel struct {
+	keyToGroupIndex map[string]int
+	groups          []*aggregateGroup
+}
+
+// Insert a CallMetrics node and its nested calls into the aggregate. The
+// allocsTracked flag indicates whether the profile being inserted tracked
+// allocations and the seen map tracks the group keys that were already
+// merged at each depth for it.
+func (t *targetAggregate) insert(depth, parentGroupIndex int, parentFnName string, cm *profiler.CallMetrics, allocsTracked bool, seen map[int]map[string]bool) {
+	if len(t.levels) < depth+1 {
+		t.levels = append(t.levels, &aggregateLevel{
+			keyToGroupIndex: make(map[string]int),
+			groups:          make([]*aggregateGroup, 0),
+		})
+	}
+	if seen[depth] == nil {
+		seen[depth] = make(map[string]bool)
+	}
+
+	level := t.levels[depth]
+
+	// Construct composite grouping key
+	var groupKey string
+	if depth > 0 {
+		groupKey = parentFnName + ","
+	}
+	groupKey += cm.FnName
+
+	groupIndex, exists := level.keyToGroupIndex[groupKey]
+	if !exists {
+		groupIndex = len(level.groups)
+		level.groups = append(level.groups, newAggregateGroup(cm.FnName))
+		level.keyToGroupIndex[groupKey] = groupIndex
+	}
+
+	group := level.groups[groupIndex]
+	if parentGroupIndex >= 0 {
+		group.parentGroups[parentGroupIndex] = true
+	}
+
+	// The profiler emits a copy of a call group under each one of the
+	// groups that it is linked to. The copies share the same metrics so
+	// we only need to merge the first one.
+	if !seen[depth][groupKey] {
+		seen[depth][groupKey] = true
+		group.merge(cm, allocsTracked)
+	}
+
+	for _, nestedCall := range cm.NestedCalls {
+		t.insert(depth+1, groupIndex, cm.FnName, nestedCall, allocsTracked, seen)
+	}
+}
+
+// Generate a profile with the aggregated metrics.
+func (t *targetAggregate) profile() *profiler.Profile {
+	return &profiler.Profile{
+		ID:            t.id,
+		CreatedAt:     t.createdAt,
+		Label:         t.label,
+		Target:        t.groupMetrics(0, 0),
+		Calibration:   t.calibration,
+		AllocsTracked: t.allocsTracked,
+	}
+}
+
+// Run a DFS on the linked groups emitting a CallMetrics instance for each
+// group.
+func (t *targetAggregate) groupMetrics(depth, groupIndex int) *profiler.CallMetrics {
+	cm := t.levels[depth].groups[groupIndex].metrics()
+
+	if depth+1 < len(t.levels) {
+		for nestedGroupIndex, nestedGroup := range t.levels[depth+1].groups {
+			if nestedGroup.parentGroups[groupIndex] {
+				cm.NestedCalls = append(cm.NestedCalls, t.groupMetrics(depth+1, nestedGroupIndex))
+			}
+		}
+	}
+
+	return cm
+}
+
+// aggregateGroup accumulates the mergeable metrics for a group of calls.
+type aggregateGroup struct {
+	fnName       string
+	parentGroups map[int]bool
+
+	async       bool
+	invocations int
+	unfinished  int
+	panics      int
+	errors      int
+
+	totalTime, minTime, maxTime        time.Duration
+	selfTime, minSelfTime, maxSelfTime time.Duration
+	waitTime, cpuTime, offCPUTime      time.Duration
+
+	// The allocation metrics of the invocations that tracked allocations.
+	allocInvocations                               int
+	allocBytes, minAllocBytes, maxAllocBytes       uint64
+	allocObjects, minAllocObjects, maxAllocObjects uint64
+
+	// The merged histograms of the total and self time of each invocation.
+	timeHistogram, selfTimeHistogram *profiler.Histogram
+
+	// The sum of the squared total time of each invocation which allows
+	// us to calculate the stddev of the merged invocations.
+	sumSquares float64
+}
+
+func newAggregateGroup(fnName string) *aggregateGroup {
+	return &aggregateGroup{
+		fnName:            fnName,
+		parentGroups:      make(map[int]bool),
+		timeHistogram:     profiler.NewHistogram(),
+		selfTimeHistogram: profiler.NewHistogram(),
+	}
+}
+
+// Merge the metrics for a group of calls into the aggregated group metrics.
+// The allocation metrics are only merged if allocsTracked is set.
+func (g *aggregateGroup) merge(cm *profiler.CallMetrics, allocsTracked bool) {
+	if cm.Invocations <= 0 {
+		return
+	}
+
+	if g.invocations == 0 {
+		g.minTime, g.maxTime = cm.MinTime, cm.MaxTime
+		g.minSelfTime, g.maxSelfTime = cm.MinSelfTime, cm.MaxSelfTime
+	} else {
+		g.minTime, g.maxTime = minDuration(g.minTime, cm.MinTime), maxDuration(g.maxTime, cm.MaxTime)
+		g.minSelfTime, g.maxSelfTime = minDuration(g.minSelfTime, cm.MinSelfTime), maxDuration(g.maxSelfTime, cm.MaxSelfTime)
+	}
+
+	if allocsTracked {
+		if g.allocInvocations == 0 {
+			g.minAllocBytes, g.maxAllocBytes = cm.MinAllocBytes, cm.MaxAllocBytes
+			g.minAllocObjects, g.maxAllocObjects = cm.MinAllocObjects, cm.MaxAllocObjects
+		} else {
+			g.minAllocBytes, g.maxAllocBytes = minUint64(g.minAllocBytes, cm.MinAllocBytes), maxUint64(g.maxAllocBytes, cm.MaxAllocBytes)
+			g.minAllocObjects, g.maxAllocObjects = minUint64(g.minAllocObjects, cm.MinAllocObjects), maxUint64(g.maxAllocObjects, cm.MaxAllocObjects)
+		}
+
+		g.allocInvocations += cm.Invocations
+		g.allocBytes += cm.AllocBytes
+		g.allocObjects += cm.AllocObjects
+	}
+
+	g.async = g.async || cm.Async
+	g.invocations += cm.Invocations
+	g.unfinished += cm.Unfinished
+	g.panics += cm.Panics
+	g.errors += cm.Errors
+	g.totalTime += cm.TotalTime
+	g.selfTime += cm.SelfTime
+	g.waitTime += cm.WaitTime
+	g.cpuTime += cm.CPUTime
+	g.offCPUTime += cm.OffCPUTime
+
+	// Sum_i(total_i^2) = N * (stddev^2 + mean^2)
+	n := float64(cm.Invocations)
+	mean := float64(cm.TotalTime) / n
+	g.sumSquares += n * (cm.StdDev*cm.StdDev + mean*mean)
+
+	// Profiles that do not carry histograms (e.g. profiles assembled by
+	// hand) are approximated by recording the mean time
+	if cm.TimeHistogram != nil {
+		g.timeHistogram.Merge(cm.TimeHistogram)
+	} else {
+		g.timeHistogram.RecordN(cm.TotalTime/time.Duration(cm.Invocations), uint64(cm.Invocations))
+	}
+	if cm.SelfTimeHistogram != nil {
+		g.selfTimeHistogram.Merge(cm.SelfTimeHistogram)
+	} else {
+		g.selfTimeHistogram.RecordN(cm.SelfTime/time.Duration(cm.Invocations), uint64(cm.Invocations))
+	}
+}
+
+// Generate a CallMetrics instance from the aggregated group metrics.
+func (g *aggregateGroup) metrics() *profiler.CallMetrics {
+	cm := &profiler.CallMetrics{
+		FnName:      g.fnName,
+		NestedCalls: make([]*profiler.CallMetrics, 0),
+
+		Invocations: g.invocations,
+		Async:       g.async,
+		WaitTime:    g.waitTime,
+		Unfinished:  g.unfinished,
+		Panics:      g.panics,
+		Errors:      g.errors,
+
+		TotalTime:     g.totalTime,
+		MinTime:       g.minTime,
+		MaxTime:       g.maxTime,
+		MedianTime:    g.timeHistogram.Quantile(0.5),
+		P50Time:       g.timeHistogram.Quantile(0.5),
+		P75Time:       g.timeHistogram.Quantile(0.75),
+		P90Time:       g.timeHistogram.Quantile(0.90),
+		P99Time:       g.timeHistogram.Quantile(0.99),
+		TimeHistogram: g.timeHistogram,
+
+		SelfTime:          g.selfTime,
+		MinSelfTime:       g.minSelfTime,
+		MaxSelfTime:       g.maxSelfTime,
+		MedianSelfTime:    g.selfTimeHistogram.Quantile(0.5),
+		P50SelfTime:       g.selfTimeHistogram.Quantile(0.5),
+		P75SelfTime:       g.selfTimeHistogram.Quantile(0.75),
+		P90SelfTime:       g.selfTimeHistogram.Quantile(0.90),
+		P99SelfTime:       g.selfTimeHistogram.Quantile(0.99),
+		SelfTimeHistogram: g.selfTimeHistogram,
+
+		AllocBytes:      g.allocBytes,
+		MinAllocBytes:   g.minAllocBytes,
+		MaxAllocBytes:   g.maxAllocBytes,
+		AllocObjects:    g.allocObjects,
+		MinAllocObjects: g.minAllocObjects,
+		MaxAllocObjects: g.maxAllocObjects,
+
+		CPUTime:    g.cpuTime,
+		OffCPUTime: g.offCPUTime,
+	}
+
+	if g.invocations == 0 {
+		return cm
+	}
+
+	invocations := time.Duration(g.invocations)
+	cm.MeanTime = g.totalTime / invocations
+	cm.MeanSelfTime = g.selfTime / invocations
+	cm.MeanCPUTime = g.cpuTime / invocations
+	cm.MeanOffCPUTime = g.offCPUTime / invocations
+	if g.allocInvocations != 0 {
+		cm.MeanAllocBytes = g.allocBytes / uint64(g.allocInvocations)
+		cm.MeanAllocObjects = g.allocObjects / uint64(g.allocInvocations)
+	}
+	cm.ErrorRate = float64(g.errors) / float64(g.invocations)
+
+	// Var = Sum_i(total_i^2) / N - mean^2
+	mean := float64(g.totalTime) / float64(g.invocations)
+	if variance := g.sumSquares/float64(g.invocations) - mean*mean; variance > 0 {
+		cm.StdDev = math.Sqrt(variance)
+	}
+
+	return cm
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
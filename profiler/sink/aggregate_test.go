@@ -0,0 +1,247 @@
+package sink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestAggregateSink(t *testing.T) {
+	buffer := newBufferedSink()
+	s := NewAggregateSink(buffer, 0)
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Send 100 profiles for target A which calls B and 10 profiles for
+	// target C. Every 10th profile for A also calls D via B.
+	for i := 1; i <= 100; i++ {
+		b := mockCallMetrics("B", time.Duration(i)*time.Millisecond)
+		if i%10 == 0 {
+			b.NestedCalls = append(b.NestedCalls, mockCallMetrics("D", time.Millisecond))
+		}
+
+		a := mockCallMetrics("A", time.Duration(i+1)*time.Millisecond)
+		a.NestedCalls = append(a.NestedCalls, b)
+		s.Input() <- &profiler.Profile{ID: uint64(i), Label: "test", Target: a}
+
+		if i%10 == 0 {
+			s.Input() <- &profiler.Profile{ID: uint64(i), Label: "test", Target: mockCallMetrics("C", time.Second)}
+		}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !buffer.closed {
+		t.Error("expected aggregate sink to close the wrapped sink")
+	}
+	if len(buffer.buffer) != 2 {
+		t.Fatalf("expected wrapped sink to receive 2 profiles; got %d", len(buffer.buffer))
+	}
+
+	profile := buffer.buffer[0]
+	if profile.ID != 1 || profile.Label != "test" {
+		t.Errorf("expected aggregated profile to have ID 1 and label %q; got %d and %q", "test", profile.ID, profile.Label)
+	}
+
+	specs := []struct {
+		Metrics        *profiler.CallMetrics
+		ExpFnName      string
+		ExpInvocations int
+		ExpTotalTime   time.Duration
+		ExpMinTime     time.Duration
+		ExpMaxTime     time.Duration
+		ExpP90Time     time.Duration
+		ExpNestedCalls int
+	}{
+		{buffer.buffer[0].Target, "A", 100, 5150 * time.Millisecond, 2 * time.Millisecond, 101 * time.Millisecond, 91 * time.Millisecond, 1},
+		{buffer.buffer[0].Target.NestedCalls[0], "B", 100, 5050 * time.Millisecond, 1 * time.Millisecond, 100 * time.Millisecond, 90 * time.Millisecond, 1},
+		{buffer.buffer[0].Target.NestedCalls[0].NestedCalls[0], "D", 10, 10 * time.Millisecond, 1 * time.Millisecond, 1 * time.Millisecond, 1 * time.Millisecond, 0},
+		{buffer.buffer[1].Target, "C", 10, 10 * time.Second, time.Second, time.Second, time.Second, 0},
+	}
+
+	for specIndex, spec := range specs {
+		cm := spec.Metrics
+		if cm.FnName != spec.ExpFnName {
+			t.Errorf("[spec %d] expected fn name to be %q; got %q", specIndex, spec.ExpFnName, cm.FnName)
+		}
+		if cm.Invocations != spec.ExpInvocations {
+			t.Errorf("[spec %d] expected invocations to be %d; got %d", specIndex, spec.ExpInvocations, cm.Invocations)
+		}
+		if cm.TotalTime != spec.ExpTotalTime {
+			t.Errorf("[spec %d] expected total time to be %v; got %v", specIndex, spec.ExpTotalTime, cm.TotalTime)
+		}
+		if cm.MinTime != spec.ExpMinTime || cm.MaxTime != spec.ExpMaxTime {
+			t.Errorf("[spec %d] expected min/max time to be %v/%v; got %v/%v", specIndex, spec.ExpMinTime, spec.ExpMaxTime, cm.MinTime, cm.MaxTime)
+		}
+		if expMean := spec.ExpTotalTime / time.Duration(spec.ExpInvocations); cm.MeanTime != expMean {
+			t.Errorf("[spec %d] expected mean time to be %v; got %v", specIndex, expMean, cm.MeanTime)
+		}
+		if relErr := float64(cm.P90Time-spec.ExpP90Time) / float64(spec.ExpP90Time); relErr < -0.01 || relErr > 0.01 {
+			t.Errorf("[spec %d] expected p90 time to be within 1%% of %v; got %v", specIndex, spec.ExpP90Time, cm.P90Time)
+		}
+		if len(cm.NestedCalls) != spec.ExpNestedCalls {
+			t.Errorf("[spec %d] expected %d nested calls; got %d", specIndex, spec.ExpNestedCalls, len(cm.NestedCalls))
+		}
+	}
+
+	// The stddev of 2..101ms is the same as the stddev of 1..100ms
+	expStdDev := 28.86607 * float64(time.Millisecond)
+	if stdDev := buffer.buffer[0].Target.StdDev; stdDev < expStdDev*0.999 || stdDev > expStdDev*1.001 {
+		t.Errorf("expected stddev to be %v; got %v", expStdDev, stdDev)
+	}
+}
+
+func TestAggregateSinkSharedGroups(t *testing.T) {
+	buffer := newBufferedSink()
+	s := NewAggregateSink(buffer, 0)
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When A calls X->Z->C and Y->Z->C, the profiler groups both C calls
+	// together and emits a copy of the group under each Z call
+	for i := 0; i < 2; i++ {
+		c := mockCallMetrics("C", time.Millisecond)
+		c.Invocations = 2
+		c.TotalTime = 2 * time.Millisecond
+
+		x := mockCallMetrics("X", time.Millisecond)
+		x.NestedCalls = append(x.NestedCalls, mockCallMetrics("Z", time.Millisecond))
+		x.NestedCalls[0].NestedCalls = append(x.NestedCalls[0].NestedCalls, c)
+
+		y := mockCallMetrics("Y", time.Millisecond)
+		y.NestedCalls = append(y.NestedCalls, mockCallMetrics("Z", time.Millisecond))
+		y.NestedCalls[0].NestedCalls = append(y.NestedCalls[0].NestedCalls, c)
+
+		a := mockCallMetrics("A", 10*time.Millisecond)
+		a.NestedCalls = append(a.NestedCalls, x, y)
+		s.Input() <- &profiler.Profile{Target: a}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buffer.buffer) != 1 {
+		t.Fatalf("expected wrapped sink to receive 1 profile; got %d", len(buffer.buffer))
+	}
+
+	target := buffer.buffer[0].Target
+	for _, path := range [][]int{{0, 0, 0}, {1, 0, 0}} {
+		cm := target
+		for _, index := range path {
+			if index >= len(cm.NestedCalls) {
+				t.Fatalf("missing nested call at path %v", path)
+			}
+			cm = cm.NestedCalls[index]
+		}
+
+		if cm.FnName != "C" || cm.Invocations != 4 {
+			t.Errorf("expected call at path %v to be C with 4 invocations; got %s with %d invocations", path, cm.FnName, cm.Invocations)
+		}
+	}
+}
+
+func TestAggregateSinkFlushInterval(t *testing.T) {
+	buffer := newBufferedSink()
+	buffer.received = make(chan struct{}, 10)
+	s := NewAggregateSink(buffer, 10*time.Millisecond)
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Input() <- &profiler.Profile{Target: mockCallMetrics("A", time.Millisecond)}
+
+	// The aggregated profile should be forwarded without closing the sink
+	select {
+	case <-buffer.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sink to forward the aggregated profile")
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As the aggregation state is reset after each flush, closing the
+	// sink should not forward any further profiles
+	if len(buffer.buffer) != 1 {
+		t.Fatalf("expected wrapped sink to receive 1 profile; got %d", len(buffer.buffer))
+	}
+}
+
+func TestAggregateSinkAllocs(t *testing.T) {
+	buffer := newBufferedSink()
+	s := NewAggregateSink(buffer, 0)
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only every other profile tracks allocations; the remaining profiles
+	// should not affect the aggregated allocation metrics
+	for i := 1; i <= 4; i++ {
+		cm := mockCallMetrics("A", time.Millisecond)
+		allocsTracked := i%2 == 0
+		if allocsTracked {
+			cm.AllocBytes, cm.MinAllocBytes, cm.MaxAllocBytes = uint64(i*100), uint64(i*100), uint64(i*100)
+			cm.AllocObjects, cm.MinAllocObjects, cm.MaxAllocObjects = uint64(i), uint64(i), uint64(i)
+		}
+		s.Input() <- &profiler.Profile{Target: cm, AllocsTracked: allocsTracked}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(buffer.buffer) != 1 {
+		t.Fatalf("expected wrapped sink to receive 1 profile; got %d", len(buffer.buffer))
+	}
+
+	profile := buffer.buffer[0]
+	if !profile.AllocsTracked {
+		t.Error("expected aggregated profile to be marked as tracking allocations")
+	}
+
+	cm := profile.Target
+	if cm.Invocations != 4 {
+		t.Errorf("expected invocations to be 4; got %d", cm.Invocations)
+	}
+	if cm.AllocBytes != 600 || cm.MinAllocBytes != 200 || cm.MaxAllocBytes != 400 || cm.MeanAllocBytes != 300 {
+		t.Errorf("expected total/min/max/mean alloc bytes to be 600/200/400/300; got %d/%d/%d/%d", cm.AllocBytes, cm.MinAllocBytes, cm.MaxAllocBytes, cm.MeanAllocBytes)
+	}
+	if cm.AllocObjects != 6 || cm.MinAllocObjects != 2 || cm.MaxAllocObjects != 4 || cm.MeanAllocObjects != 3 {
+		t.Errorf("expected total/min/max/mean alloc objects to be 6/2/4/3; got %d/%d/%d/%d", cm.AllocObjects, cm.MinAllocObjects, cm.MaxAllocObjects, cm.MeanAllocObjects)
+	}
+}
+
+// Create the CallMetrics for a single invocation of fnName.
+func mockCallMetrics(fnName string, totalTime time.Duration) *profiler.CallMetrics {
+	histogram := profiler.NewHistogram()
+	histogram.Record(totalTime)
+
+	return &profiler.CallMetrics{
+		FnName:            fnName,
+		Invocations:       1,
+		TotalTime:         totalTime,
+		MinTime:           totalTime,
+		MaxTime:           totalTime,
+		SelfTime:          totalTime,
+		MinSelfTime:       totalTime,
+		MaxSelfTime:       totalTime,
+		TimeHistogram:     histogram,
+		SelfTimeHistogram: histogram,
+		NestedCalls:       make([]*profiler.CallMetrics, 0),
+	}
+}
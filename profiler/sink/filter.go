@@ -0,0 +1,45 @@
+package sink
+
+import (
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+// A FilterFunc returns true if a profile should be forwarded to the sink
+// wrapped by a filter sink.
+type FilterFunc func(*profiler.Profile) bool
+
+type filterSink struct {
+	wrapperSink
+	sink      profiler.Sink
+	predicate FilterFunc
+}
+
+// NewFilterSink creates a new profile entry sink instance which forwards
+// incoming profiles to sink only if predicate returns true for them. Opening
+// or closing the filter sink also opens or closes the sink that it wraps.
+func NewFilterSink(sink profiler.Sink, predicate FilterFunc) profiler.Sink {
+	s := &filterSink{
+		sink:      sink,
+		predicate: predicate,
+	}
+	s.wrapperSink = newWrapperSink(s.worker, sink)
+	return s
+}
+
+// SlowerThan returns a FilterFunc that matches profiles whose root call took
+// more than threshold to complete.
+func SlowerThan(threshold time.Duration) FilterFunc {
+	return func(profile *profiler.Profile) bool {
+		return profile.Target != nil && profile.Target.TotalTime > threshold
+	}
+}
+
+func (s *filterSink) worker(input <-chan *profiler.Profile) {
+	for profile := range input {
+		if s.predicate(profile) {
+			s.sink.Input() <- profile
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package sink
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestFilterSink(t *testing.T) {
+	buffer := newBufferedSink()
+	s := NewFilterSink(buffer, SlowerThan(10*time.Millisecond))
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totalTimes := []time.Duration{
+		5 * time.Millisecond,
+		20 * time.Millisecond,
+		10 * time.Millisecond,
+		11 * time.Millisecond,
+	}
+	for index, totalTime := range totalTimes {
+		s.Input() <- &profiler.Profile{
+			ID: uint64(index),
+			Target: &profiler.CallMetrics{
+				FnName:    fmt.Sprintf("foo.%d", index),
+				TotalTime: totalTime,
+			},
+		}
+	}
+	// Profiles without a root call never match the predicate
+	s.Input() <- &profiler.Profile{}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !buffer.closed {
+		t.Error("expected filter sink to close the wrapped sink")
+	}
+
+	expIDs := []uint64{1, 3}
+	if len(buffer.buffer) != len(expIDs) {
+		t.Fatalf("expected wrapped sink to receive %d profiles; got %d", len(expIDs), len(buffer.buffer))
+	}
+	for index, expID := range expIDs {
+		if id := buffer.buffer[index].ID; id != expID {
+			t.Errorf("expected profile %d to have ID %d; got %d", index, expID, id)
+		}
+	}
+}
+
+// bufferedSink is a sink that records the profiles sent to it. If the received
+// channel is set, the sink also signals it for each received profile.
+type bufferedSink struct {
+	sigChan   chan struct{}
+	inputChan chan *profiler.Profile
+	buffer    []*profiler.Profile
+	received  chan struct{}
+	closed    bool
+}
+
+func newBufferedSink() *bufferedSink {
+	return &bufferedSink{
+		sigChan: make(chan struct{}, 0),
+	}
+}
+
+func (s *bufferedSink) Open(inputBufferSize int) error {
+	s.inputChan = make(chan *profiler.Profile, inputBufferSize)
+	go s.worker()
+	<-s.sigChan
+	return nil
+}
+
+func (s *bufferedSink) Close() error {
+	close(s.inputChan)
+	<-s.sigChan
+	close(s.sigChan)
+	s.closed = true
+	return nil
+}
+
+func (s *bufferedSink) Input() chan<- *profiler.Profile {
+	return s.inputChan
+}
+
+func (s *bufferedSink) worker() {
+	s.sigChan <- struct{}{}
+	defer func() {
+		s.sigChan <- struct{}{}
+	}()
+
+	for profile := range s.inputChan {
+		s.buffer = append(s.buffer, profile)
+		if s.received != nil {
+			s.received <- struct{}{}
+		}
+	}
+}
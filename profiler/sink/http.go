@@ -0,0 +1,292 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+// The payload encodings supported by the http sink.
+const (
+	// Post each batch of profiles as a JSON array.
+	EncodingJSON = "json"
+
+	// Post each batch of profiles as newline-delimited JSON.
+	EncodingNDJSON = "ndjson"
+)
+
+const (
+	defaultHTTPBatchSize         = 50
+	defaultHTTPFlushInterval     = 1 * time.Second
+	defaultHTTPMaxPendingBatches = 20
+	defaultHTTPMaxRetries        = 3
+	defaultHTTPInitialBackoff    = 100 * time.Millisecond
+	defaultHTTPTimeout           = 10 * time.Second
+)
+
+// HTTPSinkOptions defines the settings for an http sink. Any settings left
+// unspecified use the default values listed below.
+type HTTPSinkOptions struct {
+	// The payload encoding; one of EncodingJSON (default) or EncodingNDJSON.
+	Encoding string
+
+	// The max number of profiles posted by each request. Defaults to 50.
+	BatchSize int
+
+	// The max time that a profile may be buffered before the sink posts a
+	// partially filled batch. Defaults to 1s.
+	FlushInterval time.Duration
+
+	// The max number of batches waiting to be posted. If the collector
+	// cannot keep up, any further batches are dropped. Defaults to 20.
+	MaxPendingBatches int
+
+	// The max number of times that a failed request is retried. Requests
+	// are retried if they fail due to a network error or if the collector
+	// responds with a 429 or a 5xx status code. Defaults to 3; a negative
+	// value disables retries.
+	MaxRetries int
+
+	// The delay before retrying a failed request. The delay is doubled
+	// after each failed attempt. Defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// The timeout for each request. Defaults to 10s. It is ignored if a
+	// custom Client is specified.
+	Timeout time.Duration
+
+	// Additional headers to include with each request (e.g. authorization
+	// headers).
+	Headers map[string]string
+
+	// A custom http client for posting the profiles.
+	Client *http.Client
+}
+
+type httpSink struct {
+	endpoint  string
+	redacted  string
+	opts      HTTPSinkOptions
+	sigChan   chan struct{}
+	inputChan chan *profiler.Profile
+	batchChan chan []*profiler.Profile
+}
+
+// NewHTTPSink creates a new profile entry sink instance which posts batches of
+// profiles to the collector endpoint specified by endpointURL.
+func NewHTTPSink(endpointURL string, opts HTTPSinkOptions) profiler.Sink {
+	if opts.Encoding == "" {
+		opts.Encoding = EncodingJSON
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultHTTPBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultHTTPFlushInterval
+	}
+	if opts.MaxPendingBatches <= 0 {
+		opts.MaxPendingBatches = defaultHTTPMaxPendingBatches
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultHTTPMaxRetries
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = defaultHTTPInitialBackoff
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHTTPTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: opts.Timeout}
+	}
+
+	return &httpSink{
+		endpoint: endpointURL,
+		opts:     opts,
+		sigChan:  make(chan struct{}, 0),
+	}
+}
+
+// Initialize the sink.
+func (s *httpSink) Open(inputBufferSize int) error {
+	endpoint, err := url.Parse(s.endpoint)
+	if err != nil {
+		return err
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("unsupported collector endpoint scheme %q", endpoint.Scheme)
+	}
+	if s.opts.Encoding != EncodingJSON && s.opts.Encoding != EncodingNDJSON {
+		return fmt.Errorf("unsupported payload encoding %q", s.opts.Encoding)
+	}
+	s.redacted = endpoint.Redacted()
+	fmt.Fprintf(os.Stderr, "profiler: posting profiles to %s\n", s.redacted)
+
+	s.inputChan = make(chan *profiler.Profile, inputBufferSize)
+	s.batchChan = make(chan []*profiler.Profile, s.opts.MaxPendingBatches)
+
+	// start workers and wait for ready signal
+	go s.worker()
+	<-s.sigChan
+	return nil
+}
+
+// Shutdown the sink. Any buffered profiles are posted before Close returns.
+func (s *httpSink) Close() error {
+	// Signal worker to exit and wait for confirmation
+	close(s.inputChan)
+	<-s.sigChan
+	close(s.sigChan)
+	return nil
+}
+
+// Get a channel for piping profile entries to the sink.
+func (s *httpSink) Input() chan<- *profiler.Profile {
+	return s.inputChan
+}
+
+// The worker batches incoming profiles and hands them off to a dedicated
+// goroutine for posting so that a slow collector never blocks the profiler.
+func (s *httpSink) worker() {
+	senderDone := make(chan struct{}, 0)
+	go func() {
+		defer close(senderDone)
+		for batch := range s.batchChan {
+			s.post(batch)
+		}
+	}()
+
+	// Signal that worker has started
+	s.sigChan <- struct{}{}
+	defer func() {
+		// Wait for the sender to post any pending batches and signal
+		// that we have stopped
+		close(s.batchChan)
+		<-senderDone
+		s.sigChan <- struct{}{}
+	}()
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*profiler.Profile, 0, s.opts.BatchSize)
+	for {
+		select {
+		case profile, sinkOpen := <-s.inputChan:
+			if !sinkOpen {
+				// Flush the last batch; as the sink is shutting down we
+				// wait for room in the pending batch queue
+				if len(batch) != 0 {
+					s.batchChan <- batch
+				}
+				return
+			}
+
+			batch = append(batch, profile)
+			if len(batch) == s.opts.BatchSize {
+				s.enqueue(batch)
+				batch = make([]*profiler.Profile, 0, s.opts.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) != 0 {
+				s.enqueue(batch)
+				batch = make([]*profiler.Profile, 0, s.opts.BatchSize)
+			}
+		}
+	}
+}
+
+// Append a batch to the pending batch queue or drop it if the queue is full.
+func (s *httpSink) enqueue(batch []*profiler.Profile) {
+	select {
+	case s.batchChan <- batch:
+	default:
+		fmt.Fprintf(os.Stderr, "profiler: too many pending requests to %s; dropping %d profiles\n", s.redacted, len(batch))
+	}
+}
+
+// Post a batch of profiles to the collector endpoint retrying failed requests
+// with an exponential backoff.
+func (s *httpSink) post(batch []*profiler.Profile) {
+	payload, contentType, err := encodeBatch(batch, s.opts.Encoding)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "profiler: error marshalling profiles: %s; dropping %d profiles\n", err.Error(), len(batch))
+		return
+	}
+
+	backoff := s.opts.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.send(payload, contentType)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt == s.opts.MaxRetries {
+			fmt.Fprintf(os.Stderr, "profiler: error posting profiles to %s: %s; dropping %d profiles\n", s.redacted, err.Error(), len(batch))
+			return
+		}
+
+		<-time.After(backoff)
+		backoff *= 2
+	}
+}
+
+// Send a single request to the collector endpoint. If the request fails, send
+// returns an error and a flag indicating whether the request can be retried.
+func (s *httpSink) send(payload []byte, contentType string) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range s.opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	res, err := s.opts.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	// Drain the response body so the connection can be reused
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("collector responded with status %d", res.StatusCode)
+	default:
+		return false, fmt.Errorf("collector responded with status %d", res.StatusCode)
+	}
+}
+
+// Encode a batch of profiles using the specified encoding and return the
+// payload and its content type.
+func encodeBatch(batch []*profiler.Profile, encoding string) ([]byte, string, error) {
+	if encoding == EncodingJSON {
+		data, err := json.Marshal(batch)
+		return data, "application/json", err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, profile := range batch {
+		// Encode terminates each value with a newline
+		if err := enc.Encode(profile); err != nil {
+			return nil, "", err
+		}
+	}
+	return buf.Bytes(), "application/x-ndjson", nil
+}
@@ -0,0 +1,231 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestHTTPSink(t *testing.T) {
+	specs := []struct {
+		Encoding       string
+		ExpContentType string
+	}{
+		{EncodingJSON, "application/json"},
+		{EncodingNDJSON, "application/x-ndjson"},
+	}
+
+	for specIndex, spec := range specs {
+		collector := newMockCollector(t)
+
+		s := NewHTTPSink(collector.URL, HTTPSinkOptions{
+			Encoding:  spec.Encoding,
+			BatchSize: 3,
+			Headers:   map[string]string{"Authorization": "Bearer token"},
+		})
+		err := s.Open(0)
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		numEntries := 7
+		for i := 0; i < numEntries; i++ {
+			s.Input() <- &profiler.Profile{
+				Label: "test",
+				Target: &profiler.CallMetrics{
+					FnName: fmt.Sprintf("foo.%d", i),
+				},
+			}
+		}
+
+		// Close should flush the last partially filled batch
+		err = s.Close()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+		collector.Close()
+
+		if len(collector.profiles) != numEntries {
+			t.Errorf("[spec %d] expected collector to receive %d profiles; got %d", specIndex, numEntries, len(collector.profiles))
+			continue
+		}
+		for index, profile := range collector.profiles {
+			expFnName := fmt.Sprintf("foo.%d", index)
+			if profile.Target.FnName != expFnName {
+				t.Errorf("[spec %d] expected profile %d target to be %q; got %q", specIndex, index, expFnName, profile.Target.FnName)
+			}
+		}
+
+		expRequests := 3
+		if len(collector.requests) != expRequests {
+			t.Errorf("[spec %d] expected collector to receive %d requests; got %d", specIndex, expRequests, len(collector.requests))
+		}
+		for _, req := range collector.requests {
+			if contentType := req.Header.Get("Content-Type"); contentType != spec.ExpContentType {
+				t.Errorf("[spec %d] expected request content type to be %q; got %q", specIndex, spec.ExpContentType, contentType)
+			}
+			if auth := req.Header.Get("Authorization"); auth != "Bearer token" {
+				t.Errorf("[spec %d] expected request to include the authorization header; got %q", specIndex, auth)
+			}
+		}
+	}
+}
+
+func TestHTTPSinkRetries(t *testing.T) {
+	specs := []struct {
+		FailStatus  int
+		Failures    int
+		ExpRequests int
+		ExpProfiles int
+	}{
+		// Server errors are retried
+		{http.StatusServiceUnavailable, 2, 3, 1},
+		{http.StatusTooManyRequests, 1, 2, 1},
+		// Give up after 1 + MaxRetries attempts
+		{http.StatusInternalServerError, 10, 4, 0},
+		// Client errors are not retried
+		{http.StatusBadRequest, 1, 1, 0},
+	}
+
+	for specIndex, spec := range specs {
+		collector := newMockCollector(t)
+		collector.failStatus = spec.FailStatus
+		collector.failures = spec.Failures
+
+		s := NewHTTPSink(collector.URL, HTTPSinkOptions{
+			MaxRetries:     3,
+			InitialBackoff: time.Millisecond,
+		})
+		err := s.Open(0)
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		s.Input() <- &profiler.Profile{
+			Target: &profiler.CallMetrics{FnName: "foo"},
+		}
+
+		err = s.Close()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+		collector.Close()
+
+		if len(collector.requests) != spec.ExpRequests {
+			t.Errorf("[spec %d] expected collector to receive %d requests; got %d", specIndex, spec.ExpRequests, len(collector.requests))
+		}
+		if len(collector.profiles) != spec.ExpProfiles {
+			t.Errorf("[spec %d] expected collector to accept %d profiles; got %d", specIndex, spec.ExpProfiles, len(collector.profiles))
+		}
+	}
+}
+
+func TestHTTPSinkFlushInterval(t *testing.T) {
+	collector := newMockCollector(t)
+	defer collector.Close()
+
+	s := NewHTTPSink(collector.URL, HTTPSinkOptions{
+		FlushInterval: 10 * time.Millisecond,
+	})
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Input() <- &profiler.Profile{
+		Target: &profiler.CallMetrics{FnName: "foo"},
+	}
+
+	// The partially filled batch should be posted without closing the sink
+	select {
+	case <-collector.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the sink to post the buffered profile")
+	}
+}
+
+func TestHTTPSinkOpenErrors(t *testing.T) {
+	specs := []struct {
+		URL      string
+		Encoding string
+		ExpError string
+	}{
+		{"ftp://localhost/profiles", "", `unsupported collector endpoint scheme "ftp"`},
+		{"http://localhost/profiles", "xml", `unsupported payload encoding "xml"`},
+	}
+
+	for specIndex, spec := range specs {
+		s := NewHTTPSink(spec.URL, HTTPSinkOptions{Encoding: spec.Encoding})
+		err := s.Open(0)
+		if err == nil || err.Error() != spec.ExpError {
+			t.Errorf("[spec %d] expected error %q; got %v", specIndex, spec.ExpError, err)
+		}
+	}
+}
+
+// mockCollector is an http server that records the requests and profiles
+// posted to it. It can be configured to fail a number of requests.
+type mockCollector struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	failStatus int
+	failures   int
+	requests   []*http.Request
+	profiles   []*profiler.Profile
+	received   chan struct{}
+}
+
+func newMockCollector(t *testing.T) *mockCollector {
+	c := &mockCollector{
+		received: make(chan struct{}, 100),
+	}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		c.requests = append(c.requests, r)
+		if c.failures > 0 {
+			c.failures--
+			w.WriteHeader(c.failStatus)
+			return
+		}
+
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading request body: %v", err)
+			return
+		}
+
+		var profiles []*profiler.Profile
+		if r.Header.Get("Content-Type") == "application/x-ndjson" {
+			scanner := bufio.NewScanner(strings.NewReader(string(data)))
+			for scanner.Scan() {
+				var profile *profiler.Profile
+				if err = json.Unmarshal(scanner.Bytes(), &profile); err != nil {
+					t.Errorf("error decoding ndjson line %q: %v", scanner.Text(), err)
+					return
+				}
+				profiles = append(profiles, profile)
+			}
+		} else if err = json.Unmarshal(data, &profiles); err != nil {
+			t.Errorf("error decoding json payload: %v", err)
+			return
+		}
+
+		c.profiles = append(c.profiles, profiles...)
+		c.received <- struct{}{}
+	}))
+
+	return c
+}
@@ -0,0 +1,25 @@
+package sink
+
+import "github.com/geckoboard/prism/profiler"
+
+type multiSink struct {
+	wrapperSink
+}
+
+// NewMultiSink creates a new profile entry sink instance which sends each
+// incoming profile to each one of the specified sinks. Opening or closing
+// the multi sink also opens or closes the sinks that it wraps; the first
+// error reported by the wrapped sinks when closing them is returned.
+func NewMultiSink(sinks ...profiler.Sink) profiler.Sink {
+	s := &multiSink{}
+	s.wrapperSink = newWrapperSink(s.worker, sinks...)
+	return s
+}
+
+func (s *multiSink) worker(input <-chan *profiler.Profile) {
+	for profile := range input {
+		for _, sink := range s.sinks {
+			sink.Input() <- profile
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestMultiSink(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	discard := NewDiscardSink()
+	s := NewMultiSink(NewFileSink(tmpDir), discard)
+	err = s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numEntries := 5
+	for i := 0; i < numEntries; i++ {
+		s.Input() <- &profiler.Profile{
+			ID:     uint64(i),
+			Target: &profiler.CallMetrics{FnName: "foo"},
+		}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fileList, err := filepath.Glob(tmpDir + "/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fileList) != numEntries {
+		t.Errorf("expected number of written files to be %d; got %d", numEntries, len(fileList))
+	}
+
+	if numDiscarded := discard.(*discardSink).numDiscarded; numDiscarded != numEntries {
+		t.Errorf("expected discarded entry count to be %d; got %d", numEntries, numDiscarded)
+	}
+}
@@ -0,0 +1,244 @@
+package sink
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+// SinkEnvVar is the name of the env var that can be used for overriding the
+// sink URI compiled into profiled binaries (see MustOpenEnv).
+const SinkEnvVar = "PRISM_SINK"
+
+// The prefixes for multi and aggregate sink URIs.
+const (
+	multiSinkPrefix     = "multi:"
+	aggregateSinkPrefix = "aggregate:"
+)
+
+// A factory for creating a sink from a parsed sink URI.
+type sinkFactory func(uri *url.URL) (profiler.Sink, error)
+
+// The registered sink factories indexed by URI scheme.
+var sinkFactories = map[string]sinkFactory{
+	"file":    openFileSink,
+	"http":    openHTTPSink,
+	"https":   openHTTPSink,
+	"unix":    openUnixSink,
+	"discard": openDiscardSink,
+}
+
+// Open creates the sink described by a sink URI. The URI scheme selects the
+// type of sink:
+//   - file:///path/to/dir stores profiles in a local folder. URIs without
+//     a scheme are also treated as folder paths.
+//   - http://host/path and https://host/path post profiles to a collector
+//     endpoint using the default HTTPSinkOptions.
+//   - unix:///path/to/socket streams profiles to a unix socket.
+//   - discard: discards all profiles.
+//   - multi:uri1,uri2,... sends profiles to each one of the comma-delimited
+//     sink URIs. URIs that contain commas (e.g. in their query or a nested
+//     multi URI) must be enclosed in parentheses; e.g.
+//     multi:(http://host/path?tags=a,b),(aggregate:multi:uri3,uri4).
+//   - aggregate:uri or aggregate:interval:uri merges the profiles for each
+//     target and sends the aggregated profiles to the sink URI when the sink
+//     is closed and, if specified, every interval (e.g. aggregate:1m:uri).
+func Open(uri string) (profiler.Sink, error) {
+	if strings.HasPrefix(uri, multiSinkPrefix) {
+		return openMultiSink(strings.TrimPrefix(uri, multiSinkPrefix))
+	}
+	if strings.HasPrefix(uri, aggregateSinkPrefix) {
+		return openAggregateSink(strings.TrimPrefix(uri, aggregateSinkPrefix))
+	}
+
+	parsedURI, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink URI %q: %s", uri, err)
+	}
+
+	// Treat URIs without a scheme (or a windows drive letter) as paths
+	if len(parsedURI.Scheme) <= 1 {
+		if uri == "" {
+			return nil, fmt.Errorf("empty sink URI")
+		}
+		return NewFileSink(uri), nil
+	}
+
+	factory, found := sinkFactories[parsedURI.Scheme]
+	if !found {
+		return nil, fmt.Errorf("unsupported sink URI scheme %q", parsedURI.Scheme)
+	}
+	return factory(parsedURI)
+}
+
+// MustOpenEnv creates the sink described by the URI in the PRISM_SINK env var
+// or, if the env var is not set, by defaultURI. It panics if the URI is not
+// valid. This allows the profile output of instrumented binaries to be
+// redirected without rebuilding them.
+func MustOpenEnv(defaultURI string) profiler.Sink {
+	uri := defaultURI
+	if envURI := os.Getenv(SinkEnvVar); envURI != "" {
+		uri = envURI
+	}
+
+	s, err := Open(uri)
+	if err != nil {
+		panic(fmt.Errorf("profiler: %s", err))
+	}
+	return s
+}
+
+// Get the path component of a file or unix URI. Relative paths are parsed
+// as opaque URIs (e.g. file:profiles).
+func uriPath(uri *url.URL) (string, error) {
+	path := uri.Path
+	if path == "" {
+		path = uri.Opaque
+	}
+	if path == "" {
+		return "", fmt.Errorf("missing path in %s sink URI", uri.Scheme)
+	}
+	if uri.Host != "" && uri.Host != "localhost" {
+		return "", fmt.Errorf("unsupported host %q in %s sink URI", uri.Host, uri.Scheme)
+	}
+	return path, nil
+}
+
+func openFileSink(uri *url.URL) (profiler.Sink, error) {
+	path, err := uriPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileSink(path), nil
+}
+
+func openHTTPSink(uri *url.URL) (profiler.Sink, error) {
+	if uri.Host == "" {
+		return nil, fmt.Errorf("missing host in %s sink URI", uri.Scheme)
+	}
+	return NewHTTPSink(uri.String(), HTTPSinkOptions{}), nil
+}
+
+func openUnixSink(uri *url.URL) (profiler.Sink, error) {
+	path, err := uriPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewUnixSink(path), nil
+}
+
+func openDiscardSink(_ *url.URL) (profiler.Sink, error) {
+	return NewDiscardSink(), nil
+}
+
+func openMultiSink(uriList string) (profiler.Sink, error) {
+	if strings.TrimSpace(uriList) == "" {
+		return nil, fmt.Errorf("multi sink URI does not specify any sinks")
+	}
+
+	uris, err := splitMultiSinkURI(uriList)
+	if err != nil {
+		return nil, err
+	}
+
+	sinks := make([]profiler.Sink, 0)
+	for _, uri := range uris {
+		s, err := Open(uri)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	return NewMultiSink(sinks...), nil
+}
+
+// Split the URI list of a multi sink URI at the commas that are not enclosed
+// in parentheses. The parentheses enclosing an entire URI are removed.
+func splitMultiSinkURI(uriList string) ([]string, error) {
+	parts := make([]string, 0)
+	addPart := func(part string) error {
+		part = strings.TrimSpace(part)
+		if isParenthesized(part) {
+			part = strings.TrimSpace(part[1 : len(part)-1])
+		}
+		if part == "" {
+			return fmt.Errorf("empty sink URI in multi sink URI list %q", uriList)
+		}
+		parts = append(parts, part)
+		return nil
+	}
+
+	depth, start := 0, 0
+	for index := 0; index < len(uriList); index++ {
+		switch uriList[index] {
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("unbalanced parentheses in multi sink URI list %q", uriList)
+			}
+		case ',':
+			if depth == 0 {
+				if err := addPart(uriList[start:index]); err != nil {
+					return nil, err
+				}
+				start = index + 1
+			}
+		}
+	}
+
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses in multi sink URI list %q", uriList)
+	}
+	if err := addPart(uriList[start:]); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// Check whether a string is enclosed in a matching pair of parentheses.
+func isParenthesized(s string) bool {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return false
+	}
+
+	depth := 0
+	for index := 0; index < len(s)-1; index++ {
+		switch s[index] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		if depth == 0 {
+			// The opening parenthesis is closed before the end
+			return false
+		}
+	}
+	return true
+}
+
+func openAggregateSink(uri string) (profiler.Sink, error) {
+	// Check for an optional flush interval prefix
+	var flushInterval time.Duration
+	if sepIndex := strings.Index(uri, ":"); sepIndex != -1 {
+		if interval, err := time.ParseDuration(uri[:sepIndex]); err == nil {
+			if interval <= 0 {
+				return nil, fmt.Errorf("invalid aggregate sink flush interval %q", uri[:sepIndex])
+			}
+			flushInterval = interval
+			uri = uri[sepIndex+1:]
+		}
+	}
+
+	s, err := Open(uri)
+	if err != nil {
+		return nil, err
+	}
+	return NewAggregateSink(s, flushInterval), nil
+}
@@ -0,0 +1,109 @@
+package sink
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestOpen(t *testing.T) {
+	specs := []struct {
+		URI      string
+		ExpSink  string
+		ExpError string
+	}{
+		{"file:///tmp/prism", "file(/tmp/prism)", ""},
+		{"file:///tmp/prism%20profiles", "file(/tmp/prism profiles)", ""},
+		{"file:profiles", "file(profiles)", ""},
+		{"/tmp/prism", "file(/tmp/prism)", ""},
+		{"http://localhost:8080/profiles", "http(http://localhost:8080/profiles)", ""},
+		{"https://collector/profiles?token=1", "http(https://collector/profiles?token=1)", ""},
+		{"unix:///var/run/prism.sock", "unix(/var/run/prism.sock)", ""},
+		{"discard:", "discard()", ""},
+		{"multi:file:///tmp/prism, discard:", "multi(file(/tmp/prism), discard())", ""},
+		{"aggregate:file:///tmp/prism", "aggregate(0s, file(/tmp/prism))", ""},
+		{"aggregate:1m:multi:/tmp/prism,discard:", "aggregate(1m0s, multi(file(/tmp/prism), discard()))", ""},
+		{"multi:(https://collector/profiles?tags=a,b),discard:", "multi(http(https://collector/profiles?tags=a,b), discard())", ""},
+		{"multi:discard:,(aggregate:multi:/tmp/prism,discard:)", "multi(discard(), aggregate(0s, multi(file(/tmp/prism), discard())))", ""},
+		{"multi:( multi:discard:,(discard:) ), /tmp/prism(1)", "multi(multi(discard(), discard()), file(/tmp/prism(1)))", ""},
+		{"", "", "empty sink URI"},
+		{"ftp://localhost/profiles", "", `unsupported sink URI scheme "ftp"`},
+		{"file://remote/tmp/prism", "", `unsupported host "remote" in file sink URI`},
+		{"unix://", "", "missing path in unix sink URI"},
+		{"http:///profiles", "", "missing host in http sink URI"},
+		{"multi:", "", "multi sink URI does not specify any sinks"},
+		{"multi:discard:,", "", `empty sink URI in multi sink URI list "discard:,"`},
+		{"multi:discard:,,discard:", "", `empty sink URI in multi sink URI list "discard:,,discard:"`},
+		{"multi:(),discard:", "", `empty sink URI in multi sink URI list "(),discard:"`},
+		{"multi:(discard:,discard:", "", `unbalanced parentheses in multi sink URI list "(discard:,discard:"`},
+		{"multi:discard:),discard:", "", `unbalanced parentheses in multi sink URI list "discard:),discard:"`},
+		{"multi:discard:,ftp://localhost", "", `unsupported sink URI scheme "ftp"`},
+		{"aggregate:0s:discard:", "", `invalid aggregate sink flush interval "0s"`},
+		{"aggregate:", "", "empty sink URI"},
+	}
+
+	for specIndex, spec := range specs {
+		s, err := Open(spec.URI)
+		if spec.ExpError != "" {
+			if err == nil || err.Error() != spec.ExpError {
+				t.Errorf("[spec %d] expected error %q; got %v", specIndex, spec.ExpError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if desc := describeSink(s); desc != spec.ExpSink {
+			t.Errorf("[spec %d] expected sink to be %s; got %s", specIndex, spec.ExpSink, desc)
+		}
+	}
+}
+
+func TestMustOpenEnv(t *testing.T) {
+	defer os.Unsetenv(SinkEnvVar)
+
+	os.Unsetenv(SinkEnvVar)
+	if _, isFileSink := MustOpenEnv("file:///tmp/prism").(*fileSink); !isFileSink {
+		t.Error("expected MustOpenEnv to open the default sink when the env var is not set")
+	}
+
+	os.Setenv(SinkEnvVar, "discard:")
+	if _, isDiscardSink := MustOpenEnv("file:///tmp/prism").(*discardSink); !isDiscardSink {
+		t.Error("expected MustOpenEnv to open the sink specified by the env var")
+	}
+
+	os.Setenv(SinkEnvVar, "ftp://localhost")
+	defer func() {
+		if recover() == nil {
+			t.Error("expected MustOpenEnv to panic for an invalid sink URI")
+		}
+	}()
+	MustOpenEnv("file:///tmp/prism")
+}
+
+// Describe the type and settings of a sink.
+func describeSink(s profiler.Sink) string {
+	switch typedSink := s.(type) {
+	case *fileSink:
+		return "file(" + typedSink.outputDir + ")"
+	case *httpSink:
+		return "http(" + typedSink.endpoint + ")"
+	case *unixSink:
+		return "unix(" + typedSink.socketPath + ")"
+	case *discardSink:
+		return "discard()"
+	case *multiSink:
+		descs := make([]string, len(typedSink.sinks))
+		for index, wrappedSink := range typedSink.sinks {
+			descs[index] = describeSink(wrappedSink)
+		}
+		return "multi(" + strings.Join(descs, ", ") + ")"
+	case *aggregateSink:
+		return "aggregate(" + typedSink.flushInterval.String() + ", " + describeSink(typedSink.sink) + ")"
+	}
+	return "unknown"
+}
@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+type slowestNSink struct {
+	wrapperSink
+	sink profiler.Sink
+	n    int
+}
+
+// NewSlowestNSink creates a new profile entry sink instance which keeps track
+// of the n profiles with the highest root call total time. As the slowest
+// profiles are only known once all profiles have been received, they are
+// forwarded to sink, slowest first, when the sink is closed. Opening or
+// closing the slowest-N sink also opens or closes the sink that it wraps.
+func NewSlowestNSink(sink profiler.Sink, n int) profiler.Sink {
+	if n < 0 {
+		n = 0
+	}
+
+	s := &slowestNSink{
+		sink: sink,
+		n:    n,
+	}
+	s.wrapperSink = newWrapperSink(s.worker, sink)
+	return s
+}
+
+func (s *slowestNSink) worker(input <-chan *profiler.Profile) {
+	// Keep the slowest profiles in a min-heap so the fastest of them can
+	// be evicted when a slower profile arrives
+	slowest := make(profileHeap, 0, s.n)
+	for profile := range input {
+		switch {
+		case s.n == 0:
+		case len(slowest) < s.n:
+			heap.Push(&slowest, profile)
+		case profileTotalTime(profile) > profileTotalTime(slowest[0]):
+			slowest[0] = profile
+			heap.Fix(&slowest, 0)
+		}
+	}
+
+	sort.Sort(sort.Reverse(slowest))
+	for _, profile := range slowest {
+		s.sink.Input() <- profile
+	}
+}
+
+// Get the total time of a profile's root call.
+func profileTotalTime(profile *profiler.Profile) time.Duration {
+	if profile.Target == nil {
+		return 0
+	}
+	return profile.Target.TotalTime
+}
+
+// A min-heap of profiles ordered by their root call total time.
+type profileHeap []*profiler.Profile
+
+func (h profileHeap) Len() int           { return len(h) }
+func (h profileHeap) Less(i, j int) bool { return profileTotalTime(h[i]) < profileTotalTime(h[j]) }
+func (h profileHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *profileHeap) Push(x interface{}) {
+	*h = append(*h, x.(*profiler.Profile))
+}
+
+func (h *profileHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
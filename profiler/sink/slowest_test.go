@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestSlowestNSink(t *testing.T) {
+	totalTimes := []time.Duration{
+		5 * time.Millisecond,
+		40 * time.Millisecond,
+		10 * time.Millisecond,
+		30 * time.Millisecond,
+		1 * time.Millisecond,
+		20 * time.Millisecond,
+	}
+
+	specs := []struct {
+		N      int
+		ExpIDs []uint64
+	}{
+		{0, []uint64{}},
+		{1, []uint64{1}},
+		{3, []uint64{1, 3, 5}},
+		{10, []uint64{1, 3, 5, 2, 0, 4}},
+	}
+
+	for specIndex, spec := range specs {
+		buffer := newBufferedSink()
+		s := NewSlowestNSink(buffer, spec.N)
+		err := s.Open(0)
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		for index, totalTime := range totalTimes {
+			s.Input() <- &profiler.Profile{
+				ID:     uint64(index),
+				Target: &profiler.CallMetrics{TotalTime: totalTime},
+			}
+		}
+
+		err = s.Close()
+		if err != nil {
+			t.Fatalf("[spec %d] %v", specIndex, err)
+		}
+
+		if !buffer.closed {
+			t.Errorf("[spec %d] expected slowest-N sink to close the wrapped sink", specIndex)
+		}
+
+		if len(buffer.buffer) != len(spec.ExpIDs) {
+			t.Errorf("[spec %d] expected wrapped sink to receive %d profiles; got %d", specIndex, len(spec.ExpIDs), len(buffer.buffer))
+			continue
+		}
+		for index, expID := range spec.ExpIDs {
+			if id := buffer.buffer[index].ID; id != expID {
+				t.Errorf("[spec %d] expected profile %d to have ID %d; got %d", specIndex, index, expID, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+// The timeout for connecting to the unix socket.
+const unixDialTimeout = 1 * time.Second
+
+type unixSink struct {
+	socketPath string
+	conn       net.Conn
+	sigChan    chan struct{}
+	inputChan  chan *profiler.Profile
+}
+
+// NewUnixSink creates a new profile entry sink instance which streams profiles
+// as newline-delimited JSON to the unix socket specified by socketPath. The
+// sink connects to the socket when the first profile is captured and
+// reconnects if the connection is lost.
+func NewUnixSink(socketPath string) profiler.Sink {
+	return &unixSink{
+		socketPath: socketPath,
+		sigChan:    make(chan struct{}, 0),
+	}
+}
+
+// Initialize the sink.
+func (s *unixSink) Open(inputBufferSize int) error {
+	fmt.Fprintf(os.Stderr, "profiler: streaming profiles to unix socket %s\n", s.socketPath)
+
+	s.inputChan = make(chan *profiler.Profile, inputBufferSize)
+
+	// start worker and wait for ready signal
+	go s.worker()
+	<-s.sigChan
+	return nil
+}
+
+// Shutdown the sink.
+func (s *unixSink) Close() error {
+	// Signal worker to exit and wait for confirmation
+	close(s.inputChan)
+	<-s.sigChan
+	close(s.sigChan)
+
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// Get a channel for piping profile entries to the sink.
+func (s *unixSink) Input() chan<- *profiler.Profile {
+	return s.inputChan
+}
+
+func (s *unixSink) worker() {
+	// Signal that worker has started
+	s.sigChan <- struct{}{}
+	defer func() {
+		// Signal that we have stopped
+		s.sigChan <- struct{}{}
+	}()
+
+	for {
+		profile, sinkOpen := <-s.inputChan
+		if !sinkOpen {
+			return
+		}
+
+		data, err := json.Marshal(profile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "profiler: error marshalling profile: %s; dropping profile\n", err.Error())
+			continue
+		}
+
+		err = s.write(append(data, '\n'))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "profiler: error writing to unix socket %s: %s; dropping profile\n", s.socketPath, err.Error())
+		}
+	}
+}
+
+// Write data to the socket. If the connection was lost, write attempts to
+// reconnect once before giving up.
+func (s *unixSink) write(data []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			s.conn, err = net.DialTimeout("unix", s.socketPath, unixDialTimeout)
+			if err != nil {
+				s.conn = nil
+				continue
+			}
+		}
+
+		if _, err = s.conn.Write(data); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestUnixSink(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	socketPath := filepath.Join(tmpDir, "prism.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets are not supported: %v", err)
+	}
+	defer listener.Close()
+
+	// Collect the profile lines written to the socket
+	linesChan := make(chan []string, 1)
+	go func() {
+		lines := make([]string, 0)
+		defer func() { linesChan <- lines }()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+	}()
+
+	s := NewUnixSink(socketPath)
+	err = s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	numEntries := 3
+	for i := 0; i < numEntries; i++ {
+		s.Input() <- &profiler.Profile{
+			Target: &profiler.CallMetrics{FnName: fmt.Sprintf("foo.%d", i)},
+		}
+	}
+
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-linesChan
+	if len(lines) != numEntries {
+		t.Fatalf("expected socket to receive %d lines; got %d", numEntries, len(lines))
+	}
+	for index, line := range lines {
+		var profile *profiler.Profile
+		if err := json.Unmarshal([]byte(line), &profile); err != nil {
+			t.Errorf("[line %d] error decoding profile: %v", index, err)
+			continue
+		}
+
+		expFnName := fmt.Sprintf("foo.%d", index)
+		if profile.Target.FnName != expFnName {
+			t.Errorf("[line %d] expected profile target to be %q; got %q", index, expFnName, profile.Target.FnName)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sink
+
+import "github.com/geckoboard/prism/profiler"
+
+// wrapperSink implements the lifecycle shared by the sinks that process the
+// incoming profiles in a worker goroutine and forward them to one or more
+// wrapped sinks. Sinks embed it and supply the worker function that consumes
+// the input channel. The worker must return once the input channel is closed
+// and drained.
+type wrapperSink struct {
+	sinks     []profiler.Sink
+	worker    func(input <-chan *profiler.Profile)
+	inputChan chan *profiler.Profile
+	doneChan  chan struct{}
+}
+
+// Create a wrapperSink that runs worker and forwards its output to sinks.
+func newWrapperSink(worker func(input <-chan *profiler.Profile), sinks ...profiler.Sink) wrapperSink {
+	return wrapperSink{
+		sinks:  sinks,
+		worker: worker,
+	}
+}
+
+// Initialize the sink and the sinks that it wraps. If a wrapped sink fails
+// to open, any wrapped sinks that were already opened are closed.
+func (s *wrapperSink) Open(inputBufferSize int) error {
+	for index, sink := range s.sinks {
+		if err := sink.Open(inputBufferSize); err != nil {
+			for _, openedSink := range s.sinks[:index] {
+				openedSink.Close()
+			}
+			return err
+		}
+	}
+
+	s.inputChan = make(chan *profiler.Profile, inputBufferSize)
+	s.doneChan = make(chan struct{})
+
+	// start worker and wait for ready signal
+	readyChan := make(chan struct{})
+	go func() {
+		defer close(s.doneChan)
+		close(readyChan)
+		s.worker(s.inputChan)
+	}()
+	<-readyChan
+	return nil
+}
+
+// Shutdown the sink and the sinks that it wraps. The worker drains any
+// pending profiles before the wrapped sinks are closed. The first error
+// reported by the wrapped sinks is returned.
+func (s *wrapperSink) Close() error {
+	// Signal worker to exit and wait for confirmation
+	close(s.inputChan)
+	<-s.doneChan
+
+	var firstErr error
+	for _, sink := range s.sinks {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Get a channel for piping profile entries to the sink.
+func (s *wrapperSink) Input() chan<- *profiler.Profile {
+	return s.inputChan
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/geckoboard/prism/profiler"
+)
+
+func TestWrapperSinkOpenError(t *testing.T) {
+	opened := newBufferedSink()
+	failing := &failingSink{openErr: errors.New("open failed")}
+	s := NewMultiSink(opened, failing)
+
+	err := s.Open(0)
+	if err == nil || err.Error() != "open failed" {
+		t.Fatalf("expected Open to return the error of the failing sink; got %v", err)
+	}
+
+	if !opened.closed {
+		t.Error("expected the sinks that were already opened to be closed")
+	}
+}
+
+func TestWrapperSinkCloseError(t *testing.T) {
+	buffer := newBufferedSink()
+	failing := &failingSink{closeErr: errors.New("close failed")}
+	s := NewMultiSink(failing, buffer)
+
+	err := s.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Input() <- &profiler.Profile{Label: "test"}
+
+	err = s.Close()
+	if err == nil || err.Error() != "close failed" {
+		t.Fatalf("expected Close to return the error of the failing sink; got %v", err)
+	}
+
+	// The pending profiles should be drained and all wrapped sinks closed
+	if !buffer.closed {
+		t.Error("expected all wrapped sinks to be closed")
+	}
+	if len(buffer.buffer) != 1 {
+		t.Fatalf("expected wrapped sink to receive 1 profile; got %d", len(buffer.buffer))
+	}
+}
+
+// failingSink is a sink that discards the profiles sent to it and returns the
+// configured errors when opened or closed.
+type failingSink struct {
+	openErr, closeErr error
+	inputChan         chan *profiler.Profile
+}
+
+func (s *failingSink) Open(inputBufferSize int) error {
+	if s.openErr != nil {
+		return s.openErr
+	}
+
+	s.inputChan = make(chan *profiler.Profile, inputBufferSize)
+	go func() {
+		for range s.inputChan {
+		}
+	}()
+	return nil
+}
+
+func (s *failingSink) Close() error {
+	close(s.inputChan)
+	return s.closeErr
+}
+
+func (s *failingSink) Input() chan<- *profiler.Profile {
+	return s.inputChan
+}
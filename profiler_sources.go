@@ -0,0 +1,17 @@
+package main
+
+import (
+	"embed"
+
+	"github.com/geckoboard/prism/tools"
+)
+
+// The sources of the profiler packages are embedded into the prism binary so
+// that they can be copied next to the cloned go modules being profiled.
+//
+//go:embed profiler
+var profilerSources embed.FS
+
+func init() {
+	tools.ProfilerSources = profilerSources
+}
@@ -3,6 +3,10 @@ package tools
 import (
 	"bytes"
 	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/ast/astutil"
@@ -23,6 +27,13 @@ type funcVisitor struct {
 	// The unique list of functions that we need to hook indexed by FQN.
 	uniqueTargetMap map[string]*CallGraphNode
 
+	// The FQ names of the anonymous functions in the SSA representation of
+	// the package indexed by their source position.
+	anonFuncNames map[funcPos]string
+
+	// The type-checked packages of the program indexed by their import path.
+	loadedPkgs map[string]*types.Package
+
 	// Flag indicating whether the AST was modified.
 	modifiedAST bool
 
@@ -31,10 +42,12 @@ type funcVisitor struct {
 }
 
 // Create a new function node visitor.
-func newFuncVisitor(uniqueTargetMap map[string]*CallGraphNode, patchFn PatchFunc) *funcVisitor {
+func newFuncVisitor(uniqueTargetMap map[string]*CallGraphNode, anonFuncNames map[funcPos]string, loadedPkgs map[string]*types.Package, patchFn PatchFunc) *funcVisitor {
 	return &funcVisitor{
 		patchFn:         patchFn,
 		uniqueTargetMap: uniqueTargetMap,
+		anonFuncNames:   anonFuncNames,
+		loadedPkgs:      loadedPkgs,
 	}
 }
 
@@ -76,14 +89,62 @@ func (v *funcVisitor) Visit(node ast.Node) ast.Visitor {
 		return nil
 	}
 
-	// Check if we need to hook this function
+	// Check if we need to hook this function or any of its closures. The
+	// closures need to be looked up before patching the function body as
+	// the patch may add new function literals to it.
 	fqName := qualifiedNodeName(fnDecl, v.parsedFile.pkgName)
+	closures, closureNames := v.closures(fnDecl.Body)
+
+	v.patch(fqName, fnDecl.Type, fnDecl.Body, closureNames)
+	for _, closure := range closures {
+		v.patch(closureNames[closure], closure.Type, closure.Body, closureNames)
+	}
+
+	return nil
+}
+
+// Collect the anonymous functions defined inside a function body in source
+// order and name them after their SSA representation. The names are looked
+// up by position as the numbering of anonymous functions (e.g. Handler.func1,
+// Handler.func1.func1) follows the order in which the SSA builder visits them
+// which does not always match the source order. Any anonymous functions
+// without an SSA representation are omitted.
+func (v *funcVisitor) closures(body *ast.BlockStmt) ([]*ast.FuncLit, map[*ast.FuncLit]string) {
+	closures := make([]*ast.FuncLit, 0)
+	closureNames := make(map[*ast.FuncLit]string, 0)
+	ast.Inspect(body, func(node ast.Node) bool {
+		funcLit, isFuncLit := node.(*ast.FuncLit)
+		if !isFuncLit {
+			return true
+		}
+
+		pos := makeFuncPos(v.parsedFile.fset.Position(funcLit.Type.Func))
+		if fqName, found := v.anonFuncNames[pos]; found {
+			closures = append(closures, funcLit)
+			closureNames[funcLit] = fqName
+		}
+		return true
+	})
+
+	return closures, closureNames
+}
+
+// Apply the patch function to a function if fqName is one of our targets.
+func (v *funcVisitor) patch(fqName string, fnType *ast.FuncType, body *ast.BlockStmt, closureNames map[*ast.FuncLit]string) {
 	cgNode, isTarget := v.uniqueTargetMap[fqName]
 	if !isTarget {
-		return nil
+		return
 	}
 
-	modified, extraImports := v.patchFn(cgNode, fnDecl.Body)
+	// The callgraph nodes are shared by all files so the closure names are
+	// attached to a copy
+	patchedNode := *cgNode
+	patchedNode.patchCtx = &patchContext{
+		closureNames: closureNames,
+		lookupObject: v.objectLookup(v.parsedFile),
+	}
+
+	modified, extraImports := v.patchFn(&patchedNode, fnType, body)
 	if modified {
 		v.modifiedAST = true
 		v.patchCount++
@@ -93,8 +154,69 @@ func (v *funcVisitor) Visit(node ast.Node) ast.Visitor {
 			v.extraImports[name] = struct{}{}
 		}
 	}
+}
 
-	return nil
+// Create a function for looking up the package-level objects referenced by
+// the unresolved and the package-qualified identifiers of a file. The parser
+// only resolves identifiers declared in the same file so any package-level
+// declarations from other files or imported packages are looked up in the
+// type-checked packages of the program.
+func (v *funcVisitor) objectLookup(parsedFile *parsedGoFile) func(ast.Expr) types.Object {
+	return func(expr ast.Expr) types.Object {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			if pkg := v.loadedPkgs[parsedFile.pkgName]; pkg != nil {
+				if obj := pkg.Scope().Lookup(e.Name); obj != nil {
+					return obj
+				}
+			}
+			return types.Universe.Lookup(e.Name)
+		case *ast.SelectorExpr:
+			pkgIdent, isIdent := e.X.(*ast.Ident)
+			if !isIdent {
+				return nil
+			}
+
+			for _, importSpec := range parsedFile.astFile.Imports {
+				importPath, err := strconv.Unquote(importSpec.Path.Value)
+				pkg := v.loadedPkgs[importPath]
+				if err != nil || pkg == nil {
+					continue
+				}
+
+				pkgName := pkg.Name()
+				if importSpec.Name != nil {
+					pkgName = importSpec.Name.Name
+				}
+				if pkgName == pkgIdent.Name {
+					return pkg.Scope().Lookup(e.Sel.Name)
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+// funcPos identifies a function by the source position of its func keyword.
+type funcPos struct {
+	file         string
+	line, column int
+}
+
+// Create a funcPos from a source position. File paths are made absolute so
+// that positions reported by different file sets can be compared.
+func makeFuncPos(pos token.Position) funcPos {
+	file, err := filepath.Abs(pos.Filename)
+	if err != nil {
+		file = pos.Filename
+	}
+
+	return funcPos{
+		file:   file,
+		line:   pos.Line,
+		column: pos.Column,
+	}
 }
 
 // Returns the fully qualified name for function declaration given its AST node.
@@ -105,13 +227,8 @@ func qualifiedNodeName(fnDecl *ast.FuncDecl, pkgName string) string {
 	// Examine receiver
 	if fnDecl.Recv != nil {
 		for _, rcvField := range fnDecl.Recv.List {
-			// We only care for identifiers and star expressions
-			switch rcvType := rcvField.Type.(type) {
-			case *ast.StarExpr: // e.g (b *Bar)
-				buf.WriteString(rcvType.X.(*ast.Ident).Name)
-				buf.WriteByte('.')
-			case *ast.Ident:
-				buf.WriteString(rcvType.Name)
+			if rcvName := receiverTypeName(rcvField.Type); rcvName != "" {
+				buf.WriteString(rcvName)
 				buf.WriteByte('.')
 			}
 		}
@@ -121,3 +238,21 @@ func qualifiedNodeName(fnDecl *ast.FuncDecl, pkgName string) string {
 	buf.WriteString(fnDecl.Name.Name)
 	return buf.String()
 }
+
+// Returns the name of a receiver type omitting any type parameters. We only
+// care for identifiers, star expressions and, for generic types, index
+// expressions; an empty string is returned for any other expression.
+func receiverTypeName(rcvType ast.Expr) string {
+	switch expr := rcvType.(type) {
+	case *ast.StarExpr: // e.g (b *Bar)
+		return receiverTypeName(expr.X)
+	case *ast.IndexExpr: // e.g (s Set[T])
+		return receiverTypeName(expr.X)
+	case *ast.IndexListExpr: // e.g (p Pair[K, V])
+		return receiverTypeName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+
+	return ""
+}
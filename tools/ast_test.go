@@ -4,6 +4,8 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -17,6 +19,10 @@ func TestQualifiedCodeName(t *testing.T) {
 		{"NoReceiver", parsedFile.pkgName + "/NoReceiver"},
 		{"Receiver", parsedFile.pkgName + "/MyFoo.Receiver"},
 		{"PtrReceiver", parsedFile.pkgName + "/MyFoo.PtrReceiver"},
+		{"GenericFn", parsedFile.pkgName + "/GenericFn"},
+		{"GenericReceiver", parsedFile.pkgName + "/MySet.GenericReceiver"},
+		{"GenericPtrReceiver", parsedFile.pkgName + "/MySet.GenericPtrReceiver"},
+		{"MultiParamReceiver", parsedFile.pkgName + "/MyPair.MultiParamReceiver"},
 	}
 
 	for specIndex, spec := range specs {
@@ -51,7 +57,9 @@ func TestFuncVisitorImport(t *testing.T) {
 	}
 	visitor := newFuncVisitor(
 		targetMap,
-		func(_ *CallGraphNode, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		nil,
+		nil,
+		func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 			return true, []string{
 				"github.com/foo/bar",
 				"namedImport github.com/foo/baz",
@@ -92,6 +100,303 @@ func TestFuncVisitorImport(t *testing.T) {
 	}
 }
 
+func TestFuncVisitorClosureNames(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockClosurePackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedFile := mockClosureParsedFile(t, pkgDir, pkgName)
+	visitor := newFuncVisitor(nil, anonFuncNames(candidates), nil, nil)
+	visitor.parsedFile = parsedFile
+
+	// The closures are listed in source order and named after their SSA
+	// representation
+	expNames := []string{
+		pkgName + "/Server.Handle.func1",
+		pkgName + "/Server.Handle.func1.func1",
+		pkgName + "/loop.func1",
+		pkgName + "/loop.func3",
+		pkgName + "/loop.func2",
+		pkgName + "/pick.func2",
+		pkgName + "/pick.func1",
+		pkgName + "/kind.func2",
+		pkgName + "/kind.func1",
+		pkgName + "/main.func1",
+	}
+
+	names := make([]string, 0)
+	for _, decl := range parsedFile.astFile.Decls {
+		fnDecl, isFnDecl := decl.(*ast.FuncDecl)
+		if !isFnDecl {
+			continue
+		}
+
+		closures, closureNames := visitor.closures(fnDecl.Body)
+		for _, closure := range closures {
+			fqName := closureNames[closure]
+			names = append(names, fqName)
+
+			// The SSA function with the same name should point to the same function literal
+			ssaFn := candidates[fqName]
+			if ssaFn == nil {
+				t.Errorf("could not find SSA function for %q", fqName)
+				continue
+			}
+
+			expPos := parsedFile.fset.Position(closure.Pos())
+			ssaPos := ssaFn.Prog.Fset.Position(ssaFn.Pos())
+			if expPos.Line != ssaPos.Line || expPos.Column != ssaPos.Column {
+				t.Errorf("expected SSA function %q to be defined at %d:%d; got %d:%d", fqName, expPos.Line, expPos.Column, ssaPos.Line, ssaPos.Column)
+			}
+		}
+	}
+
+	if len(names) != len(expNames) {
+		t.Fatalf("expected to get %d anonymous functions; got %v", len(expNames), names)
+	}
+
+	for index, expName := range expNames {
+		if names[index] != expName {
+			t.Errorf("expected anonymous function %d to be named %q; got %q", index, expName, names[index])
+		}
+	}
+}
+
+func TestFuncVisitorClosures(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockClosurePackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedFile := mockClosureParsedFile(t, pkgDir, pkgName)
+	targetMap := map[string]*CallGraphNode{
+		pkgName + "/Server.Handle.func1.func1": &CallGraphNode{
+			Name: pkgName + "/Server.Handle.func1.func1",
+		},
+		pkgName + "/pick.func1": &CallGraphNode{
+			Name: pkgName + "/pick.func1",
+		},
+		pkgName + "/kind.func1": &CallGraphNode{
+			Name: pkgName + "/kind.func1",
+		},
+		pkgName + "/main.func1": &CallGraphNode{
+			Name: pkgName + "/main.func1",
+		},
+	}
+
+	patchedNames := make([]string, 0)
+	patchedLines := make([]int, 0)
+	visitor := newFuncVisitor(
+		targetMap,
+		anonFuncNames(candidates),
+		nil,
+		func(cgNode *CallGraphNode, fnType *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+			patchedNames = append(patchedNames, cgNode.Name)
+			patchedLines = append(patchedLines, parsedFile.fset.Position(fnType.Pos()).Line)
+			return true, nil
+		},
+	)
+
+	modifiedAST, patchCount := visitor.Process(parsedFile)
+	if !modifiedAST {
+		t.Fatal("expected visitor to modify the AST")
+	}
+
+	expPatchCount := 4
+	if patchCount != expPatchCount {
+		t.Fatalf("expected visitor to apply %d patches; got %d", expPatchCount, patchCount)
+	}
+
+	// The closures defined by the non-default switch clauses should be
+	// patched even though the default clauses come first
+	specs := []struct {
+		Name string
+		Line int
+	}{
+		{pkgName + "/Server.Handle.func1.func1", 8},
+		{pkgName + "/pick.func1", 29},
+		{pkgName + "/kind.func1", 38},
+		{pkgName + "/main.func1", 45},
+	}
+	for specIndex, spec := range specs {
+		if patchedNames[specIndex] != spec.Name || patchedLines[specIndex] != spec.Line {
+			t.Errorf("[spec %d] expected patch to be applied to %q at line %d; got %q at line %d", specIndex, spec.Name, spec.Line, patchedNames[specIndex], patchedLines[specIndex])
+		}
+	}
+}
+
+func TestFuncVisitorObjectLookup(t *testing.T) {
+	pkgName := "prism-mock"
+	decls := `
+package main
+
+var limit = 1
+
+const retries = 3
+
+type Limit int
+
+func work(args ...interface{}) {}
+`
+	src := `
+package main
+
+import (
+	"os"
+	clock "time"
+)
+
+func spawn() {
+	go work(limit, retries, Limit(1), Limit.String, os.Args, os.Getpid, clock.Second, clock.Duration(1), nil)
+}
+
+func (l Limit) String() string {
+	return ""
+}
+
+func main() {
+	spawn()
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(workspaceDir)
+
+	pkgDir := workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+	for fileName, contents := range map[string]string{"decls.go": decls, "src.go": src} {
+		err = ioutil.WriteFile(pkgDir+fileName, []byte(contents), os.ModePerm)
+		if err != nil {
+			t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+		}
+	}
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, workspaceDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsedFile := mockClosureParsedFile(t, pkgDir, pkgName)
+	visitor := newFuncVisitor(nil, nil, loadedPackages(candidates), nil)
+	lookupObject := visitor.objectLookup(parsedFile)
+
+	fnDecl := parsedFile.astFile.Decls[1].(*ast.FuncDecl)
+	args := fnDecl.Body.List[0].(*ast.GoStmt).Call.Args
+
+	// Package-level variables declared in other files or imported packages
+	// must be evaluated by the spawning goroutine
+	expStable := []bool{false, true, false, false, false, true, true, false, true}
+	if len(args) != len(expStable) {
+		t.Fatalf("expected %d arguments; got %d", len(expStable), len(args))
+	}
+
+	for index, arg := range args {
+		if isStable := isStableExpr(arg, lookupObject); isStable != expStable[index] {
+			t.Errorf("[arg %d] expected isStableExpr to return %t; got %t", index, expStable[index], isStable)
+		}
+	}
+}
+
+func mockClosurePackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
+	pkgName = "prism-mock"
+	src := `
+package main
+
+type Server struct{}
+
+func (s *Server) Handle() {
+	run(func() {
+		run(func() {})
+	})
+}
+
+func run(fn func()) {
+	fn()
+}
+
+func loop() {
+	// The SSA builder visits the loop body before the post statement
+	for i := 0; i < func() int { return 1 }(); i = func() int { return i + 1 }() {
+		run(func() {})
+	}
+}
+
+// The SSA builder visits the default clause of a switch statement last
+func pick(v int) func() {
+	switch v {
+	default:
+		return func() {}
+	case 1:
+		return func() {}
+	}
+}
+
+func kind(v interface{}) func() {
+	switch v.(type) {
+	default:
+		return func() {}
+	case int:
+		return func() {}
+	}
+}
+
+func main() {
+	s := &Server{}
+	s.Handle()
+	defer func() {}()
+	loop()
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir = workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+
+	err = ioutil.WriteFile(pkgDir+"src.go", []byte(src), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+	}
+
+	return workspaceDir, pkgDir, pkgName
+}
+
+func mockClosureParsedFile(t *testing.T, pkgDir, pkgName string) *parsedGoFile {
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, pkgDir+"src.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &parsedGoFile{
+		pkgName:  pkgName,
+		filePath: pkgDir + "src.go",
+		fset:     fset,
+		astFile:  astFile,
+	}
+}
+
 func mockParsedGoFile(t *testing.T) *parsedGoFile {
 	filePath := "test.go"
 	fqPkgName := "github.com/geckoboard/test"
@@ -107,6 +412,14 @@ func NoReceiver()
 func NoReceiver(){}
 func (f MyFoo) Receiver(){}
 func (f *MyFoo) PtrReceiver(arg int){}
+
+type MySet[T comparable] struct{}
+type MyPair[K comparable, V any] struct{}
+
+func GenericFn[T any](v T){}
+func (s MySet[T]) GenericReceiver(){}
+func (s *MySet[T]) GenericPtrReceiver(){}
+func (p *MyPair[K, V]) MultiParamReceiver(){}
 `
 
 	fset := token.NewFileSet()
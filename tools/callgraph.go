@@ -1,21 +1,122 @@
 package tools
 
 import (
+	"fmt"
+	"go/ast"
+	"go/types"
 	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
 	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/callgraph/static"
+	"golang.org/x/tools/go/callgraph/vta"
 	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
 )
 
+// CallGraphAlgorithm selects the analysis used for discovering the functions
+// that are reachable through a ProfileTarget.
+type CallGraphAlgorithm uint8
+
+// The supported callgraph algorithms.
+const (
+	// Rapid Type Analysis; only considers dynamic calls to types that are
+	// instantiated by the code reachable from the target.
+	CallGraphRTA CallGraphAlgorithm = iota
+
+	// Class Hierarchy Analysis; dynamic calls are resolved to all methods
+	// implementing the called interface.
+	CallGraphCHA
+
+	// Variable Type Analysis; dynamic calls are resolved to the types that
+	// can flow into the called value.
+	CallGraphVTA
+
+	// Only direct calls to statically known functions are followed.
+	CallGraphStatic
+)
+
+var (
+	callGraphAlgorithmNames = map[CallGraphAlgorithm]string{
+		CallGraphRTA:    "rta",
+		CallGraphCHA:    "cha",
+		CallGraphVTA:    "vta",
+		CallGraphStatic: "static",
+	}
+
+	// Whole-program callgraphs are expensive to construct so we only build
+	// them once per SSA program and algorithm.
+	programGraphMutex sync.Mutex
+	programGraphCache = make(map[programGraphKey]*callgraph.Graph, 0)
+)
+
+// programGraphKey indexes the cache of whole-program callgraphs.
+type programGraphKey struct {
+	prog      *ssa.Program
+	algorithm CallGraphAlgorithm
+}
+
+// ParseCallGraphAlgorithm returns the CallGraphAlgorithm with the given name.
+func ParseCallGraphAlgorithm(val string) (CallGraphAlgorithm, error) {
+	trimmed := strings.TrimSpace(val)
+	for algorithm, name := range callGraphAlgorithmNames {
+		if name == trimmed {
+			return algorithm, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported callgraph algorithm %q", trimmed)
+}
+
+// String returns the name of the callgraph algorithm.
+func (a CallGraphAlgorithm) String() string {
+	return callGraphAlgorithmNames[a]
+}
+
 // CallGraphNode models a section of the callgraph that is reachable through
 // a Target root node via one or more hops.
 type CallGraphNode struct {
 	// A fully qualified function name reachable through a ProfileTarget.
 	Name string
 
-	// Number of hops from the callgraph entrypoint (root).
+	// Number of hops from the callgraph entrypoint (root) via the
+	// shortest path.
 	Depth int
+
+	// Details about the AST of the function. Only populated for the nodes
+	// passed to a PatchFunc.
+	patchCtx *patchContext
+}
+
+// patchContext describes the AST of a function that is being patched.
+type patchContext struct {
+	// The FQ names of the anonymous functions defined inside the function
+	// indexed by their AST node.
+	closureNames map[*ast.FuncLit]string
+
+	// Looks up the package-level object referenced by an identifier that
+	// the parser could not resolve or by a package-qualified identifier.
+	// Returns nil if the object is unknown.
+	lookupObject func(ast.Expr) types.Object
+}
+
+// Get the closure names for a callgraph node that is being patched.
+func (n *CallGraphNode) closureNames() map[*ast.FuncLit]string {
+	if n.patchCtx == nil {
+		return nil
+	}
+	return n.patchCtx.closureNames
+}
+
+// Look up the package-level object referenced by an unresolved or
+// package-qualified identifier in a callgraph node that is being patched.
+func (n *CallGraphNode) lookupObject(expr ast.Expr) types.Object {
+	if n.patchCtx == nil || n.patchCtx.lookupObject == nil {
+		return nil
+	}
+	return n.patchCtx.lookupObject(expr)
 }
 
 // CallGraph is a slice of callgraph nodes obtained by performing
@@ -32,8 +133,27 @@ type ProfileTarget struct {
 	// The fully qualified package name for the analyzed go package.
 	PkgPrefix string
 
+	// The algorithm used for discovering the functions reachable from
+	// the target. Defaults to RTA.
+	CallGraphAlgorithm CallGraphAlgorithm
+
+	// The maximum number of hops from the target to include in the
+	// callgraph. A zero value disables the depth limit.
+	MaxDepth int
+
+	// A list of FQ function names, glob patterns or "re:" prefixed regular
+	// expressions for functions that should be excluded from the callgraph.
+	// Functions that can only be reached via an excluded function are also
+	// excluded.
+	Exclude []string
+
+	// A list of FQ names for functions that should never be hooked. Unlike
+	// Exclude, any functions reachable via an ignored function are still
+	// included in the callgraph.
+	Ignore []string
+
 	// The SSA representation of the target. We rely on this to perform
-	// RTA analysis so we can discover any reachable functions from this endpoint
+	// callgraph analysis so we can discover any reachable functions from this endpoint
 	ssaFunc *ssa.Function
 }
 
@@ -42,10 +162,15 @@ type ProfileTarget struct {
 // the profile target.
 //
 // The discovery of any functions reachable by the endpoint is facilitated by
-// the use of Rapid Type Analysis (RTA).
+// the callgraph algorithm selected for the target. By default, Rapid Type
+// Analysis (RTA) is used.
 //
 // The discovery algorithm only considers functions whose FQN begins with the
-// processed root package name. This includes any vendored dependencies.
+// processed root package name. This includes any vendored dependencies. The
+// graph is further pruned by omitting functions deeper than MaxDepth, the
+// subtrees rooted at any functions matching the Exclude patterns and the
+// functions listed in Ignore. The target itself is never excluded but it is
+// omitted if it is listed in Ignore.
 func (pt *ProfileTarget) CallGraph() CallGraph {
 	cg := make(CallGraph, 0)
 	if pt.ssaFunc == nil {
@@ -55,25 +180,53 @@ func (pt *ProfileTarget) CallGraph() CallGraph {
 	}
 
 	var visitFn func(node *callgraph.Node, depth int)
-	calleeCache := make(map[string]struct{}, 0)
+	calleeDepth := make(map[string]int, 0)
+	calleeNodes := make(map[string]*CallGraphNode, 0)
+	isExcluded := newMultiTargetMatcher(pt.Exclude)
+	ignored := make(map[string]struct{}, len(pt.Ignore))
+	for _, fqName := range pt.Ignore {
+		ignored[fqName] = struct{}{}
+	}
 	visitFn = func(node *callgraph.Node, depth int) {
+		// Instantiations of generic functions simply call the generic
+		// function they were instantiated from so we skip over them
+		if node.Func.Origin() != nil {
+			for _, outEdge := range node.Out {
+				visitFn(outEdge.Callee, depth)
+			}
+			return
+		}
+
 		target := ssaQualifiedFuncName(node.Func)
 
 		if !includeInGraph(target, pt.PkgPrefix) {
 			return
 		}
 
-		// Watch out for callgraph loops; if we have already visited
-		// this edge bail out
-		if _, exists := calleeCache[target]; exists {
+		// Prune subtrees past the depth limit or rooted at an excluded function
+		if depth > 0 && ((pt.MaxDepth > 0 && depth > pt.MaxDepth) || isExcluded(target)) {
 			return
 		}
-		calleeCache[target] = struct{}{}
 
-		cg = append(cg, &CallGraphNode{
-			Name:  target,
-			Depth: depth,
-		})
+		// Watch out for callgraph loops; if we have already visited this
+		// node via a path that is not longer than the current one bail
+		// out. Otherwise, we need to revisit it as the shorter path may
+		// bring more of its subtree within the depth limit
+		if minDepth, exists := calleeDepth[target]; exists && minDepth <= depth {
+			return
+		}
+		calleeDepth[target] = depth
+
+		if cgNode, exists := calleeNodes[target]; exists {
+			cgNode.Depth = depth
+		} else if _, isIgnored := ignored[target]; !isIgnored {
+			cgNode = &CallGraphNode{
+				Name:  target,
+				Depth: depth,
+			}
+			calleeNodes[target] = cgNode
+			cg = append(cg, cgNode)
+		}
 
 		// Visit edges
 		for _, outEdge := range node.Out {
@@ -81,13 +234,53 @@ func (pt *ProfileTarget) CallGraph() CallGraph {
 		}
 	}
 
-	// Build and traverse RTA graph starting at entrypoint.
-	rtaRes := rta.Analyze([]*ssa.Function{pt.ssaFunc}, true)
-	visitFn(rtaRes.CallGraph.Root, 0)
+	// Build and traverse graph starting at entrypoint.
+	root := pt.callGraphRoot()
+	if root == nil {
+		return append(cg, &CallGraphNode{
+			Name: pt.QualifiedName,
+		})
+	}
+	visitFn(root, 0)
 
 	return cg
 }
 
+// Get the callgraph node for the target using the selected algorithm.
+func (pt *ProfileTarget) callGraphRoot() *callgraph.Node {
+	if pt.CallGraphAlgorithm == CallGraphRTA {
+		rtaRes := rta.Analyze([]*ssa.Function{pt.ssaFunc}, true)
+		return rtaRes.CallGraph.Root
+	}
+
+	return programCallGraph(pt.ssaFunc.Prog, pt.CallGraphAlgorithm).Nodes[pt.ssaFunc]
+}
+
+// Build the whole-program callgraph for prog using the given algorithm or
+// return a cached copy if it has already been built.
+func programCallGraph(prog *ssa.Program, algorithm CallGraphAlgorithm) *callgraph.Graph {
+	programGraphMutex.Lock()
+	defer programGraphMutex.Unlock()
+
+	key := programGraphKey{prog: prog, algorithm: algorithm}
+	if graph, exists := programGraphCache[key]; exists {
+		return graph
+	}
+
+	var graph *callgraph.Graph
+	switch algorithm {
+	case CallGraphCHA:
+		graph = cha.CallGraph(prog)
+	case CallGraphVTA:
+		graph = vta.CallGraph(ssautil.AllFunctions(prog), cha.CallGraph(prog))
+	default:
+		graph = static.CallGraph(prog)
+	}
+
+	programGraphCache[key] = graph
+	return graph
+}
+
 // Check if target can be include in callgraph.
 func includeInGraph(target string, pkgPrefix string) bool {
 	return strings.HasPrefix(target, pkgPrefix)
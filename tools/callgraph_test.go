@@ -1,7 +1,9 @@
 package tools
 
 import (
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -46,6 +48,149 @@ func TestCallgraphGeneration(t *testing.T) {
 	}
 }
 
+func TestCallgraphPruning(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainFqName := pkgName + "/main"
+	specs := []struct {
+		MaxDepth     int
+		Exclude      []string
+		ExpNodeNames []string
+	}{
+		{0, nil, []string{mainFqName, pkgName + "/DoStuff", pkgName + "/A.DoStuff"}},
+		{1, nil, []string{mainFqName, pkgName + "/DoStuff"}},
+		{0, []string{pkgName + "/DoStuff"}, []string{mainFqName}},
+		{0, []string{pkgName + "/A.*"}, []string{mainFqName, pkgName + "/DoStuff"}},
+		// The target itself should never be excluded
+		{0, []string{"re:.*/main"}, []string{mainFqName, pkgName + "/DoStuff", pkgName + "/A.DoStuff"}},
+	}
+
+	for specIndex, spec := range specs {
+		target := &ProfileTarget{
+			QualifiedName: mainFqName,
+			PkgPrefix:     pkgName,
+			MaxDepth:      spec.MaxDepth,
+			Exclude:       spec.Exclude,
+			ssaFunc:       candidates[mainFqName],
+		}
+
+		graphNodes := target.CallGraph()
+		if len(graphNodes) != len(spec.ExpNodeNames) {
+			t.Errorf("[spec %d] expected callgraph from main() to have %d nodes; got %d", specIndex, len(spec.ExpNodeNames), len(graphNodes))
+			continue
+		}
+
+		for index, node := range graphNodes {
+			if node.Name != spec.ExpNodeNames[index] {
+				t.Errorf("[spec %d] expected node %d to have name %q; got %q", specIndex, index, spec.ExpNodeNames[index], node.Name)
+			}
+		}
+	}
+}
+
+func TestCallgraphDepthUsesShortestPath(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockShortcutPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// T reaches B both via T->X->Y->B and directly via T->B. The longer
+	// path is visited first.
+	targetFqName := pkgName + "/T"
+	specs := []struct {
+		MaxDepth  int
+		ExpDepths map[string]int
+	}{
+		{0, map[string]int{"T": 0, "X": 1, "Y": 2, "B": 1, "C": 2}},
+		{3, map[string]int{"T": 0, "X": 1, "Y": 2, "B": 1, "C": 2}},
+		{2, map[string]int{"T": 0, "X": 1, "Y": 2, "B": 1, "C": 2}},
+		{1, map[string]int{"T": 0, "X": 1, "B": 1}},
+	}
+
+	for specIndex, spec := range specs {
+		target := &ProfileTarget{
+			QualifiedName: targetFqName,
+			PkgPrefix:     pkgName,
+			MaxDepth:      spec.MaxDepth,
+			ssaFunc:       candidates[targetFqName],
+		}
+
+		graphNodes := target.CallGraph()
+		if len(graphNodes) != len(spec.ExpDepths) {
+			t.Errorf("[spec %d] expected callgraph from T() to have %d nodes; got %d", specIndex, len(spec.ExpDepths), len(graphNodes))
+			continue
+		}
+
+		for _, node := range graphNodes {
+			name := strings.TrimPrefix(node.Name, pkgName+"/")
+			expDepth, expected := spec.ExpDepths[name]
+			if !expected {
+				t.Errorf("[spec %d] unexpected callgraph node %q", specIndex, node.Name)
+				continue
+			}
+			if node.Depth != expDepth {
+				t.Errorf("[spec %d] expected node %q to have depth %d; got %d", specIndex, node.Name, expDepth, node.Depth)
+			}
+		}
+	}
+}
+
+func TestCallgraphIgnore(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockShortcutPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetFqName := pkgName + "/T"
+	specs := []struct {
+		Ignore       []string
+		ExpNodeNames []string
+	}{
+		// Functions reachable via an ignored function are still included
+		{[]string{"B"}, []string{"T", "X", "Y", "C"}},
+		// Ignored functions are never hooked even if selected as targets
+		{[]string{"T"}, []string{"X", "Y", "B", "C"}},
+	}
+
+	for specIndex, spec := range specs {
+		ignore := make([]string, len(spec.Ignore))
+		for index, name := range spec.Ignore {
+			ignore[index] = pkgName + "/" + name
+		}
+
+		target := &ProfileTarget{
+			QualifiedName: targetFqName,
+			PkgPrefix:     pkgName,
+			Ignore:        ignore,
+			ssaFunc:       candidates[targetFqName],
+		}
+
+		graphNodes := target.CallGraph()
+		if len(graphNodes) != len(spec.ExpNodeNames) {
+			t.Errorf("[spec %d] expected callgraph from T() to have %d nodes; got %d", specIndex, len(spec.ExpNodeNames), len(graphNodes))
+			continue
+		}
+
+		for index, node := range graphNodes {
+			if expName := pkgName + "/" + spec.ExpNodeNames[index]; node.Name != expName {
+				t.Errorf("[spec %d] expected node %d to have name %q; got %q", specIndex, index, expName, node.Name)
+			}
+		}
+	}
+}
+
 func TestCallgraphGenerationWithNilSSA(t *testing.T) {
 	target := &ProfileTarget{
 		QualifiedName: "mock/main",
@@ -58,3 +203,311 @@ func TestCallgraphGenerationWithNilSSA(t *testing.T) {
 		t.Fatalf("expected callgraph from main() to have %d nodes; got %d", expNodes, len(graphNodes))
 	}
 }
+
+func TestCallgraphAlgorithms(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockInterfacePackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainFqName := pkgName + "/main"
+	specs := []struct {
+		Algorithm    CallGraphAlgorithm
+		ExpNodeNames []string
+	}{
+		{
+			CallGraphRTA,
+			[]string{mainFqName, pkgName + "/measure", pkgName + "/Square.Area", pkgName + "/helper"},
+		},
+		{
+			CallGraphCHA,
+			[]string{mainFqName, pkgName + "/measure", pkgName + "/Square.Area", pkgName + "/Circle.Area", pkgName + "/helper"},
+		},
+		{
+			CallGraphVTA,
+			[]string{mainFqName, pkgName + "/measure", pkgName + "/Square.Area", pkgName + "/helper"},
+		},
+		{
+			CallGraphStatic,
+			[]string{mainFqName, pkgName + "/measure", pkgName + "/helper"},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		target := &ProfileTarget{
+			QualifiedName:      mainFqName,
+			PkgPrefix:          pkgName,
+			CallGraphAlgorithm: spec.Algorithm,
+			ssaFunc:            candidates[mainFqName],
+		}
+
+		graphNodes := target.CallGraph()
+		if len(graphNodes) != len(spec.ExpNodeNames) {
+			t.Errorf("[spec %d] expected %s callgraph from main() to have %d nodes; got %d", specIndex, spec.Algorithm, len(spec.ExpNodeNames), len(graphNodes))
+			continue
+		}
+
+		nodeNames := make(map[string]struct{}, 0)
+		for _, node := range graphNodes {
+			nodeNames[node.Name] = struct{}{}
+		}
+
+		for _, expName := range spec.ExpNodeNames {
+			if _, exists := nodeNames[expName]; !exists {
+				t.Errorf("[spec %d] expected %s callgraph from main() to include %q", specIndex, spec.Algorithm, expName)
+			}
+		}
+	}
+}
+
+func TestCallgraphGenerationWithGenerics(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockGenericPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	candidates, err := ssaCandidates(pkgDir, pkgName, wsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mainFqName := pkgName + "/main"
+	target := &ProfileTarget{
+		QualifiedName: mainFqName,
+		PkgPrefix:     pkgName,
+		ssaFunc:       candidates[mainFqName],
+	}
+
+	graphNodes := target.CallGraph()
+	expGraphNodes := []CallGraphNode{
+		{Name: mainFqName, Depth: 0},
+		{Name: pkgName + "/Set.Add", Depth: 1},
+		{Name: pkgName + "/Pair.Key", Depth: 1},
+		{Name: pkgName + "/Map", Depth: 1},
+	}
+	if len(graphNodes) != len(expGraphNodes) {
+		t.Fatalf("expected callgraph from main() to have %d nodes; got %d", len(expGraphNodes), len(graphNodes))
+	}
+
+	for index, node := range graphNodes {
+		if *node != expGraphNodes[index] {
+			t.Errorf("expected node %d to be %+v; got %+v", index, expGraphNodes[index], *node)
+		}
+	}
+
+	// Instantiations should not be listed as separate candidates
+	for candidate := range candidates {
+		if strings.ContainsAny(candidate, "[]") {
+			t.Errorf("expected candidate names not to include type parameters; got %q", candidate)
+		}
+	}
+}
+
+func TestParseCallGraphAlgorithm(t *testing.T) {
+	specs := []struct {
+		In     string
+		ExpOut CallGraphAlgorithm
+		ExpErr bool
+	}{
+		{"rta", CallGraphRTA, false},
+		{" cha ", CallGraphCHA, false},
+		{"vta", CallGraphVTA, false},
+		{"static", CallGraphStatic, false},
+		{"pointer", 0, true},
+	}
+
+	for specIndex, spec := range specs {
+		algorithm, err := ParseCallGraphAlgorithm(spec.In)
+		if spec.ExpErr {
+			if err == nil {
+				t.Errorf("[spec %d] expected to get an error", specIndex)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if algorithm != spec.ExpOut {
+			t.Errorf("[spec %d] expected parsed algorithm to be %q; got %q", specIndex, spec.ExpOut, algorithm)
+		}
+	}
+}
+
+func mockInterfacePackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
+	pkgName = "prism-mock"
+	src := `
+package main
+
+type Shape interface {
+	Area() int
+}
+
+type Square struct {
+}
+
+func (s *Square) Area() int {
+	return 1
+}
+
+type Circle struct {
+}
+
+// Circle is never instantiated; only CHA should include it in the callgraph
+func (c *Circle) Area() int {
+	return 2
+}
+
+func measure(s Shape) int {
+	return s.Area()
+}
+
+func helper() int {
+	return 0
+}
+
+func main(){
+	measure(&Square{})
+	helper()
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir = workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+
+	err = ioutil.WriteFile(pkgDir+"src.go", []byte(src), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+	}
+
+	return workspaceDir, pkgDir, pkgName
+}
+
+func mockGenericPackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
+	pkgName = "prism-mock"
+	src := `
+package main
+
+type Set[T comparable] struct {
+	m map[T]struct{}
+}
+
+func (s *Set[T]) Add(v T) {
+	s.m[v] = struct{}{}
+}
+
+type Pair[K comparable, V any] struct {
+	k K
+	v V
+}
+
+func (p *Pair[K, V]) Key() K {
+	return p.k
+}
+
+func Map[T, U any](in []T, fn func(T) U) []U {
+	out := make([]U, 0, len(in))
+	for _, v := range in {
+		out = append(out, fn(v))
+	}
+	return out
+}
+
+func main(){
+	// Both instantiations should be reported as Set.Add
+	intSet := &Set[int]{m: map[int]struct{}{}}
+	intSet.Add(1)
+	strSet := &Set[string]{m: map[string]struct{}{}}
+	strSet.Add("foo")
+
+	pair := &Pair[string, []int]{}
+	pair.Key()
+
+	Map([]int{1}, func(v int) string { return "" })
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir = workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+
+	err = ioutil.WriteFile(pkgDir+"src.go", []byte(src), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+	}
+
+	return workspaceDir, pkgDir, pkgName
+}
+
+func mockShortcutPackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
+	pkgName = "prism-mock"
+	src := `
+package main
+
+func C() {
+}
+
+func B() {
+	C()
+}
+
+func Y() {
+	B()
+}
+
+func X() {
+	Y()
+}
+
+func T() {
+	X()
+	B()
+}
+
+func main(){
+	T()
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir = workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+
+	err = ioutil.WriteFile(pkgDir+"src.go", []byte(src), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+	}
+
+	return workspaceDir, pkgDir, pkgName
+}
@@ -0,0 +1,68 @@
+package tools
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+const (
+	// A comment directive for marking a function as a profile target.
+	profileDirective = "//prism:profile"
+
+	// A comment directive for preventing a function from being hooked.
+	ignoreDirective = "//prism:ignore"
+)
+
+// Directives scans the package sources for functions whose doc comments
+// include a prism directive and returns the sorted FQ names of the functions
+// marked with a //prism:profile and a //prism:ignore directive respectively.
+// Vendored packages are only scanned if they match one of the vendorPkgRegex
+// entries.
+func (pkg *GoPackage) Directives(vendorPkgRegex []string) (profileFuncs, ignoredFuncs []string, err error) {
+	parsedFiles, err := parsePackageSources(pkg.pathToPackage, pkg.PkgPrefix, vendorPkgRegex)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	profileFuncs = make([]string, 0)
+	ignoredFuncs = make([]string, 0)
+	for _, parsedFile := range parsedFiles {
+		for _, decl := range parsedFile.astFile.Decls {
+			fnDecl, isFnDecl := decl.(*ast.FuncDecl)
+			if !isFnDecl || fnDecl.Body == nil {
+				continue
+			}
+
+			fqName := qualifiedNodeName(fnDecl, parsedFile.pkgName)
+			if hasDirective(fnDecl.Doc, profileDirective) {
+				profileFuncs = append(profileFuncs, fqName)
+			}
+			if hasDirective(fnDecl.Doc, ignoreDirective) {
+				ignoredFuncs = append(ignoredFuncs, fqName)
+			}
+		}
+	}
+
+	sort.Strings(profileFuncs)
+	sort.Strings(ignoredFuncs)
+	return profileFuncs, ignoredFuncs, nil
+}
+
+// Check whether a comment group contains the given directive. Like go
+// directives, prism directives must start at the beginning of the comment
+// without any spaces after the comment marker.
+func hasDirective(doc *ast.CommentGroup, directive string) bool {
+	if doc == nil {
+		return false
+	}
+
+	for _, comment := range doc.List {
+		text := strings.TrimRight(comment.Text, " \t")
+		if text == directive || strings.HasPrefix(text, directive+" ") {
+			return true
+		}
+	}
+
+	return false
+}
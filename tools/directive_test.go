@@ -0,0 +1,168 @@
+package tools
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDirectives(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockDirectivePackage(t)
+	defer os.RemoveAll(wsDir)
+
+	pkg, err := NewGoPackage(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profileFuncs, ignoredFuncs, err := pkg.Directives(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expProfileFuncs := []string{pkgName + "/A.DoStuff", pkgName + "/DoStuff"}
+	if len(profileFuncs) != len(expProfileFuncs) {
+		t.Fatalf("expected to get %d profile directives; got %d", len(expProfileFuncs), len(profileFuncs))
+	}
+	for index, expName := range expProfileFuncs {
+		if profileFuncs[index] != expName {
+			t.Errorf("expected profile directive %d to be %q; got %q", index, expName, profileFuncs[index])
+		}
+	}
+
+	expIgnoredFuncs := []string{pkgName + "/log"}
+	if len(ignoredFuncs) != len(expIgnoredFuncs) {
+		t.Fatalf("expected to get %d ignore directives; got %d", len(expIgnoredFuncs), len(ignoredFuncs))
+	}
+	for index, expName := range expIgnoredFuncs {
+		if ignoredFuncs[index] != expName {
+			t.Errorf("expected ignore directive %d to be %q; got %q", index, expName, ignoredFuncs[index])
+		}
+	}
+
+	// Ignored functions should be omitted from the callgraph but any
+	// functions reachable through them should still be included
+	targets, err := pkg.Find(pkgName + "/DoStuff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets[0].Ignore = ignoredFuncs
+
+	expGraphNodes := []CallGraphNode{
+		{Name: pkgName + "/DoStuff", Depth: 0},
+		{Name: pkgName + "/A.DoStuff", Depth: 1},
+		{Name: pkgName + "/format", Depth: 3},
+	}
+	graphNodes := targets[0].CallGraph()
+	if len(graphNodes) != len(expGraphNodes) {
+		t.Fatalf("expected callgraph to have %d nodes; got %d", len(expGraphNodes), len(graphNodes))
+	}
+	for index, node := range graphNodes {
+		if *node != expGraphNodes[index] {
+			t.Errorf("expected node %d to be %+v; got %+v", index, expGraphNodes[index], *node)
+		}
+	}
+}
+
+func TestHasDirective(t *testing.T) {
+	src := `
+package foo
+
+//prism:profile
+func A(){}
+
+// Some docs
+//
+//prism:profile some trailing text
+func B(){}
+
+// prism:profile
+func C(){}
+
+//prism:profiler
+func D(){}
+
+func E(){}
+`
+
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expResults := map[string]bool{
+		"A": true,
+		"B": true,
+		"C": false,
+		"D": false,
+		"E": false,
+	}
+
+	for _, decl := range astFile.Decls {
+		fnDecl := decl.(*ast.FuncDecl)
+		result := hasDirective(fnDecl.Doc, profileDirective)
+		if result != expResults[fnDecl.Name.Name] {
+			t.Errorf("expected hasDirective for func %s to return %t; got %t", fnDecl.Name.Name, expResults[fnDecl.Name.Name], result)
+		}
+	}
+}
+
+func mockDirectivePackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
+	pkgName = "prism-mock"
+	src := `
+package main
+
+type A struct {
+}
+
+//prism:profile
+func (a *A) DoStuff(){
+	log("foo")
+}
+
+// DoStuff does stuff.
+//
+//prism:profile
+func DoStuff(){
+	a := &A{}
+	a.DoStuff()
+}
+
+//prism:ignore
+func log(msg string){
+	format(msg)
+}
+
+func format(msg string) string {
+	return msg
+}
+
+func main(){
+	DoStuff()
+}
+`
+
+	workspaceDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkgDir = workspaceDir + "/src/" + pkgName + "/"
+	err = os.MkdirAll(pkgDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating workspace folder for package %q: %s", pkgName, err)
+	}
+
+	err = ioutil.WriteFile(pkgDir+"src.go", []byte(src), os.ModePerm)
+	if err != nil {
+		os.RemoveAll(workspaceDir)
+		t.Fatalf("error creating package contents for package %q: %s", pkgName, err)
+	}
+
+	return workspaceDir, pkgDir, pkgName
+}
@@ -1,37 +1,156 @@
 package tools
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/printer"
 	"go/token"
+	"go/types"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
 )
 
+// The name of the flag used by the injected hooks for detecting panics.
+const panicFlagName = "prismPanicking"
+
 var (
 	profilerImports = []string{"prismProfiler github.com/geckoboard/prism/profiler"}
 	sinkImports     = []string{"prismSink github.com/geckoboard/prism/profiler/sink"}
+
+	// Builtin functions cannot be used as function values so go statements
+	// invoking them cannot be linked to the active profile.
+	builtinFuncs = map[string]struct{}{
+		"append": {}, "cap": {}, "clear": {}, "close": {}, "complex": {},
+		"copy": {}, "delete": {}, "imag": {}, "len": {}, "make": {},
+		"max": {}, "min": {}, "new": {}, "panic": {}, "print": {},
+		"println": {}, "real": {}, "recover": {},
+	}
 )
 
+// ProfilerOptions defines the profiler options that are passed to the
+// profiler init code injected by the bootstrap PatchFuncs.
+type ProfilerOptions struct {
+	// The URI of the sink for captured profiles (see sink.Open). If empty,
+	// captured profiles are saved to the profile dir. In both cases, the
+	// sink can be overridden at runtime via the PRISM_SINK env var.
+	ProfileSink string
+
+	// Track the heap allocations performed by each profiled call.
+	TrackAllocs bool
+
+	// Only track allocations in 1 in TrackAllocsEvery profiles of each
+	// profile target. Values greater than 1 imply TrackAllocs.
+	TrackAllocsEvery int
+
+	// Track the CPU time consumed by each profiled call.
+	TrackCPUTime bool
+
+	// Only profile 1 in SampleEvery invocations of each profile target.
+	// Values less than 2 disable this setting.
+	SampleEvery int
+
+	// The max number of profiles per second for each profile target. A
+	// zero value disables the limit.
+	MaxProfilesPerSecond int
+
+	// The max number of profiles in total for each profile target. A zero
+	// value disables the limit.
+	MaxProfiles int
+
+	// The file for caching the calibrated profiler overheads. If empty, the
+	// profiler uses its default cache location.
+	CalibrationCache string
+
+	// Disable the calibration cache.
+	NoCalibrationCache bool
+
+	// Estimate the profiler overheads using the median of a set of
+	// calibration batches.
+	MedianCalibration bool
+}
+
+// Generate the sink argument for the profiler Init call.
+func (opts ProfilerOptions) sinkArg(profileDir string) string {
+	// Sink URIs without a scheme are treated as folder paths
+	sinkURI := opts.ProfileSink
+	if sinkURI == "" {
+		sinkURI = profileDir
+	}
+	return fmt.Sprintf("prismSink.MustOpenEnv(%q)", sinkURI)
+}
+
+// Generate the option arguments for the profiler Init call.
+func (opts ProfilerOptions) initArgs() string {
+	var args string
+	if opts.TrackAllocsEvery > 1 {
+		args += fmt.Sprintf(", prismProfiler.TrackAllocsEvery(%d)", opts.TrackAllocsEvery)
+	} else if opts.TrackAllocs {
+		args += ", prismProfiler.TrackAllocs()"
+	}
+	if opts.TrackCPUTime {
+		args += ", prismProfiler.TrackCPUTime()"
+	}
+	if opts.SampleEvery > 1 {
+		args += fmt.Sprintf(", prismProfiler.SampleEvery(%d)", opts.SampleEvery)
+	}
+	if opts.MaxProfilesPerSecond > 0 {
+		args += fmt.Sprintf(", prismProfiler.MaxProfilesPerSecond(%d)", opts.MaxProfilesPerSecond)
+	}
+	if opts.MaxProfiles > 0 {
+		args += fmt.Sprintf(", prismProfiler.MaxProfiles(%d)", opts.MaxProfiles)
+	}
+	if opts.NoCalibrationCache {
+		args += ", prismProfiler.NoCalibrationCache()"
+	} else if opts.CalibrationCache != "" {
+		args += fmt.Sprintf(", prismProfiler.CalibrationCache(%q)", opts.CalibrationCache)
+	}
+	if opts.MedianCalibration {
+		args += ", prismProfiler.MedianCalibration()"
+	}
+
+	return args
+}
+
 // InjectProfilerBootstrap returns a PatchFunc that injects our profiler init code the main function of the target package.
-func InjectProfilerBootstrap(profileDir, profileLabel string) PatchFunc {
-	return func(cgNode *CallGraphNode, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+func InjectProfilerBootstrap(profileDir, profileLabel string, opts ProfilerOptions) PatchFunc {
+	return func(cgNode *CallGraphNode, fnType *ast.FuncType, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 		imports := append(profilerImports, sinkImports...)
 		fnDeclNode.List = append(
-			[]ast.Stmt{
-				&ast.ExprStmt{
-					X: &ast.BasicLit{
-						ValuePos: token.NoPos,
-						Kind:     token.STRING,
-						Value:    fmt.Sprintf("prismProfiler.Init(prismSink.NewFileSink(%q), %q)", profileDir, profileLabel),
-					},
-				},
-				&ast.ExprStmt{
-					X: &ast.BasicLit{
+			profilerBootstrapStmts(profileDir, profileLabel, opts),
+			fnDeclNode.List...,
+		)
+
+		return true, imports
+	}
+}
+
+// InjectTestMainBootstrap returns a PatchFunc that injects our profiler init
+// code to the TestMain function of a test package. As TestMain functions
+// typically exit via os.Exit, which skips deferred calls, the exit code passed
+// to any os.Exit call is also wrapped by a function that shuts down the
+// profiler before the process exits.
+func InjectTestMainBootstrap(profileDir, profileLabel string, opts ProfilerOptions) PatchFunc {
+	return func(cgNode *CallGraphNode, fnType *ast.FuncType, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		imports := append(profilerImports, sinkImports...)
+
+		ast.Inspect(fnDeclNode, func(node ast.Node) bool {
+			if call, isCall := node.(*ast.CallExpr); isCall && isOsExitCall(call) && len(call.Args) == 1 {
+				call.Args[0] = &ast.CallExpr{
+					Fun: &ast.BasicLit{
 						ValuePos: token.NoPos,
 						Kind:     token.STRING,
-						Value:    `defer prismProfiler.Shutdown()`,
+						Value:    `func(code int) int { prismProfiler.Shutdown(); return code }`,
 					},
-				},
-			},
+					Args: []ast.Expr{call.Args[0]},
+				}
+			}
+			return true
+		})
+
+		fnDeclNode.List = append(
+			profilerBootstrapStmts(profileDir, profileLabel, opts),
 			fnDeclNode.List...,
 		)
 
@@ -39,15 +158,72 @@ func InjectProfilerBootstrap(profileDir, profileLabel string) PatchFunc {
 	}
 }
 
+// Generate the statements for initializing the profiler and shutting it
+// down when the enclosing function returns.
+func profilerBootstrapStmts(profileDir, profileLabel string, opts ProfilerOptions) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.ExprStmt{
+			X: &ast.BasicLit{
+				ValuePos: token.NoPos,
+				Kind:     token.STRING,
+				Value:    fmt.Sprintf("prismProfiler.Init(%s, %q%s)", opts.sinkArg(profileDir), profileLabel, opts.initArgs()),
+			},
+		},
+		&ast.ExprStmt{
+			X: &ast.BasicLit{
+				ValuePos: token.NoPos,
+				Kind:     token.STRING,
+				Value:    `defer prismProfiler.Shutdown()`,
+			},
+		},
+	}
+}
+
+// Check whether a call expression invokes os.Exit.
+func isOsExitCall(call *ast.CallExpr) bool {
+	selector, isSelector := call.Fun.(*ast.SelectorExpr)
+	if !isSelector || selector.Sel.Name != "Exit" {
+		return false
+	}
+
+	pkgIdent, isIdent := selector.X.(*ast.Ident)
+	return isIdent && pkgIdent.Name == "os"
+}
+
 // InjectProfiler returns a PatchFunc that injects our profiler instrumentation code in all
 // functions that are reachable from the profile targets that the user specified.
+//
+// The deferred exit hook detects panics via a flag that is set when the
+// function is entered and cleared by its return statements. This way panics
+// are never recovered and keep their original stack trace.
+//
+// Any go statements in the patched functions are also rewritten so that the
+// spawned goroutines are linked to the profile of the spawning goroutine. For
+// functions whose last result is an error, the results are named if needed so
+// that the deferred exit hook can report whether a non-nil error was returned.
 func InjectProfiler() PatchFunc {
-	return func(cgNode *CallGraphNode, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
-		enterFn, leaveFn := profileFnName(cgNode.Depth)
+	return func(cgNode *CallGraphNode, fnType *ast.FuncType, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		errResult := errorResultName(fnType)
+		enterFn, leaveFn := profileFnName(cgNode.Depth, errResult != "")
+
+		leaveArgs := panicFlagName
+		if errResult != "" {
+			leaveArgs += ", " + errResult
+		}
+
+		linkGoStmts(fnDeclNode, cgNode.closureNames(), cgNode.lookupObject)
+		clearPanicFlag(fnType, fnDeclNode)
 
 		// Append our instrumentation calls to the top of the function
 		fnDeclNode.List = append(
 			[]ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.BasicLit{
+						ValuePos: token.NoPos,
+						Kind:     token.STRING,
+						Value:    panicFlagName + " := true",
+					},
+				},
 				&ast.ExprStmt{
 					X: &ast.BasicLit{
 						ValuePos: token.NoPos,
@@ -59,7 +235,7 @@ func InjectProfiler() PatchFunc {
 					X: &ast.BasicLit{
 						ValuePos: token.NoPos,
 						Kind:     token.STRING,
-						Value:    fmt.Sprintf(`defer prismProfiler.%s()`, leaveFn),
+						Value:    fmt.Sprintf(`defer func() { prismProfiler.%s(%s) }()`, leaveFn, leaveArgs),
 					},
 				},
 			},
@@ -70,13 +246,325 @@ func InjectProfiler() PatchFunc {
 	}
 }
 
+// Rewrite the return statements of a function body so that they clear the
+// panic flag checked by the deferred exit hook. Return statements inside
+// anonymous functions are not rewritten. The flag is only cleared once the
+// returned values have been evaluated; returned values that may panic are
+// evaluated into temporaries first. For example, return fn(x), nil is
+// rewritten as:
+//
+//	{
+//		var prismRet0 int
+//		var prismRet1 error
+//		prismRet0, prismRet1 = fn(x), nil
+//		prismPanicking = false
+//		return prismRet0, prismRet1
+//	}
+//
+// Functions without results that do not end with a return statement also
+// clear the flag at the end of their body.
+func clearPanicFlag(fnType *ast.FuncType, body *ast.BlockStmt) {
+	clearStmt := func() ast.Stmt {
+		return &ast.ExprStmt{
+			X: &ast.BasicLit{
+				ValuePos: token.NoPos,
+				Kind:     token.STRING,
+				Value:    panicFlagName + " = false",
+			},
+		}
+	}
+
+	resultTypes := make([]string, 0)
+	if fnType != nil && fnType.Results != nil {
+		for _, result := range fnType.Results.List {
+			var buf bytes.Buffer
+			printer.Fprint(&buf, token.NewFileSet(), result.Type)
+			for count := 0; count < len(result.Names) || count == 0; count++ {
+				resultTypes = append(resultTypes, buf.String())
+			}
+		}
+	}
+
+	astutil.Apply(body, func(c *astutil.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			if !mayPanicOnReturn(n) {
+				if c.Index() >= 0 {
+					c.InsertBefore(clearStmt())
+				} else {
+					c.Replace(&ast.BlockStmt{List: []ast.Stmt{clearStmt(), n}})
+				}
+				return false
+			}
+
+			block := &ast.BlockStmt{}
+			assignStmt := &ast.AssignStmt{Tok: token.ASSIGN, Rhs: n.Results}
+			retStmt := &ast.ReturnStmt{}
+			for index, resultType := range resultTypes {
+				tmpName := fmt.Sprintf("prismRet%d", index)
+				block.List = append(block.List, &ast.ExprStmt{
+					X: &ast.BasicLit{
+						ValuePos: token.NoPos,
+						Kind:     token.STRING,
+						Value:    fmt.Sprintf("var %s %s", tmpName, resultType),
+					},
+				})
+				assignStmt.Lhs = append(assignStmt.Lhs, ast.NewIdent(tmpName))
+				retStmt.Results = append(retStmt.Results, ast.NewIdent(tmpName))
+			}
+			block.List = append(block.List, assignStmt, clearStmt(), retStmt)
+			c.Replace(block)
+			return false
+		}
+		return true
+	}, nil)
+
+	if len(resultTypes) != 0 {
+		return
+	}
+	if len(body.List) != 0 {
+		if _, isReturn := body.List[len(body.List)-1].(*ast.ReturnStmt); isReturn {
+			return
+		}
+	}
+	body.List = append(body.List, clearStmt())
+}
+
+// Check whether evaluating the values returned by a return statement may
+// panic. This is assumed to be the case unless all values are identifiers
+// or literals.
+func mayPanicOnReturn(retStmt *ast.ReturnStmt) bool {
+	for _, result := range retStmt.Results {
+		switch result.(type) {
+		case *ast.Ident, *ast.BasicLit:
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the name of the error result for a function whose last result is an
+// error. If the function results are not named, they are named after their
+// position (prismResult0, prismResult1, ...) with the error result being
+// named prismErr. A blank error result is also renamed to prismErr. Returns
+// an empty string if the last result of the function is not an error.
+func errorResultName(fnType *ast.FuncType) string {
+	if fnType == nil || fnType.Results == nil || len(fnType.Results.List) == 0 {
+		return ""
+	}
+
+	results := fnType.Results.List
+	lastResult := results[len(results)-1]
+
+	// Skip functions returning a locally declared type named error
+	typeIdent, isIdent := lastResult.Type.(*ast.Ident)
+	if !isIdent || typeIdent.Name != "error" || typeIdent.Obj != nil {
+		return ""
+	}
+
+	// Results are either all named or all unnamed
+	if len(lastResult.Names) != 0 {
+		errIdent := lastResult.Names[len(lastResult.Names)-1]
+		if errIdent.Name == "_" {
+			errIdent.Name = "prismErr"
+		}
+		return errIdent.Name
+	}
+
+	for index, result := range results {
+		result.Names = []*ast.Ident{ast.NewIdent(fmt.Sprintf("prismResult%d", index))}
+	}
+	lastResult.Names[0].Name = "prismErr"
+
+	return "prismErr"
+}
+
+// Rewrite the go statements in a function body so that the spawned goroutines
+// are linked to the active profile. For example, go fn(x) is rewritten as:
+//
+//	{
+//		prismArg0, prismAsync := x, prismProfiler.Fork("go fn")
+//		go func() {
+//			prismProfiler.BeginAsync(prismAsync)
+//			defer prismProfiler.EndAsync(prismAsync)
+//			fn(prismArg0)
+//		}()
+//	}
+//
+// To preserve the semantics of the go statement, the spawned function value
+// and its arguments are evaluated by the spawning goroutine. Go statements
+// inside anonymous functions are not rewritten as anonymous functions are
+// patched separately. Spawned anonymous functions are named after their entry
+// in closureNames. The package-level objects referenced by the go statements
+// are resolved via lookupObject.
+func linkGoStmts(body *ast.BlockStmt, closureNames map[*ast.FuncLit]string, lookupObject func(ast.Expr) types.Object) {
+	astutil.Apply(body, func(c *astutil.Cursor) bool {
+		switch n := c.Node().(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.GoStmt:
+			if asyncFnName, canLink := goStmtFnName(n.Call.Fun, closureNames); canLink {
+				c.Replace(linkedGoStmt(n, asyncFnName, lookupObject))
+			}
+			return false
+		}
+		return true
+	}, nil)
+}
+
+// Generate a block statement that links the goroutine spawned by goStmt to
+// the active profile.
+func linkedGoStmt(goStmt *ast.GoStmt, asyncFnName string, lookupObject func(ast.Expr) types.Object) ast.Stmt {
+	call := goStmt.Call
+	assignStmt := &ast.AssignStmt{Tok: token.DEFINE}
+	bind := func(name string, expr ast.Expr) ast.Expr {
+		assignStmt.Lhs = append(assignStmt.Lhs, ast.NewIdent(name))
+		assignStmt.Rhs = append(assignStmt.Rhs, expr)
+		return ast.NewIdent(name)
+	}
+
+	fn := call.Fun
+	if _, isFuncLit := fn.(*ast.FuncLit); !isFuncLit && !isStableExpr(fn, lookupObject) {
+		fn = bind("prismFn", fn)
+	}
+
+	args := make([]ast.Expr, len(call.Args))
+	for argIndex, arg := range call.Args {
+		if isStableExpr(arg, lookupObject) {
+			args[argIndex] = arg
+			continue
+		}
+		args[argIndex] = bind(fmt.Sprintf("prismArg%d", argIndex), arg)
+	}
+
+	bind("prismAsync", &ast.BasicLit{
+		ValuePos: token.NoPos,
+		Kind:     token.STRING,
+		Value:    fmt.Sprintf("prismProfiler.Fork(%s)", strconv.Quote("go "+asyncFnName)),
+	})
+
+	spawnedFn := &ast.FuncLit{
+		Type: &ast.FuncType{Params: &ast.FieldList{}},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.BasicLit{
+						ValuePos: token.NoPos,
+						Kind:     token.STRING,
+						Value:    "prismProfiler.BeginAsync(prismAsync)",
+					},
+				},
+				&ast.ExprStmt{
+					X: &ast.BasicLit{
+						ValuePos: token.NoPos,
+						Kind:     token.STRING,
+						Value:    "defer prismProfiler.EndAsync(prismAsync)",
+					},
+				},
+				&ast.ExprStmt{
+					X: &ast.CallExpr{Fun: fn, Args: args, Ellipsis: call.Ellipsis},
+				},
+			},
+		},
+	}
+
+	return &ast.BlockStmt{
+		List: []ast.Stmt{
+			assignStmt,
+			&ast.GoStmt{Call: &ast.CallExpr{Fun: spawnedFn}},
+		},
+	}
+}
+
+// Get a descriptive name for the function invoked by a go statement.
+// Anonymous functions are named after their entry in closureNames. Returns
+// false for go statements invoking builtin functions or anonymous functions
+// without a name.
+func goStmtFnName(fnExpr ast.Expr, closureNames map[*ast.FuncLit]string) (string, bool) {
+	switch expr := fnExpr.(type) {
+	case *ast.FuncLit:
+		fqName, found := closureNames[expr]
+		return fqName, found
+	case *ast.Ident:
+		if _, isBuiltin := builtinFuncs[expr.Name]; isBuiltin && expr.Obj == nil {
+			return "", false
+		}
+	case *ast.ParenExpr:
+		return goStmtFnName(expr.X, closureNames)
+	}
+
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), fnExpr); err != nil {
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// Check whether an expression can be evaluated by a spawned goroutine instead
+// of the spawning goroutine without affecting its value. This is the case for
+// literals, constants, functions and types. As the parser only resolves
+// identifiers declared in the same file, unresolved and package-qualified
+// identifiers are resolved via lookupObject; identifiers that cannot be
+// resolved are not considered to be stable.
+//
+// Go statement arguments that are not stable (including package-level
+// variables) are bound to variables by the spawning goroutine. Stable
+// arguments are never bound as binding an untyped constant would change
+// its type.
+func isStableExpr(expr ast.Expr, lookupObject func(ast.Expr) types.Object) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident:
+		if e.Obj != nil {
+			return e.Obj.Kind == ast.Con || e.Obj.Kind == ast.Fun || e.Obj.Kind == ast.Typ
+		}
+		return isStableObject(lookupObject(e))
+	case *ast.SelectorExpr:
+		if pkgIdent, isIdent := e.X.(*ast.Ident); isIdent && pkgIdent.Obj == nil {
+			return isStableObject(lookupObject(e))
+		}
+	case *ast.ParenExpr:
+		return isStableExpr(e.X, lookupObject)
+	case *ast.UnaryExpr:
+		return e.Op != token.AND && e.Op != token.ARROW && isStableExpr(e.X, lookupObject)
+	case *ast.BinaryExpr:
+		return isStableExpr(e.X, lookupObject) && isStableExpr(e.Y, lookupObject)
+	}
+
+	return false
+}
+
+// Check whether a package-level object is a constant, a function or a type.
+func isStableObject(obj types.Object) bool {
+	switch obj.(type) {
+	case *types.Const, *types.Func, *types.TypeName, *types.Nil:
+		return true
+	}
+
+	return false
+}
+
 // Return the appropriate profiler enter/exit function names depending on whether
 // a profile target is a user-specified target (depth=0) or a target discovered
-// by analyzing the callgraph from a user-specified target.
-func profileFnName(depth int) (enterFn, leaveFn string) {
+// by analyzing the callgraph from a user-specified target. The exit functions
+// are invoked with a flag indicating whether the call is being unwound by a
+// panic. For functions returning an error, the exit functions also receive
+// the returned error.
+func profileFnName(depth int, returnsError bool) (enterFn, leaveFn string) {
 	if depth == 0 {
-		return "BeginProfile", "EndProfile"
+		if returnsError {
+			return "BeginProfile", "EndProfileWithError"
+		}
+		return "BeginProfile", "EndProfileWithPanic"
 	}
 
-	return "Enter", "Leave"
+	if returnsError {
+		return "Enter", "LeaveWithError"
+	}
+	return "Enter", "LeaveWithPanic"
 }
@@ -1,15 +1,22 @@
 package tools
 
 import (
+	"bytes"
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"strings"
 	"testing"
 )
 
 func TestInjectProfilerBootstrap(t *testing.T) {
 	profileDir := "/tmp/foo"
 	profileLabel := "label"
-	injectFn := InjectProfilerBootstrap(profileDir, profileLabel)
+	injectFn := InjectProfilerBootstrap(profileDir, profileLabel, ProfilerOptions{})
 
 	cgNode := &CallGraphNode{
 		Name:  "main",
@@ -20,7 +27,7 @@ func TestInjectProfilerBootstrap(t *testing.T) {
 		List: make([]ast.Stmt, 0),
 	}
 
-	modifiedAST, extraImports := injectFn(cgNode, stmt)
+	modifiedAST, extraImports := injectFn(cgNode, &ast.FuncType{Params: &ast.FieldList{}}, stmt)
 
 	if !modifiedAST {
 		t.Fatal("expected injector to modify the AST")
@@ -37,7 +44,7 @@ func TestInjectProfilerBootstrap(t *testing.T) {
 	}
 
 	expStmts := []string{
-		fmt.Sprintf("prismProfiler.Init(prismSink.NewFileSink(%q), %q)", profileDir, profileLabel),
+		fmt.Sprintf("prismProfiler.Init(prismSink.MustOpenEnv(%q), %q)", profileDir, profileLabel),
 		"defer prismProfiler.Shutdown()",
 	}
 	for stmtIndex, expStmt := range expStmts {
@@ -53,6 +60,69 @@ func TestInjectProfilerBootstrap(t *testing.T) {
 	}
 }
 
+func TestProfilerOptionsSinkArg(t *testing.T) {
+	specs := []struct {
+		Opts   ProfilerOptions
+		ExpArg string
+	}{
+		{ProfilerOptions{}, `prismSink.MustOpenEnv("/tmp/foo")`},
+		{ProfilerOptions{ProfileSink: "http://localhost:8080/profiles"}, `prismSink.MustOpenEnv("http://localhost:8080/profiles")`},
+		{ProfilerOptions{ProfileSink: "multi:file:///tmp/bar,discard:"}, `prismSink.MustOpenEnv("multi:file:///tmp/bar,discard:")`},
+	}
+
+	for specIndex, spec := range specs {
+		if arg := spec.Opts.sinkArg("/tmp/foo"); arg != spec.ExpArg {
+			t.Errorf("[spec %d] expected sink arg to be %q; got %q", specIndex, spec.ExpArg, arg)
+		}
+	}
+}
+
+func TestInjectTestMainBootstrap(t *testing.T) {
+	profileDir := "/tmp/foo"
+	profileLabel := "label"
+	injectFn := InjectTestMainBootstrap(profileDir, profileLabel, ProfilerOptions{TrackAllocs: true, TrackCPUTime: true, SampleEvery: 10, MaxProfiles: 5, CalibrationCache: "/tmp/calibration.json", MedianCalibration: true})
+
+	cgNode := &CallGraphNode{
+		Name:  "TestMain",
+		Depth: 0,
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", "package foo\n\nfunc TestMain(m *testing.M){\n\tos.Exit(m.Run())\n}\n", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fnDecl := f.Decls[0].(*ast.FuncDecl)
+
+	modifiedAST, extraImports := injectFn(cgNode, fnDecl.Type, fnDecl.Body)
+
+	if !modifiedAST {
+		t.Fatal("expected injector to modify the AST")
+	}
+
+	expImports := append(profilerImports, sinkImports...)
+	if !importsMatch(extraImports, expImports) {
+		t.Fatalf("injector did not return the expected imports; got %v", extraImports)
+	}
+
+	var buf bytes.Buffer
+	err = printer.Fprint(&buf, fset, fnDecl.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expStmts := []string{
+		fmt.Sprintf("prismProfiler.Init(prismSink.MustOpenEnv(%q), %q, prismProfiler.TrackAllocs(), prismProfiler.TrackCPUTime(), prismProfiler.SampleEvery(10), prismProfiler.MaxProfiles(5), prismProfiler.CalibrationCache(\"/tmp/calibration.json\"), prismProfiler.MedianCalibration())", profileDir, profileLabel),
+		"defer prismProfiler.Shutdown()",
+		"os.Exit(func(code int) int { prismProfiler.Shutdown(); return code }(m.Run()))",
+	}
+	for stmtIndex, expStmt := range expStmts {
+		if !strings.Contains(buf.String(), expStmt) {
+			t.Errorf("[stmt %d] expected patched function to contain %q; got:\n%s", stmtIndex, expStmt, buf.String())
+		}
+	}
+}
+
 func TestInjectProfiler(t *testing.T) {
 	injectFn := InjectProfiler()
 
@@ -65,7 +135,7 @@ func TestInjectProfiler(t *testing.T) {
 		List: make([]ast.Stmt, 0),
 	}
 
-	modifiedAST, extraImports := injectFn(cgNode, stmt)
+	modifiedAST, extraImports := injectFn(cgNode, &ast.FuncType{Params: &ast.FieldList{}}, stmt)
 
 	if !modifiedAST {
 		t.Fatal("expected injector to modify the AST")
@@ -76,14 +146,16 @@ func TestInjectProfiler(t *testing.T) {
 		t.Fatalf("injector did not return the expected imports; got %v", extraImports)
 	}
 
-	expStmtCount := 2
+	expStmtCount := 4
 	if len(stmt.List) != expStmtCount {
 		t.Fatalf("expected injector to append %d statements; got %d", expStmtCount, len(stmt.List))
 	}
 
 	expStmts := []string{
+		"prismPanicking := true",
 		fmt.Sprintf("prismProfiler.Enter(%q)", cgNode.Name),
-		"defer prismProfiler.Leave()",
+		"defer func() { prismProfiler.LeaveWithPanic(prismPanicking) }()",
+		"prismPanicking = false",
 	}
 	for stmtIndex, expStmt := range expStmts {
 		expr, err := extractExpr(stmt.List[stmtIndex])
@@ -98,19 +170,326 @@ func TestInjectProfiler(t *testing.T) {
 	}
 }
 
+func TestInjectProfilerLinksGoStmts(t *testing.T) {
+	src := `package main
+
+const retries = 3
+
+type server struct{}
+
+func (s *server) run(n int) {}
+
+func work(id int, names ...string) {}
+
+func spawn(servers []*server, names []string) {
+	for i, s := range servers {
+		go s.run(retries)
+		go work(i, names...)
+	}
+	go func() {
+		go work(0)
+	}()
+	go println("builtin")
+}
+`
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fnDecl := f.Decls[len(f.Decls)-1].(*ast.FuncDecl)
+	closure := fnDecl.Body.List[1].(*ast.GoStmt).Call.Fun.(*ast.FuncLit)
+
+	injectFn := InjectProfiler()
+	cgNode := &CallGraphNode{
+		Name:  "main/spawn",
+		Depth: 1,
+		patchCtx: &patchContext{
+			closureNames: map[*ast.FuncLit]string{closure: "main/spawn.func1"},
+		},
+	}
+	injectFn(cgNode, fnDecl.Type, fnDecl.Body)
+
+	var buf bytes.Buffer
+	err = printer.Fprint(&buf, fset, fnDecl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Go statements inside anonymous functions and go statements invoking
+	// builtins should not be rewritten
+	expOutput := `func spawn(servers []*server, names []string) {
+	prismPanicking := true
+	prismProfiler.Enter("main/spawn")
+	defer func() { prismProfiler.LeaveWithPanic(prismPanicking) }()
+	for i, s := range servers {
+		{
+			prismFn, prismAsync := s.run, prismProfiler.Fork("go s.run")
+			go func() {
+				prismProfiler.BeginAsync(prismAsync)
+				defer prismProfiler.EndAsync(prismAsync)
+				prismFn(retries)
+			}()
+		}
+		{
+			prismArg0, prismArg1, prismAsync := i, names, prismProfiler.Fork("go work")
+			go func() {
+				prismProfiler.BeginAsync(prismAsync)
+				defer prismProfiler.EndAsync(prismAsync)
+				work(prismArg0, prismArg1...)
+			}()
+		}
+	}
+	{
+		prismAsync := prismProfiler.Fork("go main/spawn.func1")
+		go func() {
+			prismProfiler.BeginAsync(prismAsync)
+			defer prismProfiler.EndAsync(prismAsync)
+			func() {
+				go work(0)
+			}()
+		}()
+	}
+	go println("builtin")
+	prismPanicking = false
+}`
+
+	if buf.String() != expOutput {
+		t.Fatalf("expected patched function to be:\n%s\n\ngot:\n%s", expOutput, buf.String())
+	}
+}
+
+func TestInjectProfilerClearsPanicFlag(t *testing.T) {
+	src := `package main
+
+func parse(in string) (int, error) {
+	if in == "" {
+		return 0, nil
+	}
+	fn := func() int {
+		return len(in)
+	}
+	return fn(), nil
+}
+
+func visit(items []int) {
+	for _, item := range items {
+		if item < 0 {
+			return
+		}
+	}
+}
+`
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injectFn := InjectProfiler()
+	for _, decl := range f.Decls {
+		fnDecl := decl.(*ast.FuncDecl)
+		injectFn(&CallGraphNode{Name: "main/" + fnDecl.Name.Name, Depth: 1}, fnDecl.Type, fnDecl.Body)
+	}
+
+	var buf bytes.Buffer
+	err = printer.Fprint(&buf, fset, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Returned values that may panic should be evaluated before clearing
+	// the flag and returns inside anonymous functions should not be
+	// rewritten
+	expOutput := `package main
+
+func parse(in string) (prismResult0 int, prismErr error) {
+	prismPanicking := true
+	prismProfiler.Enter("main/parse")
+	defer func() { prismProfiler.LeaveWithError(prismPanicking, prismErr) }()
+	if in == "" {
+		prismPanicking = false
+		return 0, nil
+	}
+	fn := func() int {
+		return len(in)
+	}
+	{
+		var prismRet0 int
+		var prismRet1 error
+		prismRet0, prismRet1 = fn(), nil
+		prismPanicking = false
+		return prismRet0, prismRet1
+	}
+}
+
+func visit(items []int) {
+	prismPanicking := true
+	prismProfiler.Enter("main/visit")
+	defer func() { prismProfiler.LeaveWithPanic(prismPanicking) }()
+	for _, item := range items {
+		if item < 0 {
+			prismPanicking = false
+			return
+		}
+	}
+	prismPanicking = false
+}
+`
+
+	if buf.String() != expOutput {
+		t.Fatalf("expected patched source to be:\n%s\n\ngot:\n%s", expOutput, buf.String())
+	}
+}
+
+func TestInjectProfilerReportsErrors(t *testing.T) {
+	src := `package main
+
+func unnamed() (int, error) {
+	return 0, nil
+}
+
+func named() (n int, err error) {
+	return
+}
+
+func blank() (_ int, _ error) {
+	return 0, nil
+}
+
+func noError() int {
+	return 0
+}
+`
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	injectFn := InjectProfiler()
+	for _, decl := range f.Decls {
+		fnDecl := decl.(*ast.FuncDecl)
+		injectFn(&CallGraphNode{Name: "main/" + fnDecl.Name.Name, Depth: 1}, fnDecl.Type, fnDecl.Body)
+	}
+
+	var buf bytes.Buffer
+	err = printer.Fprint(&buf, fset, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expOutput := `package main
+
+func unnamed() (prismResult0 int, prismErr error) {
+	prismPanicking := true
+	prismProfiler.Enter("main/unnamed")
+	defer func() { prismProfiler.LeaveWithError(prismPanicking, prismErr) }()
+	prismPanicking = false
+	return 0, nil
+}
+
+func named() (n int, err error) {
+	prismPanicking := true
+	prismProfiler.Enter("main/named")
+	defer func() { prismProfiler.LeaveWithError(prismPanicking, err) }()
+	prismPanicking = false
+	return
+}
+
+func blank() (_ int, prismErr error) {
+	prismPanicking := true
+	prismProfiler.Enter("main/blank")
+	defer func() { prismProfiler.LeaveWithError(prismPanicking, prismErr) }()
+	prismPanicking = false
+	return 0, nil
+}
+
+func noError() int {
+	prismPanicking := true
+	prismProfiler.Enter("main/noError")
+	defer func() { prismProfiler.LeaveWithPanic(prismPanicking) }()
+	prismPanicking = false
+	return 0
+}
+`
+
+	if buf.String() != expOutput {
+		t.Fatalf("expected patched source to be:\n%s\n\ngot:\n%s", expOutput, buf.String())
+	}
+}
+
+func TestIsStableExpr(t *testing.T) {
+	src := `package main
+
+const local = 1
+
+var fileVar = 1
+
+func fn(param int) {
+	_ = []interface{}{1, "foo", local, other, pkg.Name, -local, local * 2, (local), fn, nil, param, fileVar, param + 1, &param, pkg.Name.Field, call(), otherVar, pkg.Var, unknown}
+}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "src.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fnDecl := f.Decls[len(f.Decls)-1].(*ast.FuncDecl)
+	exprs := fnDecl.Body.List[0].(*ast.AssignStmt).Rhs[0].(*ast.CompositeLit).Elts
+
+	// Mock the package-level objects declared in other files and packages
+	lookupObject := func(expr ast.Expr) types.Object {
+		var name string
+		switch e := expr.(type) {
+		case *ast.Ident:
+			name = e.Name
+		case *ast.SelectorExpr:
+			name = e.X.(*ast.Ident).Name + "." + e.Sel.Name
+		}
+
+		switch name {
+		case "other", "pkg.Name":
+			return types.NewConst(token.NoPos, nil, name, types.Typ[types.UntypedInt], constant.MakeInt64(1))
+		case "otherVar", "pkg.Var":
+			return types.NewVar(token.NoPos, nil, name, types.Typ[types.Int])
+		case "nil":
+			return types.Universe.Lookup(name)
+		}
+		return nil
+	}
+
+	expStable := []bool{true, true, true, true, true, true, true, true, true, true, false, false, false, false, false, false, false, false, false}
+	if len(exprs) != len(expStable) {
+		t.Fatalf("expected %d expressions; got %d", len(expStable), len(exprs))
+	}
+
+	for index, expr := range exprs {
+		if isStable := isStableExpr(expr, lookupObject); isStable != expStable[index] {
+			t.Errorf("[expr %d] expected isStableExpr to return %t; got %t", index, expStable[index], isStable)
+		}
+	}
+}
+
 func TestProfileFnSelection(t *testing.T) {
 	specs := []struct {
-		Depth      int
-		ExpEnterFn string
-		ExpLeaveFn string
+		Depth        int
+		ReturnsError bool
+		ExpEnterFn   string
+		ExpLeaveFn   string
 	}{
-		{0, "BeginProfile", "EndProfile"},
-		{1, "Enter", "Leave"},
-		{2, "Enter", "Leave"},
+		{0, false, "BeginProfile", "EndProfileWithPanic"},
+		{1, false, "Enter", "LeaveWithPanic"},
+		{2, false, "Enter", "LeaveWithPanic"},
+		{0, true, "BeginProfile", "EndProfileWithError"},
+		{1, true, "Enter", "LeaveWithError"},
 	}
 
 	for specIndex, spec := range specs {
-		enterFn, leaveFn := profileFnName(spec.Depth)
+		enterFn, leaveFn := profileFnName(spec.Depth, spec.ReturnsError)
 		if enterFn != spec.ExpEnterFn {
 			t.Errorf("[spec %d] expected enter fn to be %q; got %q", specIndex, spec.ExpEnterFn, enterFn)
 			continue
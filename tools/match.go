@@ -0,0 +1,165 @@
+package tools
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// The prefix used for specifying regex profile targets.
+	regexTargetPrefix = "re:"
+
+	// The max number of candidate names to suggest when a target cannot be matched.
+	maxSuggestedCandidates = 5
+)
+
+// targetMatcher checks whether a FQ function name matches a profile target
+// specification. Targets may be specified as:
+//   - an exact FQ function name, e.g. github.com/geckoboard/foo/Foo.DoStuff
+//   - a glob pattern, e.g. github.com/geckoboard/foo/*.Do*
+//   - a regex prefixed with "re:", e.g. re:.*Repository\..*
+type targetMatcher func(fqName string) bool
+
+// Check whether a target specification is a glob or regex pattern.
+func isTargetPattern(target string) bool {
+	return strings.HasPrefix(target, regexTargetPrefix) || strings.ContainsAny(target, "*?[")
+}
+
+// Create a matcher for the given target specification.
+func newTargetMatcher(target string) (targetMatcher, error) {
+	switch {
+	case strings.HasPrefix(target, regexTargetPrefix):
+		regex, err := regexp.Compile("^(?:" + strings.TrimPrefix(target, regexTargetPrefix) + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("could not compile regex %q: %s", target, err)
+		}
+		return regex.MatchString, nil
+	case isTargetPattern(target):
+		// Validate pattern syntax before using it
+		if _, err := path.Match(target, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %s", target, err)
+		}
+		return func(fqName string) bool {
+			matched, _ := path.Match(target, fqName)
+			return matched
+		}, nil
+	default:
+		return func(fqName string) bool {
+			return fqName == target
+		}, nil
+	}
+}
+
+// ValidatePatterns checks that a list of target specifications can be
+// compiled and returns an error describing the first one that cannot.
+func ValidatePatterns(patterns ...string) error {
+	for _, pattern := range patterns {
+		if _, err := newTargetMatcher(pattern); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Create a matcher that returns true if a FQ function name matches any of the
+// given target specifications. Invalid specifications never match.
+func newMultiTargetMatcher(targets []string) targetMatcher {
+	matchers := make([]targetMatcher, 0, len(targets))
+	for _, target := range targets {
+		if matcher, err := newTargetMatcher(target); err == nil {
+			matchers = append(matchers, matcher)
+		}
+	}
+
+	return func(fqName string) bool {
+		for _, matcher := range matchers {
+			if matcher(fqName) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Return the sorted list of candidate names matched by the given target specification.
+func matchCandidates(target string, candidates []string) ([]string, error) {
+	matcher, err := newTargetMatcher(target)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0)
+	for _, candidate := range candidates {
+		if matcher(candidate) {
+			matches = append(matches, candidate)
+		}
+	}
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// Return up to maxCount candidate names ordered by their edit distance to target.
+func closestCandidates(target string, candidates []string, maxCount int) []string {
+	target = strings.TrimPrefix(target, regexTargetPrefix)
+
+	type scoredCandidate struct {
+		name     string
+		distance int
+	}
+	scored := make([]scoredCandidate, len(candidates))
+	for index, candidate := range candidates {
+		scored[index] = scoredCandidate{candidate, editDistance(target, candidate)}
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].distance != scored[j].distance {
+			return scored[i].distance < scored[j].distance
+		}
+		return scored[i].name < scored[j].name
+	})
+
+	if len(scored) > maxCount {
+		scored = scored[:maxCount]
+	}
+
+	closest := make([]string, len(scored))
+	for index, candidate := range scored {
+		closest[index] = candidate.name
+	}
+	return closest
+}
+
+// Calculate the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prevRow := make([]int, len(b)+1)
+	curRow := make([]int, len(b)+1)
+	for j := range prevRow {
+		prevRow[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curRow[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curRow[j] = minInt(prevRow[j]+1, minInt(curRow[j-1]+1, prevRow[j-1]+cost))
+		}
+		prevRow, curRow = curRow, prevRow
+	}
+
+	return prevRow[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
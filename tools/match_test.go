@@ -0,0 +1,65 @@
+package tools
+
+import "testing"
+
+func TestClosestCandidates(t *testing.T) {
+	candidates := []string{
+		"github.com/foo/bar/Baz.Serve",
+		"github.com/foo/bar/Baz.ServeHTTP",
+		"github.com/foo/bar/main",
+		"github.com/foo/bar/init",
+	}
+
+	closest := closestCandidates("github.com/foo/bar/Baz.Srve", candidates, 2)
+	expClosest := []string{"github.com/foo/bar/Baz.Serve", "github.com/foo/bar/Baz.ServeHTTP"}
+	if len(closest) != len(expClosest) {
+		t.Fatalf("expected to get %d candidates; got %d", len(expClosest), len(closest))
+	}
+
+	for index, candidate := range closest {
+		if candidate != expClosest[index] {
+			t.Errorf("expected candidate %d to be %q; got %q", index, expClosest[index], candidate)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	specs := []struct {
+		A, B        string
+		ExpDistance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"Foo.DoStuff", "Foo.DoStuff", 0},
+	}
+
+	for specIndex, spec := range specs {
+		distance := editDistance(spec.A, spec.B)
+		if distance != spec.ExpDistance {
+			t.Errorf("[spec %d] expected edit distance between %q and %q to be %d; got %d", specIndex, spec.A, spec.B, spec.ExpDistance, distance)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	specs := []struct {
+		Patterns []string
+		ExpErr   bool
+	}{
+		{[]string{}, false},
+		{[]string{"foo/Bar", "foo/*.Do*", "re:.*Bar"}, false},
+		{[]string{"foo/Bar", "re:(unbalanced"}, true},
+		{[]string{"foo/[unbalanced"}, true},
+	}
+
+	for specIndex, spec := range specs {
+		err := ValidatePatterns(spec.Patterns...)
+		if spec.ExpErr && err == nil {
+			t.Errorf("[spec %d] expected to get an error", specIndex)
+		} else if !spec.ExpErr && err != nil {
+			t.Errorf("[spec %d] expected no error; got %v", specIndex, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+const (
+	goModFile = "go.mod"
+
+	// The import path of prism and the folder containing the profiler
+	// package that gets imported by the injected profiler hooks.
+	prismModulePath = "github.com/geckoboard/prism"
+	profilerPkgDir  = "profiler"
+
+	// The version, go language version and folder name used when
+	// cloning the profiler sources next to a cloned module.
+	profilerModuleVersion    = "v0.0.0"
+	defaultProfilerGoVersion = "1.16"
+	profilerCloneDir         = ".prism"
+)
+
+// ProfilerSources provides the sources of the prism profiler package and its
+// sub-packages which are copied next to cloned go modules. The file system
+// must be rooted at the prism repository root. The prism binary populates it
+// with a copy of the sources that is embedded at build time so that profiling
+// go modules does not require a checkout of prism.
+var ProfilerSources fs.FS
+
+var errMissingProfilerSources = errors.New("the prism profiler sources are not available")
+
+// goModule describes the go module that a package belongs to.
+type goModule struct {
+	// The absolute path to the folder containing the go.mod file.
+	root string
+
+	// The module path as declared by the go.mod file.
+	path string
+}
+
+// Walk up the folder hierarchy starting at pathToPackage looking for a go.mod
+// file. If no go.mod file can be found, findModule returns a nil goModule and
+// the package is assumed to live inside a GOPATH workspace.
+func findModule(pathToPackage string) (*goModule, error) {
+	dir, err := filepath.Abs(filepath.Dir(pathToPackage))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		modPath := filepath.Join(dir, goModFile)
+		if info, err := os.Stat(modPath); err == nil && !info.IsDir() {
+			data, err := ioutil.ReadFile(modPath)
+			if err != nil {
+				return nil, err
+			}
+
+			path := modfile.ModulePath(data)
+			if path == "" {
+				return nil, fmt.Errorf("could not detect module path in %s", modPath)
+			}
+
+			return &goModule{
+				root: dir,
+				path: path,
+			}, nil
+		}
+
+		parentDir := filepath.Dir(dir)
+		if parentDir == dir {
+			return nil, nil
+		}
+		dir = parentDir
+	}
+}
+
+// ModuleRoot returns the absolute path to the root folder of the go module
+// containing pathToPackage. If the package does not belong to a go module,
+// ModuleRoot returns an empty string.
+func ModuleRoot(pathToPackage string) (string, error) {
+	mod, err := findModule(pathToPackage)
+	if err != nil || mod == nil {
+		return "", err
+	}
+
+	return mod.root, nil
+}
+
+// PrepareModuleClone updates the go.mod file of a module clone so that it can
+// be built with the injected profiler hooks:
+//
+// Any replace directives that point to a relative path on the local filesystem
+// are rewritten to point to the same location relative to the original module
+// root. This ensures that the cloned module can still be built from a
+// different folder.
+//
+// The sources of the prism profiler packages are copied next to the cloned
+// module and a require/replace directive pair is added so that the imports
+// injected by the profiler hooks can be resolved without fetching prism.
+func PrepareModuleClone(clonedModRoot, origModRoot string) error {
+	modPath := filepath.Join(clonedModRoot, goModFile)
+	profilerDir := filepath.Join(filepath.Dir(clonedModRoot), profilerCloneDir)
+	return patchModFile(modPath, modPath, origModRoot, profilerDir)
+}
+
+// Read the go.mod file at srcPath, pin any relative local replace directives
+// to origModRoot, add the directives for resolving the profiler packages from
+// a copy of their sources at profilerDir and write the result to dstPath.
+func patchModFile(srcPath, dstPath, origModRoot, profilerDir string) error {
+	data, err := ioutil.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+
+	modFile, err := modfile.Parse(srcPath, data, nil)
+	if err != nil {
+		return err
+	}
+
+	// Only local replacements (no version) using relative paths need to be pinned
+	localReplaces := make([]*modfile.Replace, 0)
+	for _, replace := range modFile.Replace {
+		if replace.New.Version == "" && !filepath.IsAbs(replace.New.Path) {
+			localReplaces = append(localReplaces, replace)
+		}
+	}
+
+	for _, replace := range localReplaces {
+		absPath := filepath.Join(origModRoot, replace.New.Path)
+		err = modFile.AddReplace(replace.Old.Path, replace.Old.Version, absPath, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Nothing else to do if we are profiling prism itself
+	if modFile.Module.Mod.Path != prismModulePath {
+		goVersion := defaultProfilerGoVersion
+		if modFile.Go != nil {
+			goVersion = modFile.Go.Version
+		}
+
+		err = cloneProfilerSources(profilerDir, goVersion)
+		if err != nil {
+			return err
+		}
+
+		err = modFile.AddRequire(prismModulePath, profilerModuleVersion)
+		if err != nil {
+			return err
+		}
+		err = modFile.AddReplace(prismModulePath, "", profilerDir, "")
+		if err != nil {
+			return err
+		}
+	}
+
+	modFile.Cleanup()
+	data, err = modFile.Format()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(dstPath, data, os.ModePerm)
+}
+
+// Copy the non-test go and assembly sources of the prism profiler package and
+// its sub-packages from ProfilerSources to dstDir and generate a go.mod file
+// for them.
+func cloneProfilerSources(dstDir, goVersion string) error {
+	if ProfilerSources == nil {
+		return errMissingProfilerSources
+	}
+
+	err := fs.WalkDir(ProfilerSources, profilerPkgDir, func(srcPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		isSource := strings.HasSuffix(srcPath, ".go") || strings.HasSuffix(srcPath, ".s")
+		if entry.IsDir() || !isSource || strings.HasSuffix(srcPath, "_test.go") {
+			return nil
+		}
+
+		dstPath := filepath.Join(dstDir, filepath.FromSlash(srcPath))
+		err = os.MkdirAll(filepath.Dir(dstPath), os.ModeDir|os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		data, err := fs.ReadFile(ProfilerSources, srcPath)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(dstPath, data, os.ModePerm)
+	})
+	if err != nil {
+		return fmt.Errorf("could not copy the sources for %s: %s", path.Join(prismModulePath, profilerPkgDir), err)
+	}
+
+	modData := fmt.Sprintf("module %s\n\ngo %s\n", prismModulePath, goVersion)
+	return ioutil.WriteFile(filepath.Join(dstDir, goModFile), []byte(modData), os.ModePerm)
+}
@@ -0,0 +1,135 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// Use the profiler sources from the repository checkout; the prism
+	// binary embeds the same files at build time
+	ProfilerSources = os.DirFS("..")
+}
+
+func TestFindModule(t *testing.T) {
+	modDir, modName := mockModule(t)
+	defer os.RemoveAll(modDir)
+
+	for _, path := range []string{modDir, modDir + "other/src.go"} {
+		mod, err := findModule(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mod == nil {
+			t.Fatalf("expected to find a module for %q", path)
+		}
+
+		expRoot := strings.TrimSuffix(modDir, "/")
+		if mod.root != expRoot {
+			t.Errorf("expected module root for %q to be %q; got %q", path, expRoot, mod.root)
+		}
+		if mod.path != modName {
+			t.Errorf("expected module path for %q to be %q; got %q", path, modName, mod.path)
+		}
+	}
+}
+
+func TestFindModuleInWorkspace(t *testing.T) {
+	wsDir, pkgDir, _ := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	mod, err := findModule(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod != nil {
+		t.Fatalf("expected not to find a module for package in go workspace; got %v", mod)
+	}
+}
+
+func TestPrepareModuleClone(t *testing.T) {
+	origDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origDir)
+
+	cloneDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cloneDir)
+
+	modData := `module example.com/prism-mock
+
+go 1.16
+
+replace example.com/local => ../local
+
+replace example.com/remote => example.com/fork v1.0.0
+`
+	cloneModDir := filepath.Join(cloneDir, "prism-mock")
+	err = os.MkdirAll(cloneModDir, os.ModeDir|os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(cloneModDir, goModFile), []byte(modData), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = PrepareModuleClone(cloneModDir, origDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(cloneModDir, goModFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	patchedMod := string(data)
+
+	profilerDir := filepath.Join(cloneDir, profilerCloneDir)
+	expDirectives := []string{
+		"example.com/local => " + filepath.Join(filepath.Dir(origDir), "local"),
+		"example.com/remote => example.com/fork v1.0.0",
+		prismModulePath + " " + profilerModuleVersion,
+		prismModulePath + " => " + profilerDir,
+	}
+	for _, expDirective := range expDirectives {
+		if !strings.Contains(patchedMod, expDirective) {
+			t.Errorf("expected patched go.mod to contain %q; got:\n%s", expDirective, patchedMod)
+		}
+	}
+
+	for _, expFile := range []string{goModFile, "profiler/profiler.go", "profiler/goroutine_key_amd64.s", "profiler/sink/file.go"} {
+		if _, err := os.Stat(filepath.Join(profilerDir, expFile)); err != nil {
+			t.Errorf("expected profiler clone to contain %q: %v", expFile, err)
+		}
+	}
+
+	testFiles, _ := filepath.Glob(filepath.Join(profilerDir, "profiler", "*_test.go"))
+	if len(testFiles) != 0 {
+		t.Errorf("expected profiler clone not to contain any test files; got %v", testFiles)
+	}
+}
+
+func TestCloneProfilerSourcesWithoutSources(t *testing.T) {
+	dstDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	prevSources := ProfilerSources
+	ProfilerSources = nil
+	defer func() { ProfilerSources = prevSources }()
+
+	err = cloneProfilerSources(dstDir, defaultProfilerGoVersion)
+	if err != errMissingProfilerSources {
+		t.Fatalf("expected to get errMissingProfilerSources; got %v", err)
+	}
+}
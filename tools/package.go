@@ -1,22 +1,34 @@
 package tools
 
 import (
+	"encoding/json"
 	"fmt"
 	"go/ast"
+	"go/build"
 	"go/parser"
 	"go/printer"
 	"go/token"
+	"go/types"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strings"
 
 	"golang.org/x/tools/go/ssa"
 )
 
+const overlayFileName = "overlay.json"
+
 var (
 	stripCharRegex = regexp.MustCompile(`[()*]`)
+
+	// SSA names anonymous functions by appending $N to the name of the
+	// enclosing function. We convert these into the more readable .funcN
+	// notation used by the go runtime.
+	anonFuncRegex = regexp.MustCompile(`\$(\d+)`)
 )
 
 // PatchFunc is a function used to modify the AST for a go function matching a profile target. The
@@ -24,8 +36,9 @@ var (
 // and a list of additional package imports to be injected into the file where the target
 // is defined.
 //
-// The method is passed a callgraph node instance and the AST node that corresponds to its body.
-type PatchFunc func(cgNode *CallGraphNode, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string)
+// The method is passed a callgraph node instance and the AST nodes that correspond to its
+// signature and its body.
+type PatchFunc func(cgNode *CallGraphNode, fnType *ast.FuncType, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string)
 
 // PatchCmd groups together a list of targets and a patch function to apply to them.
 type PatchCmd struct {
@@ -36,6 +49,12 @@ type PatchCmd struct {
 	PatchFn PatchFunc
 }
 
+// goOverlay models the overlay file accepted by the -overlay flag of the go tool.
+type goOverlay struct {
+	// Maps the path of each original file to the path of its replacement.
+	Replace map[string]string
+}
+
 // Represents the contents of a parsed go file.
 type parsedGoFile struct {
 	// Path to the file.
@@ -62,14 +81,36 @@ type GoPackage struct {
 	// only contains functions that can be used as profile injection points.
 	ssaFuncCandidates map[string]*ssa.Function
 
-	// The GOPATH for loading package dependencies. We intentionally override it
-	// so that the workspace path where this package's sources exist is included first.
+	// The FQ names of the anonymous functions among the candidates indexed
+	// by their source position.
+	anonFuncNames map[funcPos]string
+
+	// The type-checked packages loaded for building the SSA representation
+	// indexed by their import path.
+	loadedPkgs map[string]*types.Package
+
+	// The GOPATH for loading package dependencies. For packages inside a go
+	// workspace we intentionally override it so that the workspace path where
+	// this package's sources exist is included first.
 	GOPATH string
+
+	// The root folder of the go module containing this package. If the
+	// package lives inside a go workspace this field will be empty.
+	ModuleRoot string
+
+	// If set, Patch and PatchOverlay also process the go test files of the
+	// package (but not its sub-packages). If the package tests do not define
+	// a TestMain function, a test file defining one is generated.
+	IncludeTests bool
 }
 
 // NewGoPackage analyzes all go files in pathToPackage as well as any other packages that are
 // referenced by them and constructs a static single-assignment representation of
 // the underlying code.
+//
+// If pathToPackage belongs to a go module, the package prefix is derived from
+// the module path defined in its go.mod file. Otherwise, pathToPackage is
+// expected to reside inside a go workspace.
 func NewGoPackage(pathToPackage string) (*GoPackage, error) {
 	// Detect FQN for project base package
 	fqPkgPrefix, err := qualifiedPkgName(pathToPackage)
@@ -77,11 +118,23 @@ func NewGoPackage(pathToPackage string) (*GoPackage, error) {
 		return nil, err
 	}
 
-	adjustedGoPath, err := adjustGoPath(pathToPackage)
+	mod, err := findModule(pathToPackage)
 	if err != nil {
 		return nil, err
 	}
 
+	var adjustedGoPath, moduleRoot string
+	if mod != nil {
+		// Dependencies are resolved by the go tool via the module cache
+		adjustedGoPath = build.Default.GOPATH
+		moduleRoot = mod.root
+	} else {
+		adjustedGoPath, err = adjustGoPath(pathToPackage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	candidates, err := ssaCandidates(pathToPackage, fqPkgPrefix, adjustedGoPath)
 	if err != nil {
 		return nil, err
@@ -91,7 +144,10 @@ func NewGoPackage(pathToPackage string) (*GoPackage, error) {
 		pathToPackage:     pathToPackage,
 		PkgPrefix:         fqPkgPrefix,
 		ssaFuncCandidates: candidates,
+		anonFuncNames:     anonFuncNames(candidates),
+		loadedPkgs:        loadedPackages(candidates),
 		GOPATH:            adjustedGoPath,
+		ModuleRoot:        moduleRoot,
 	}, nil
 }
 
@@ -120,32 +176,77 @@ func NewGoPackage(pathToPackage string) (*GoPackage, error) {
 // The injector targets for the two functions are defined as:
 //  github.com/geckoboard/foo/MoreStuff
 //  github.com/geckoboard/foo/Foo.DoStuff
+//
+// Targets may also be specified as glob patterns (e.g. github.com/geckoboard/foo/*.Do*)
+// or as regular expressions prefixed with "re:" (e.g. re:.*Foo\..*). Each
+// function matched by a pattern is returned as a separate ProfileTarget. If
+// a target does not match any function, Find returns an error listing the
+// closest candidate names.
 func (pkg *GoPackage) Find(targetList ...string) ([]ProfileTarget, error) {
-	profileTargets := make([]ProfileTarget, len(targetList))
-	var entrypointSSA *ssa.Function
-	for targetIndex, target := range targetList {
-		entrypointSSA = nil
-		for candidate, ssaFn := range pkg.ssaFuncCandidates {
-			if candidate == target {
-				entrypointSSA = ssaFn
-				break
-			}
+	candidateNames := make([]string, 0, len(pkg.ssaFuncCandidates))
+	for candidate := range pkg.ssaFuncCandidates {
+		candidateNames = append(candidateNames, candidate)
+	}
+
+	profileTargets := make([]ProfileTarget, 0, len(targetList))
+	seenTargets := make(map[string]struct{}, 0)
+	for _, target := range targetList {
+		matches, err := matchCandidates(target, candidateNames)
+		if err != nil {
+			return nil, fmt.Errorf("GoPackage.Find: invalid profile target: %s", err)
 		}
 
-		if entrypointSSA == nil {
-			return nil, fmt.Errorf("GoPackage.Find: no match for profile target %q", target)
+		if len(matches) == 0 {
+			closest := closestCandidates(target, candidateNames, maxSuggestedCandidates)
+			if len(closest) == 0 {
+				return nil, fmt.Errorf("GoPackage.Find: no match for profile target %q", target)
+			}
+			return nil, fmt.Errorf("GoPackage.Find: no match for profile target %q; closest candidates: %s", target, strings.Join(closest, ", "))
 		}
 
-		profileTargets[targetIndex] = ProfileTarget{
-			QualifiedName: target,
-			PkgPrefix:     pkg.PkgPrefix,
-			ssaFunc:       entrypointSSA,
+		for _, match := range matches {
+			// Skip functions already matched by a previous target
+			if _, seen := seenTargets[match]; seen {
+				continue
+			}
+			seenTargets[match] = struct{}{}
+
+			profileTargets = append(profileTargets, ProfileTarget{
+				QualifiedName: match,
+				PkgPrefix:     pkg.PkgPrefix,
+				ssaFunc:       pkg.ssaFuncCandidates[match],
+			})
 		}
 	}
 
 	return profileTargets, nil
 }
 
+// Candidates returns the sorted list of FQ function names that can be used as
+// profile targets. If filter is not empty, only names matching it are returned.
+// The filter uses the same syntax as the targets passed to Find with the
+// exception that plain strings are matched as substrings of the candidate names.
+func (pkg *GoPackage) Candidates(filter string) ([]string, error) {
+	candidateNames := make([]string, 0, len(pkg.ssaFuncCandidates))
+	for candidate := range pkg.ssaFuncCandidates {
+		if filter == "" || isTargetPattern(filter) || strings.Contains(candidate, filter) {
+			candidateNames = append(candidateNames, candidate)
+		}
+	}
+
+	if filter == "" || !isTargetPattern(filter) {
+		sort.Strings(candidateNames)
+		return candidateNames, nil
+	}
+
+	matches, err := matchCandidates(filter, candidateNames)
+	if err != nil {
+		return nil, fmt.Errorf("GoPackage.Candidates: invalid filter: %s", err)
+	}
+
+	return matches, nil
+}
+
 // Patch iterates the list of go source files that comprise this package and any folder
 // defined inside it and applies the patch function to AST entries matching the given
 // list of targets.
@@ -153,16 +254,105 @@ func (pkg *GoPackage) Find(targetList ...string) ([]ProfileTarget, error) {
 // This function will automatically overwrite any files that are modified by the
 // given patch function.
 func (pkg *GoPackage) Patch(vendorPkgRegex []string, patchCmds ...PatchCmd) (updatedFiles int, patchCount int, err error) {
+	return pkg.patch(
+		vendorPkgRegex,
+		patchCmds,
+		func(parsedFile *parsedGoFile) error {
+			return writeParsedFile(parsedFile.filePath, parsedFile)
+		},
+	)
+}
+
+// PatchOverlay works like Patch but leaves the package sources untouched. Instead,
+// any modified files are written to overlayDir and an overlay file mapping the
+// original file paths to the modified copies is generated inside overlayDir.
+// The returned overlay file path can be passed to the -overlay flag of the go
+// tool to build the patched package without copying its sources.
+//
+// If the package belongs to a go module, the overlay will also include a
+// modified go.mod file so that the profiler imports can be resolved.
+func (pkg *GoPackage) PatchOverlay(overlayDir string, vendorPkgRegex []string, patchCmds ...PatchCmd) (overlayFile string, updatedFiles int, patchCount int, err error) {
+	overlayDir, err = filepath.Abs(overlayDir)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	overlay := &goOverlay{
+		Replace: make(map[string]string, 0),
+	}
+
+	updatedFiles, patchCount, err = pkg.patch(
+		vendorPkgRegex,
+		patchCmds,
+		func(parsedFile *parsedGoFile) error {
+			srcPath, err := filepath.Abs(parsedFile.filePath)
+			if err != nil {
+				return err
+			}
+
+			dstPath := filepath.Join(overlayDir, srcPath)
+			err = os.MkdirAll(filepath.Dir(dstPath), os.ModeDir|os.ModePerm)
+			if err != nil {
+				return err
+			}
+
+			overlay.Replace[srcPath] = dstPath
+			return writeParsedFile(dstPath, parsedFile)
+		},
+	)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	if pkg.ModuleRoot != "" {
+		srcPath := filepath.Join(pkg.ModuleRoot, goModFile)
+		dstPath := filepath.Join(overlayDir, srcPath)
+		err = os.MkdirAll(filepath.Dir(dstPath), os.ModeDir|os.ModePerm)
+		if err != nil {
+			return "", 0, 0, err
+		}
+
+		err = patchModFile(srcPath, dstPath, pkg.ModuleRoot, filepath.Join(overlayDir, profilerCloneDir))
+		if err != nil {
+			return "", 0, 0, err
+		}
+		overlay.Replace[srcPath] = dstPath
+	}
+
+	overlayFile = filepath.Join(overlayDir, overlayFileName)
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	err = ioutil.WriteFile(overlayFile, data, os.ModePerm)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	return overlayFile, updatedFiles, patchCount, nil
+}
+
+// Apply the patch cmds to the package sources and invoke writeFn for each
+// file whose AST was modified.
+func (pkg *GoPackage) patch(vendorPkgRegex []string, patchCmds []PatchCmd, writeFn func(parsedFile *parsedGoFile) error) (updatedFiles int, patchCount int, err error) {
 	// Parse package sources
-	parsedFiles, err := parsePackageSources(pkg.pathToPackage, vendorPkgRegex)
+	parsedFiles, err := parsePackageSources(pkg.pathToPackage, pkg.PkgPrefix, vendorPkgRegex)
 	if err != nil {
 		return 0, 0, err
 	}
 
+	if pkg.IncludeTests {
+		testFiles, err := parseTestSources(pkg.pathToPackage, pkg.PkgPrefix)
+		if err != nil {
+			return 0, 0, err
+		}
+		parsedFiles = append(parsedFiles, testFiles...)
+	}
+
 	// Expand the callgraph of hook targets and generate a visitor for each patch cmd
 	visitors := make([]*funcVisitor, len(patchCmds))
 	for cmdIndex, cmd := range patchCmds {
-		visitors[cmdIndex] = newFuncVisitor(uniqueTargetMap(cmd.Targets), cmd.PatchFn)
+		visitors[cmdIndex] = newFuncVisitor(uniqueTargetMap(cmd.Targets), pkg.anonFuncNames, pkg.loadedPkgs, cmd.PatchFn)
 	}
 
 	totalPatchCount := 0
@@ -178,12 +368,10 @@ func (pkg *GoPackage) Patch(vendorPkgRegex []string, patchCmds ...PatchCmd) (upd
 
 		// If the file was updated write it back to disk
 		if modifiedAST {
-			f, err := os.Create(parsedFile.filePath)
+			err = writeFn(parsedFile)
 			if err != nil {
 				return 0, 0, err
 			}
-			printer.Fprint(f, parsedFile.fset, parsedFile.astFile)
-			f.Close()
 			updatedFiles++
 		}
 	}
@@ -191,6 +379,17 @@ func (pkg *GoPackage) Patch(vendorPkgRegex []string, patchCmds ...PatchCmd) (upd
 	return updatedFiles, totalPatchCount, err
 }
 
+// Render the AST of a parsed file and write it to dstPath.
+func writeParsedFile(dstPath string, parsedFile *parsedGoFile) error {
+	f, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return printer.Fprint(f, parsedFile.fset, parsedFile.astFile)
+}
+
 // For each profile target, discover all reachable functions in its callgraph and
 // generate a map where keys are the FQ name of each callgraph node and values
 // are the callgraph nodes.
@@ -207,8 +406,9 @@ func uniqueTargetMap(targets []ProfileTarget) map[string]*CallGraphNode {
 }
 
 // Recursively scan pathToPackage and create an AST for any non-test go files
-// that are found.
-func parsePackageSources(pathToPackage string, vendorPkgRegex []string) ([]*parsedGoFile, error) {
+// that are found. The fully qualified package name for each file is constructed
+// by appending the file's folder path relative to pathToPackage to pkgPrefix.
+func parsePackageSources(pathToPackage, pkgPrefix string, vendorPkgRegex []string) ([]*parsedGoFile, error) {
 	var err error
 	pkgRegexes := make([]*regexp.Regexp, len(vendorPkgRegex))
 	for index, regex := range vendorPkgRegex {
@@ -250,10 +450,14 @@ func parsePackageSources(pathToPackage string, vendorPkgRegex []string) ([]*pars
 			return fmt.Errorf("in: could not parse %s; %v", path, err)
 		}
 
-		pkgName, err := qualifiedPkgName(path)
+		relDir, err := filepath.Rel(pathToPackage, filepath.Dir(path))
 		if err != nil {
 			return err
 		}
+		pkgName := pkgPrefix
+		if relDir != "." {
+			pkgName += "/" + filepath.ToSlash(relDir)
+		}
 
 		parsedFiles = append(parsedFiles, &parsedGoFile{
 			pkgName:  pkgName,
@@ -1,8 +1,10 @@
 package tools
 
 import (
+	"encoding/json"
 	"fmt"
 	"go/ast"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
@@ -37,6 +39,53 @@ func TestNewGoPackage(t *testing.T) {
 	}
 }
 
+func TestNewGoPackageForModule(t *testing.T) {
+	defer enableModuleMode()()
+	modDir, modName := mockModule(t)
+	defer os.RemoveAll(modDir)
+
+	pkg, err := NewGoPackage(modDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.PkgPrefix != modName {
+		t.Fatalf("expected PkgPrefix to be %q; got %q", modName, pkg.PkgPrefix)
+	}
+
+	expModuleRoot := strings.TrimSuffix(modDir, "/")
+	if pkg.ModuleRoot != expModuleRoot {
+		t.Fatalf("expected ModuleRoot to be %q; got %q", expModuleRoot, pkg.ModuleRoot)
+	}
+
+	targetList, err := pkg.Find(modName + "/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummyPatchCmd := PatchCmd{
+		Targets: targetList,
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+			return true, nil
+		},
+	}
+	updatedFiles, patchCount, err := pkg.Patch(nil, dummyPatchCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The module sub-package should also be patched
+	expUpdatedFiles := 2
+	if updatedFiles != expUpdatedFiles {
+		t.Fatalf("expected Patch() to update %d files; got %d", expUpdatedFiles, updatedFiles)
+	}
+
+	expPatchCount := 4
+	if patchCount != expPatchCount {
+		t.Fatalf("expected Patch() to apply %d patches; got %d", expPatchCount, patchCount)
+	}
+}
+
 func TestFindTarget(t *testing.T) {
 	wsDir, pkgDir, pkgName := mockPackage(t)
 	defer os.RemoveAll(wsDir)
@@ -71,13 +120,114 @@ func TestFindMissingTarget(t *testing.T) {
 	}
 
 	invalidTarget := pkgName + "/missing.target"
-	expError := fmt.Sprintf("GoPackage.Find: no match for profile target %q", invalidTarget)
+	expError := fmt.Sprintf(
+		"GoPackage.Find: no match for profile target %q; closest candidates: %s",
+		invalidTarget,
+		strings.Join([]string{pkgName + "/init", pkgName + "/main", pkgName + "/A.DoStuff", pkgName + "/DoStuff"}, ", "),
+	)
 	_, err = pkg.Find(invalidTarget)
 	if err == nil || err.Error() != expError {
 		t.Fatalf("expected to get error %q; got: %v", expError, err)
 	}
 }
 
+func TestFindTargetPatterns(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	pkg, err := NewGoPackage(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		Targets    []string
+		ExpTargets []string
+	}{
+		{[]string{pkgName + "/*DoStuff"}, []string{pkgName + "/A.DoStuff", pkgName + "/DoStuff"}},
+		{[]string{pkgName + "/*.Do*"}, []string{pkgName + "/A.DoStuff"}},
+		{[]string{"re:.*/(main|init)"}, []string{pkgName + "/init", pkgName + "/main"}},
+		// Functions matched by multiple targets should only be returned once
+		{[]string{pkgName + "/DoStuff", "re:.*DoStuff"}, []string{pkgName + "/DoStuff", pkgName + "/A.DoStuff"}},
+	}
+
+	for specIndex, spec := range specs {
+		targetList, err := pkg.Find(spec.Targets...)
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if len(targetList) != len(spec.ExpTargets) {
+			t.Errorf("[spec %d] expected to get back %d targets; got %d", specIndex, len(spec.ExpTargets), len(targetList))
+			continue
+		}
+
+		for targetIndex, target := range targetList {
+			if target.QualifiedName != spec.ExpTargets[targetIndex] {
+				t.Errorf("[spec %d] expected target %d to be %q; got %q", specIndex, targetIndex, spec.ExpTargets[targetIndex], target.QualifiedName)
+			}
+		}
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	pkg, err := NewGoPackage(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		Filter        string
+		ExpCandidates []string
+	}{
+		{"", []string{pkgName + "/A.DoStuff", pkgName + "/DoStuff", pkgName + "/init", pkgName + "/main"}},
+		{"Stuff", []string{pkgName + "/A.DoStuff", pkgName + "/DoStuff"}},
+		{pkgName + "/*.Do*", []string{pkgName + "/A.DoStuff"}},
+		{"re:.*/(main|init)", []string{pkgName + "/init", pkgName + "/main"}},
+		{"no-match", []string{}},
+	}
+
+	for specIndex, spec := range specs {
+		candidates, err := pkg.Candidates(spec.Filter)
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if len(candidates) != len(spec.ExpCandidates) {
+			t.Errorf("[spec %d] expected to get back %d candidates; got %d", specIndex, len(spec.ExpCandidates), len(candidates))
+			continue
+		}
+
+		for index, candidate := range candidates {
+			if candidate != spec.ExpCandidates[index] {
+				t.Errorf("[spec %d] expected candidate %d to be %q; got %q", specIndex, index, spec.ExpCandidates[index], candidate)
+			}
+		}
+	}
+}
+
+func TestFindInvalidTargetPattern(t *testing.T) {
+	wsDir, pkgDir, _ := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	pkg, err := NewGoPackage(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"re:(unbalanced", "foo/[unbalanced"} {
+		_, err = pkg.Find(target)
+		if err == nil {
+			t.Errorf("expected to get an error for invalid target pattern %q", target)
+		}
+	}
+}
+
 func TestPatchPackageExcludingDeps(t *testing.T) {
 	wsDir, pkgDir, pkgName := mockPackage(t)
 	defer os.RemoveAll(wsDir)
@@ -99,7 +249,7 @@ func TestPatchPackageExcludingDeps(t *testing.T) {
 	vendorPkgRegex := []string{}
 	dummyPatchCmd := PatchCmd{
 		Targets: targetList,
-		PatchFn: func(_ *CallGraphNode, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 			return true, nil
 		},
 	}
@@ -120,6 +270,156 @@ func TestPatchPackageExcludingDeps(t *testing.T) {
 	}
 }
 
+func TestPatchOverlay(t *testing.T) {
+	wsDir, pkgDir, pkgName := mockPackage(t)
+	defer os.RemoveAll(wsDir)
+
+	overlayDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(overlayDir)
+
+	pkg, err := NewGoPackage(pkgDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetList, err := pkg.Find(pkgName + "/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcFile := pkgDir + "src.go"
+	origSrc, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummyPatchCmd := PatchCmd{
+		Targets: targetList,
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, fnDeclNode *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+			fnDeclNode.List = nil
+			return true, nil
+		},
+	}
+	overlayFile, updatedFiles, patchCount, err := pkg.PatchOverlay(overlayDir, nil, dummyPatchCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expUpdatedFiles := 1
+	if updatedFiles != expUpdatedFiles {
+		t.Fatalf("expected PatchOverlay() to update %d files; got %d", expUpdatedFiles, updatedFiles)
+	}
+
+	expPatchCount := 3
+	if patchCount != expPatchCount {
+		t.Fatalf("expected PatchOverlay() to apply %d patches; got %d", expPatchCount, patchCount)
+	}
+
+	// Original sources should be left untouched
+	src, err := ioutil.ReadFile(srcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(src) != string(origSrc) {
+		t.Fatal("expected PatchOverlay() not to modify the original package sources")
+	}
+
+	data, err := ioutil.ReadFile(overlayFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overlay goOverlay
+	err = json.Unmarshal(data, &overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(overlay.Replace) != expUpdatedFiles {
+		t.Fatalf("expected overlay to contain %d entries; got %d", expUpdatedFiles, len(overlay.Replace))
+	}
+
+	patchedFile, exists := overlay.Replace[srcFile]
+	if !exists {
+		t.Fatalf("expected overlay to contain an entry for %q; got %v", srcFile, overlay.Replace)
+	}
+	if !strings.HasPrefix(patchedFile, overlayDir) {
+		t.Fatalf("expected patched file %q to be stored inside %q", patchedFile, overlayDir)
+	}
+
+	patchedSrc, err := ioutil.ReadFile(patchedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(patchedSrc) == string(origSrc) {
+		t.Fatal("expected patched file contents to differ from the original file")
+	}
+}
+
+func TestPatchOverlayForModule(t *testing.T) {
+	defer enableModuleMode()()
+	modDir, modName := mockModule(t)
+	defer os.RemoveAll(modDir)
+
+	overlayDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(overlayDir)
+
+	pkg, err := NewGoPackage(modDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targetList, err := pkg.Find(modName + "/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dummyPatchCmd := PatchCmd{
+		Targets: targetList,
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+			return true, nil
+		},
+	}
+	overlayFile, _, _, err := pkg.PatchOverlay(overlayDir, nil, dummyPatchCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(overlayFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var overlay goOverlay
+	err = json.Unmarshal(data, &overlay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overlay should replace both source files and the go.mod file
+	expEntries := 3
+	if len(overlay.Replace) != expEntries {
+		t.Fatalf("expected overlay to contain %d entries; got %v", expEntries, overlay.Replace)
+	}
+
+	patchedMod, exists := overlay.Replace[modDir+goModFile]
+	if !exists {
+		t.Fatalf("expected overlay to contain an entry for go.mod; got %v", overlay.Replace)
+	}
+
+	data, err = ioutil.ReadFile(patchedMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), prismModulePath+" => ") {
+		t.Fatalf("expected patched go.mod to contain a replace directive for %q; got:\n%s", prismModulePath, string(data))
+	}
+}
+
 func TestPatchPackageIncludingGodeps(t *testing.T) {
 	wsDir, pkgDir, pkgName := mockPackageWithVendoredDeps(t, true)
 	defer os.RemoveAll(wsDir)
@@ -141,7 +441,7 @@ func TestPatchPackageIncludingGodeps(t *testing.T) {
 	vendorPkgRegex := []string{"other/pkg"}
 	dummyPatchCmd := PatchCmd{
 		Targets: targetList,
-		PatchFn: func(_ *CallGraphNode, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 			return true, nil
 		},
 	}
@@ -183,7 +483,7 @@ func TestPatchPackageIncludingVendorDeps(t *testing.T) {
 	vendorPkgRegex := []string{"other/pkg"}
 	dummyPatchCmd := PatchCmd{
 		Targets: targetList,
-		PatchFn: func(_ *CallGraphNode, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 			return true, nil
 		},
 	}
@@ -225,7 +525,7 @@ func TestPatchPackageWithInvalidVendorRegex(t *testing.T) {
 	vendorPkgRegex := []string{"other/pkg *****"}
 	dummyPatchCmd := PatchCmd{
 		Targets: targetList,
-		PatchFn: func(_ *CallGraphNode, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
+		PatchFn: func(_ *CallGraphNode, _ *ast.FuncType, _ *ast.BlockStmt) (modifiedAST bool, extraImports []string) {
 			return true, nil
 		},
 	}
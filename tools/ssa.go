@@ -3,6 +3,7 @@ package tools
 import (
 	"fmt"
 	"go/build"
+	"go/types"
 	"path/filepath"
 	"strings"
 
@@ -28,10 +29,15 @@ func ssaCandidates(pathToPackage, fqPkgPrefix, goPath string) (map[string]*ssa.F
 		return nil, err
 	}
 
+	// When running in module mode, go/build resolves imports by invoking
+	// the go tool which needs to be run from within the module folder.
+	buildCtx := build.Default
+	buildCtx.GOPATH = goPath
+	buildCtx.Dir = pathToPackage
+
 	var conf loader.Config
 	conf.CreateFromFilenames(fqPkgPrefix, goFiles...)
-	conf.Build = &build.Default
-	conf.Build.GOPATH = goPath
+	conf.Build = &buildCtx
 	conf.Cwd = pathToPackage
 	loadedProg, err := conf.Load()
 	if err != nil {
@@ -45,6 +51,12 @@ func ssaCandidates(pathToPackage, fqPkgPrefix, goPath string) (map[string]*ssa.F
 	// Build candidate map
 	candidates := make(map[string]*ssa.Function, 0)
 	for ssaFn := range ssautil.AllFunctions(ssaProg) {
+		// Skip instantiations of generic functions; the generic
+		// function they were instantiated from is used instead
+		if ssaFn.Origin() != nil {
+			continue
+		}
+
 		target := ssaQualifiedFuncName(ssaFn)
 		if strings.HasPrefix(string(target), fqPkgPrefix) {
 			candidates[target] = ssaFn
@@ -53,12 +65,51 @@ func ssaCandidates(pathToPackage, fqPkgPrefix, goPath string) (map[string]*ssa.F
 	return candidates, nil
 }
 
+// Index the FQ names of the anonymous functions among a set of SSA candidates
+// by their source position so that they can be matched to the function
+// literals in the AST of the package sources.
+func anonFuncNames(candidates map[string]*ssa.Function) map[funcPos]string {
+	names := make(map[funcPos]string, 0)
+	for fqName, ssaFn := range candidates {
+		if ssaFn.Parent() == nil || !ssaFn.Pos().IsValid() {
+			continue
+		}
+
+		names[makeFuncPos(ssaFn.Prog.Fset.Position(ssaFn.Pos()))] = fqName
+	}
+
+	return names
+}
+
+// Index the type-checked packages loaded by the SSA program of a set of
+// candidates by their import path.
+func loadedPackages(candidates map[string]*ssa.Function) map[string]*types.Package {
+	pkgs := make(map[string]*types.Package, 0)
+	for _, ssaFn := range candidates {
+		// All candidates belong to the same program
+		for _, ssaPkg := range ssaFn.Prog.AllPackages() {
+			pkgs[ssaPkg.Pkg.Path()] = ssaPkg.Pkg
+		}
+		break
+	}
+
+	return pkgs
+}
+
 // Generate fully qualified name for SSA function representation that includes
 // the name of the package. This is achieved by invoking the String() method on
 // the supplied SSA function and manipulating its output.
+//
+// Instantiations of generic functions are reported using the name of the
+// generic function they were instantiated from with any type parameters
+// omitted (e.g. both Set[int].Add and Set[string].Add map to Set.Add).
 func ssaQualifiedFuncName(fn *ssa.Function) string {
-	// Normalize fn.String() output by removing parenthesis and star operator
-	normalized := stripCharRegex.ReplaceAllString(fn.String(), "")
+	if origin := fn.Origin(); origin != nil {
+		fn = origin
+	}
+
+	// Normalize fn.String() output by removing type parameters, parenthesis and star operator
+	normalized := stripCharRegex.ReplaceAllString(stripTypeParams(fn.String()), "")
 
 	// The normalized string representation of the function concatenates
 	// the package name and the function name (incl. receiver) with a dot.
@@ -68,19 +119,66 @@ func ssaQualifiedFuncName(fn *ssa.Function) string {
 		pkgLen := len(pkgName)
 		normalized = normalized[0:pkgLen] + "/" + normalized[pkgLen+1:]
 	}
-	return normalized
+
+	// Convert anonymous function names (e.g. Handler$1) to Handler.func1
+	return anonFuncRegex.ReplaceAllString(normalized, ".func${1}")
 }
 
-// Construct fully qualified package name from a file path by stripping the
-// go workspace location from its absolute path representation.
+// Remove any bracketed type parameter or type argument lists from a function name.
+func stripTypeParams(name string) string {
+	if !strings.ContainsRune(name, '[') {
+		return name
+	}
+
+	buf := make([]byte, 0, len(name))
+	nesting := 0
+	for index := 0; index < len(name); index++ {
+		switch name[index] {
+		case '[':
+			nesting++
+		case ']':
+			nesting--
+		default:
+			if nesting == 0 {
+				buf = append(buf, name[index])
+			}
+		}
+	}
+
+	return string(buf)
+}
+
+// Construct fully qualified package name from a file path. If the path belongs
+// to a go module, the package name is constructed by appending the path
+// relative to the module root to the module path. Otherwise, the name is
+// constructed by stripping the go workspace location from its absolute path
+// representation.
 func qualifiedPkgName(pathToPackage string) (string, error) {
 	absPackageDir, err := filepath.Abs(filepath.Dir(pathToPackage))
 	if err != nil {
 		return "", err
 	}
 
-	skipLen := strings.Index(absPackageDir, "/src/") + 5
-	return absPackageDir[skipLen:], nil
+	mod, err := findModule(pathToPackage)
+	if err != nil {
+		return "", err
+	}
+	if mod != nil {
+		relPath, err := filepath.Rel(mod.root, absPackageDir)
+		if err != nil {
+			return "", err
+		}
+		if relPath == "." {
+			return mod.path, nil
+		}
+		return mod.path + "/" + filepath.ToSlash(relPath), nil
+	}
+
+	skipLen := strings.Index(absPackageDir, "/src/")
+	if skipLen == -1 {
+		return "", fmt.Errorf("%s is neither part of a go module nor located inside a go workspace", absPackageDir)
+	}
+	return absPackageDir[skipLen+5:], nil
 }
 
 // Get the go workspace location from an absolute package path.
@@ -91,5 +189,8 @@ func packageWorkspace(pathToPackage string) (string, error) {
 	}
 
 	skipLen := strings.Index(absPackageDir, "/src/")
+	if skipLen == -1 {
+		return "", fmt.Errorf("%s is not located inside a go workspace", absPackageDir)
+	}
 	return absPackageDir[:skipLen], nil
 }
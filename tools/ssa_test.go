@@ -3,6 +3,7 @@ package tools
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -32,6 +33,45 @@ func TestQualifiedPkgName(t *testing.T) {
 	}
 }
 
+func TestQualifiedPkgNameForModule(t *testing.T) {
+	modDir, modName := mockModule(t)
+	defer os.RemoveAll(modDir)
+
+	specs := []struct {
+		Path       string
+		ExpPkgName string
+	}{
+		{modDir, modName},
+		{modDir + "src.go", modName},
+		{modDir + "other/", modName + "/other"},
+		{modDir + "other/src.go", modName + "/other"},
+	}
+
+	for specIndex, spec := range specs {
+		pkgName, err := qualifiedPkgName(spec.Path)
+		if err != nil {
+			t.Fatalf("[spec %d] %s", specIndex, err)
+		}
+
+		if pkgName != spec.ExpPkgName {
+			t.Fatalf("[spec %d] expected qualified package name to be %q; got %q", specIndex, spec.ExpPkgName, pkgName)
+		}
+	}
+}
+
+func TestQualifiedPkgNameOutsideWorkspace(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	_, err = qualifiedPkgName(tmpDir + "/")
+	if err == nil {
+		t.Fatal("expected to get an error for a package outside a go module or workspace")
+	}
+}
+
 func TestPackageWorkspace(t *testing.T) {
 	_, pathToTestFile, _, ok := runtime.Caller(0)
 	if !ok {
@@ -84,6 +124,25 @@ func TestSSACandidates(t *testing.T) {
 	}
 }
 
+func TestStripTypeParams(t *testing.T) {
+	specs := []struct {
+		In     string
+		ExpOut string
+	}{
+		{"example.com/foo.DoStuff", "example.com/foo.DoStuff"},
+		{"(*example.com/foo.Set[T]).Add", "(*example.com/foo.Set).Add"},
+		{"(*example.com/foo.Pair[string, []int]).Key[string []int]", "(*example.com/foo.Pair).Key"},
+		{"example.com/foo.Map[map[string]int example.com/bar.Baz]", "example.com/foo.Map"},
+	}
+
+	for specIndex, spec := range specs {
+		out := stripTypeParams(spec.In)
+		if out != spec.ExpOut {
+			t.Errorf("[spec %d] expected output to be %q; got %q", specIndex, spec.ExpOut, out)
+		}
+	}
+}
+
 func mockPackage(t *testing.T) (workspaceDir, pkgDir, pkgName string) {
 	pkgName = "prism-mock"
 	otherPkgName := "other"
@@ -212,3 +271,81 @@ func main(){
 	pkgDir = workspaceDir + "/src/" + pkgName + "/"
 	return workspaceDir, pkgDir, pkgName
 }
+
+func mockModule(t *testing.T) (modDir, pkgName string) {
+	pkgName = "example.com/prism-mock"
+	pkgData := map[string]string{
+		"go.mod": `
+module ` + pkgName + `
+
+go 1.16
+`,
+		"other/src.go": `
+package other
+
+func DoStuff(){
+}
+	`,
+		"src.go": `
+package main
+
+import other "` + pkgName + `/other"
+
+type A struct {
+}
+
+func(a *A) DoStuff(){
+	// Call to module sub-package
+	other.DoStuff()
+}
+
+func DoStuff(){
+	a := &A{}
+	a.DoStuff()
+
+	// The callgraph generator should not visit this function a second time
+	a.DoStuff()
+}
+
+func main(){
+	DoStuff()
+}
+`,
+	}
+
+	modDir, err := ioutil.TempDir("", "prism-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modDir += "/"
+
+	for name, src := range pkgData {
+		err = os.MkdirAll(filepath.Dir(modDir+name), os.ModeDir|os.ModePerm)
+		if err != nil {
+			os.RemoveAll(modDir)
+			t.Fatalf("error creating module folder for file %q: %s", name, err)
+		}
+
+		err = ioutil.WriteFile(modDir+name, []byte(src), os.ModePerm)
+		if err != nil {
+			os.RemoveAll(modDir)
+			t.Fatalf("error creating module contents for file %q: %s", name, err)
+		}
+	}
+
+	return modDir, pkgName
+}
+
+// Enable module mode for the duration of a test and return a func for
+// restoring the previous GO111MODULE value.
+func enableModuleMode() func() {
+	prevValue, wasSet := os.LookupEnv("GO111MODULE")
+	os.Setenv("GO111MODULE", "on")
+	return func() {
+		if wasSet {
+			os.Setenv("GO111MODULE", prevValue)
+		} else {
+			os.Unsetenv("GO111MODULE")
+		}
+	}
+}
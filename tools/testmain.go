@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// The name of the file generated for packages without a TestMain function.
+	testMainFileName = "prism_main_test.go"
+
+	// The name of the test entrypoint function.
+	testMainFuncName = "TestMain"
+
+	// The suffix used by external test packages.
+	externalTestPkgSuffix = "_test"
+)
+
+// A template for generating a TestMain function. The %s verb is replaced
+// by the name of the tested package.
+var testMainTemplate = `package %s
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+`
+
+// TestMainTargets returns the profile targets for bootstrapping the profiler
+// from the TestMain function of the package tests. The TestMain function may
+// be defined either by the package itself or by its external test package.
+// These targets are only matched if IncludeTests is set.
+func (pkg *GoPackage) TestMainTargets() []ProfileTarget {
+	return []ProfileTarget{
+		ProfileTarget{
+			QualifiedName: pkg.PkgPrefix + "/" + testMainFuncName,
+			PkgPrefix:     pkg.PkgPrefix,
+		},
+		ProfileTarget{
+			QualifiedName: pkg.PkgPrefix + externalTestPkgSuffix + "/" + testMainFuncName,
+			PkgPrefix:     pkg.PkgPrefix,
+		},
+	}
+}
+
+// Parse the go test files located in pathToPackage. Sub-packages are not
+// scanned. Files belonging to an external test package are assigned the
+// pkgPrefix + "_test" package name so that their functions cannot be mistaken
+// for functions of the tested package.
+//
+// If none of the test files defines a TestMain function, a file containing
+// a TestMain function that simply runs the package tests is generated.
+func parseTestSources(pathToPackage, pkgPrefix string) ([]*parsedGoFile, error) {
+	testFiles, err := filepath.Glob(filepath.Join(pathToPackage, "*_test.go"))
+	if err != nil {
+		return nil, err
+	}
+
+	parsedFiles := make([]*parsedGoFile, 0, len(testFiles)+1)
+	hasTestMain := false
+	for _, path := range testFiles {
+		fset := token.NewFileSet()
+		f, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return nil, fmt.Errorf("in: could not parse %s; %v", path, err)
+		}
+
+		pkgName := pkgPrefix
+		if strings.HasSuffix(f.Name.Name, externalTestPkgSuffix) {
+			pkgName += externalTestPkgSuffix
+		}
+
+		parsedFiles = append(parsedFiles, &parsedGoFile{
+			pkgName:  pkgName,
+			filePath: path,
+			fset:     fset,
+			astFile:  f,
+		})
+
+		hasTestMain = hasTestMain || definesTestMain(f)
+	}
+
+	if hasTestMain {
+		return parsedFiles, nil
+	}
+
+	// Generate a TestMain for the tested package
+	goPkgName, err := packageClauseName(pathToPackage)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(pathToPackage, testMainFileName)
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, fmt.Sprintf(testMainTemplate, goPkgName), parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(parsedFiles, &parsedGoFile{
+		pkgName:  pkgPrefix,
+		filePath: path,
+		fset:     fset,
+		astFile:  f,
+	}), nil
+}
+
+// Check whether a parsed file defines a TestMain function.
+func definesTestMain(f *ast.File) bool {
+	for _, decl := range f.Decls {
+		if fnDecl, isFnDecl := decl.(*ast.FuncDecl); isFnDecl && fnDecl.Recv == nil && fnDecl.Name.Name == testMainFuncName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the name of the go package defined by the non-test files in pathToPackage.
+func packageClauseName(pathToPackage string) (string, error) {
+	goFiles, err := filepath.Glob(filepath.Join(pathToPackage, "*.go"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, path := range goFiles {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.PackageClauseOnly)
+		if err != nil {
+			return "", fmt.Errorf("in: could not parse %s; %v", path, err)
+		}
+		return f.Name.Name, nil
+	}
+
+	return "", fmt.Errorf("could not find any go files in %s", pathToPackage)
+}
@@ -0,0 +1,88 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseTestSources(t *testing.T) {
+	specs := []struct {
+		TestSrc        map[string]string
+		ExpPkgNames    []string
+		ExpGenTestMain bool
+	}{
+		// No test files
+		{
+			TestSrc:        map[string]string{},
+			ExpPkgNames:    []string{"prism-mock"},
+			ExpGenTestMain: true,
+		},
+		// Internal and external test files without a TestMain
+		{
+			TestSrc: map[string]string{
+				"a_test.go": "package foo\n\nfunc TestA(t *testing.T){}\n",
+				"b_test.go": "package foo_test\n\nfunc TestB(t *testing.T){}\n",
+			},
+			ExpPkgNames:    []string{"prism-mock", "prism-mock_test", "prism-mock"},
+			ExpGenTestMain: true,
+		},
+		// External test package defining a TestMain
+		{
+			TestSrc: map[string]string{
+				"a_test.go": "package foo\n\nfunc TestA(t *testing.T){}\n",
+				"b_test.go": "package foo_test\n\nfunc TestMain(m *testing.M){}\n",
+			},
+			ExpPkgNames: []string{"prism-mock", "prism-mock_test"},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		pkgDir, err := ioutil.TempDir("", "prism-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(pkgDir)
+
+		spec.TestSrc["src.go"] = "package foo\n"
+		for name, src := range spec.TestSrc {
+			err = ioutil.WriteFile(pkgDir+"/"+name, []byte(src), os.ModePerm)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		parsedFiles, err := parseTestSources(pkgDir, "prism-mock")
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if len(parsedFiles) != len(spec.ExpPkgNames) {
+			t.Errorf("[spec %d] expected to parse %d files; got %d", specIndex, len(spec.ExpPkgNames), len(parsedFiles))
+			continue
+		}
+
+		for index, parsedFile := range parsedFiles {
+			if parsedFile.pkgName != spec.ExpPkgNames[index] {
+				t.Errorf("[spec %d] expected file %d package name to be %q; got %q", specIndex, index, spec.ExpPkgNames[index], parsedFile.pkgName)
+			}
+		}
+
+		genFile := parsedFiles[len(parsedFiles)-1]
+		isGenerated := genFile.filePath == pkgDir+"/"+testMainFileName
+		if isGenerated != spec.ExpGenTestMain {
+			t.Errorf("[spec %d] expected TestMain generation to be %t; got %t", specIndex, spec.ExpGenTestMain, isGenerated)
+			continue
+		}
+
+		if isGenerated {
+			if genFile.astFile.Name.Name != "foo" {
+				t.Errorf("[spec %d] expected generated file package clause to be %q; got %q", specIndex, "foo", genFile.astFile.Name.Name)
+			}
+			if !definesTestMain(genFile.astFile) {
+				t.Errorf("[spec %d] expected generated file to define a TestMain function", specIndex)
+			}
+		}
+	}
+}